@@ -21,6 +21,7 @@ func (a *App) registerRoutes(r chi.Router) {
 	r.Route("/api/scraper", a.mapScraperRoutes)
 	r.Route("/api/chat", a.mapChatRoutes)
 	r.Route("/api/documents", a.mapDocumentRoutes)
+	r.Route("/api/knowledge", a.mapKnowledgeRoutes)
 	r.Route("/api/widget", a.mapWidgetRoutes)
 	r.Route("/api/projects", a.mapProjectRoutes)
 	r.Route("/api/chatbots", a.mapChatbotRoutes)
@@ -70,7 +71,9 @@ func (a *App) mapScraperRoutes(r chi.Router) {
 	r.Get("/sources", a.auth(a.ctrl.GetSources))
 	r.Get("/stats", a.auth(a.ctrl.SourceStats))
 	r.Get("/jobs/{id}", a.auth(a.ctrl.GetScrapeJob))
+	r.Post("/jobs/{id}/cancel", a.auth(a.ctrl.CancelScrapeJob))
 	r.Post("/sources", a.auth(a.ctrl.Scrape))
+	r.Put("/sources/refresh", a.auth(a.ctrl.UpdateSourceRefresh))
 	r.Post("/retrain", a.auth(a.ctrl.Scrape))
 	r.Post("/query", a.auth(a.ctrl.QueryDocuments))
 	r.Delete("/sources", a.auth(a.ctrl.DeleteSource))
@@ -95,6 +98,15 @@ func (a *App) mapDocumentRoutes(r chi.Router) {
 	r.Delete("/{id}", a.auth(a.ctrl.DeleteDocument))
 }
 
+// Knowledge — curated answers and gaps in the knowledge base
+func (a *App) mapKnowledgeRoutes(r chi.Router) {
+	r.Get("/gaps", a.auth(a.ctrl.KnowledgeGaps))
+	r.Get("/qa", a.auth(a.ctrl.ListCuratedAnswers))
+	r.Post("/qa", a.auth(a.ctrl.CreateCuratedAnswer))
+	r.Put("/qa/{id}", a.auth(a.ctrl.UpdateCuratedAnswer))
+	r.Delete("/qa/{id}", a.auth(a.ctrl.DeleteCuratedAnswer))
+}
+
 // Widget
 func (a *App) mapWidgetRoutes(r chi.Router) {
 	r.Post("/", a.auth(a.ctrl.CreateWidget))
@@ -109,6 +121,17 @@ func (a *App) mapWidgetRoutes(r chi.Router) {
 	r.Put("/navigation", a.auth(a.ctrl.UpdateNavigation))
 	r.Get("/branding", a.auth(a.ctrl.GetBranding))
 	r.Put("/branding", a.auth(a.ctrl.UpdateBranding))
+	r.Get("/retrieval", a.auth(a.ctrl.GetRetrieval))
+	r.Put("/retrieval", a.auth(a.ctrl.UpdateRetrieval))
+	r.Get("/ai", a.auth(a.ctrl.GetAISettings))
+	r.Put("/ai", a.auth(a.ctrl.UpdateAISettings))
+	r.Get("/tools", a.auth(a.ctrl.GetChatTools))
+	r.Put("/tools", a.auth(a.ctrl.UpdateChatTools))
+	r.Get("/guardrails", a.auth(a.ctrl.GetGuardrails))
+	r.Put("/guardrails", a.auth(a.ctrl.UpdateGuardrails))
+	r.Get("/guardrails/events", a.auth(a.ctrl.GuardrailEvents))
+	r.Get("/suggestions", a.auth(a.ctrl.GetSuggestions))
+	r.Put("/suggestions", a.auth(a.ctrl.UpdateSuggestions))
 }
 
 // Projects and chatbots (currently mapped to per-user widget resources)
@@ -152,6 +175,7 @@ func (a *App) mapAdminRoutes(r chi.Router) {
 	r.Post("/actions/reset-usage", a.adminRoles(a.ctrl.AdminResetUsage, "super_admin", "admin"))
 	r.Post("/actions/force-logout", a.adminRoles(a.ctrl.AdminForceLogout, "super_admin", "admin"))
 	r.Post("/actions/set-plan", a.adminRoles(a.ctrl.AdminSetPlan, "super_admin"))
+	r.Post("/actions/set-spend-cap", a.adminRoles(a.ctrl.AdminSetSpendCap, "super_admin", "admin"))
 }
 
 // Inbox — hybrid AI+human handoff
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"konvoq-backend/controller"
+	"konvoq-backend/platform/chunker"
+)
+
+// goldenSet is an evaluation file. YAML and JSON files hold the whole set;
+// JSONL files hold one question per line and take the user from -user.
+type goldenSet struct {
+	UserID   string         `yaml:"userId" json:"userId"`
+	Chunking goldenChunking `yaml:"chunking" json:"chunking"`
+	// Retrieval replaces the user's widget retrieval settings, in the same
+	// shape as PUT /api/widget/retrieval; unset fields use the defaults.
+	Retrieval map[string]interface{} `yaml:"retrieval" json:"retrieval"`
+	Documents []goldenDocument       `yaml:"documents" json:"documents"`
+	Questions []goldenQuestion       `yaml:"questions" json:"questions"`
+}
+
+// goldenChunking overrides the chunker defaults for the documents.
+type goldenChunking struct {
+	MaxTokens     int  `yaml:"maxTokens" json:"maxTokens"`
+	OverlapTokens *int `yaml:"overlapTokens" json:"overlapTokens"`
+}
+
+func (c goldenChunking) options() chunker.Options {
+	return chunker.Options{MaxTokens: c.MaxTokens, OverlapTokens: c.OverlapTokens}
+}
+
+// goldenDocument is a page indexed before the questions run in -stub mode.
+type goldenDocument struct {
+	URL   string `yaml:"url" json:"url"`
+	Title string `yaml:"title" json:"title"`
+	Text  string `yaml:"text" json:"text"`
+}
+
+// goldenQuestion is one question with what a good answer looks like. Either
+// list may be empty; a question is only scored on the lists it has.
+type goldenQuestion struct {
+	ID               string   `yaml:"id" json:"id"`
+	Question         string   `yaml:"question" json:"question"`
+	ExpectedSources  []string `yaml:"expectedSources" json:"expectedSources"`
+	ExpectedKeywords []string `yaml:"expectedKeywords" json:"expectedKeywords"`
+}
+
+func loadGoldenSet(path string) (goldenSet, error) {
+	var set goldenSet
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return set, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl":
+		scanner := bufio.NewScanner(bytes.NewReader(raw))
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			var q goldenQuestion
+			if err := json.Unmarshal([]byte(text), &q); err != nil {
+				return set, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			set.Questions = append(set.Questions, q)
+		}
+		if err := scanner.Err(); err != nil {
+			return set, err
+		}
+	case ".yaml", ".yml", ".json":
+		// JSON is valid YAML, so one decoder covers both.
+		if err := yaml.Unmarshal(raw, &set); err != nil {
+			return set, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return set, fmt.Errorf("%s: unsupported golden file type (want .yaml, .yml, .json or .jsonl)", path)
+	}
+	return set, set.validate()
+}
+
+func (s *goldenSet) validate() error {
+	if len(s.Questions) == 0 {
+		return fmt.Errorf("golden set has no questions")
+	}
+	if err := s.Chunking.options().Validate(); err != nil {
+		return fmt.Errorf("chunking: %w", err)
+	}
+	for i := range s.Questions {
+		q := &s.Questions[i]
+		q.Question = strings.TrimSpace(q.Question)
+		if q.Question == "" {
+			return fmt.Errorf("question %d is empty", i+1)
+		}
+		if q.ID == "" {
+			q.ID = fmt.Sprintf("q%d", i+1)
+		}
+	}
+	for i, d := range s.Documents {
+		if strings.TrimSpace(d.URL) == "" || strings.TrimSpace(d.Text) == "" {
+			return fmt.Errorf("document %d needs a url and text", i+1)
+		}
+	}
+	return nil
+}
+
+func (s *goldenSet) evalOptions() (controller.EvalOptions, error) {
+	var opts controller.EvalOptions
+	if len(s.Retrieval) == 0 {
+		return opts, nil
+	}
+	raw, err := json.Marshal(s.Retrieval)
+	if err != nil {
+		return opts, fmt.Errorf("retrieval: %w", err)
+	}
+	settings, err := controller.EvalRetrievalSettings(raw)
+	if err != nil {
+		return opts, fmt.Errorf("retrieval: %w", err)
+	}
+	opts.Retrieval = &settings
+	return opts, nil
+}
+
+func (s *goldenSet) evalDocuments() []controller.EvalDocument {
+	docs := make([]controller.EvalDocument, 0, len(s.Documents))
+	for _, d := range s.Documents {
+		docs = append(docs, controller.EvalDocument{URL: strings.TrimSpace(d.URL), Title: d.Title, Text: d.Text})
+	}
+	return docs
+}
+
+// normalizeSourceURL makes expected and retrieved URLs comparable: scheme and
+// host are lowercased and fragments, query strings and trailing slashes are
+// dropped.
+func normalizeSourceURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := neturl.Parse(raw)
+	if err != nil || u.Host == "" {
+		return strings.TrimRight(strings.ToLower(raw), "/")
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	return strings.ToLower(u.Scheme) + "://" + host + strings.TrimRight(u.Path, "/")
+}
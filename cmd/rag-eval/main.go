@@ -0,0 +1,200 @@
+// Command rag-eval scores retrieval and answers against a golden question set.
+//
+// Each question runs through the same curated-answer, retrieval and
+// generation path as chat. The report gives retrieval recall and hit rate
+// against the expected source URLs and keyword coverage of the answers, as a
+// table and optionally as JSON.
+//
+//	go run ./cmd/rag-eval -golden eval.yaml -user <user id>
+//	go run ./cmd/rag-eval -golden eval.yaml -stub -json report.json -min-recall 0.8
+//
+// With -stub the fake LLM and embedder and the in-memory vector store are
+// used, and the golden file's documents are indexed first, so no API keys,
+// database or Redis are needed.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"konvoq-backend/config"
+	"konvoq-backend/controller"
+	"konvoq-backend/platform/db"
+	applog "konvoq-backend/platform/logger"
+	"konvoq-backend/platform/rediscache"
+	"konvoq-backend/platform/vectorstore"
+)
+
+// stubUserID owns the documents indexed in -stub mode when no user is given.
+const stubUserID = "00000000-0000-4000-8000-000000000000"
+
+func main() {
+	goldenPath := flag.String("golden", "", "golden question set (.yaml, .yml, .json or .jsonl)")
+	userID := flag.String("user", "", "user whose knowledge base is evaluated (overrides userId in the golden file)")
+	stub := flag.Bool("stub", false, "use the fake LLM, fake embedder and in-memory vector store")
+	jsonPath := flag.String("json", "", "write the JSON report to this file (\"-\" for stdout)")
+	verbose := flag.Bool("v", false, "log at the configured LOG_LEVEL instead of errors only")
+	timeout := flag.Duration("timeout", time.Minute, "time limit per question")
+	minRecall := flag.Float64("min-recall", 0, "exit non-zero if retrieval recall is below this (0-1)")
+	minHitRate := flag.Float64("min-hit-rate", 0, "exit non-zero if the hit rate is below this (0-1)")
+	minCoverage := flag.Float64("min-coverage", 0, "exit non-zero if keyword coverage is below this (0-1)")
+	flag.Parse()
+
+	if *goldenPath == "" {
+		fatalf("-golden is required")
+	}
+	set, err := loadGoldenSet(*goldenPath)
+	if err != nil {
+		fatalf("load golden set: %v", err)
+	}
+	if *userID != "" {
+		set.UserID = *userID
+	}
+	opts, err := set.evalOptions()
+	if err != nil {
+		fatalf("load golden set: %v", err)
+	}
+	if set.UserID == "" {
+		if !*stub {
+			fatalf("-user is required unless -stub is set")
+		}
+		set.UserID = stubUserID
+	}
+
+	cfg := config.Load()
+	if *stub {
+		cfg.LLMProvider = "fake"
+		cfg.EmbeddingProvider = "fake"
+		cfg.VectorStore = vectorstore.BackendMemory
+	}
+	level := "error"
+	if *verbose {
+		level = cfg.LogLevel
+	}
+	logger := applog.New(applog.Config{
+		Service:     "konvoq-rag-eval",
+		Environment: cfg.Environment,
+		Level:       level,
+		Format:      cfg.LogFormat,
+		AddSource:   cfg.LogAddSource,
+		Color:       cfg.LogColor,
+	})
+	slog.SetDefault(logger)
+
+	database, err := db.Open(cfg.DBURL)
+	if err != nil {
+		if !*stub {
+			fatalf("database connection failed: %v", err)
+		}
+		// Settings and curated answers are read from the database when it is
+		// reachable; otherwise every lookup fails and the defaults apply.
+		logger.Warn("database unavailable; using default settings", "error", err)
+		database, _ = sql.Open("pgx", cfg.DBURL)
+	}
+	defer database.Close()
+
+	// Stub runs skip the embedding cache so results never depend on vectors
+	// cached by an earlier run with a different embedder.
+	var cache *redis.Client
+	if !*stub {
+		if cache, err = rediscache.Open(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB); err != nil {
+			logger.Warn("redis unavailable; embedding cache disabled", "error", err)
+			cache = nil
+		} else {
+			defer cache.Close()
+		}
+	}
+
+	ctrl := controller.New(cfg, database, cache, logger)
+
+	if len(set.Documents) > 0 {
+		if *stub {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			err := ctrl.IndexEvalDocuments(ctx, set.UserID, set.evalDocuments(), set.Chunking.options())
+			cancel()
+			if err != nil {
+				fatalf("index documents: %v", err)
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, "rag-eval: documents are only indexed with -stub; evaluating the user's existing knowledge base")
+		}
+	}
+
+	rep := report{GeneratedAt: time.Now().UTC(), UserID: set.UserID, Stub: *stub}
+	for _, q := range set.Questions {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		start := time.Now()
+		res, err := ctrl.EvalAnswer(ctx, set.UserID, q.Question, opts)
+		cancel()
+		rep.Questions = append(rep.Questions, scoreQuestion(q, res, time.Since(start), err))
+	}
+	rep.Summary = summarize(rep.Questions)
+
+	var tableOut io.Writer = os.Stdout
+	if *jsonPath == "-" {
+		tableOut = os.Stderr
+	}
+	if err := writeTable(tableOut, rep); err != nil {
+		fatalf("write table: %v", err)
+	}
+	if *jsonPath != "" {
+		if err := writeJSON(*jsonPath, rep); err != nil {
+			fatalf("write json report: %v", err)
+		}
+	}
+
+	failed := false
+	for _, gate := range []struct {
+		name  string
+		min   float64
+		value *float64
+	}{
+		{"retrieval recall", *minRecall, rep.Summary.RetrievalRecall},
+		{"hit rate", *minHitRate, rep.Summary.HitRate},
+		{"keyword coverage", *minCoverage, rep.Summary.KeywordCoverage},
+	} {
+		if gate.min <= 0 {
+			continue
+		}
+		if gate.value == nil {
+			fmt.Fprintf(os.Stderr, "rag-eval: %s was not measured; no question has the expectations it needs\n", gate.name)
+			failed = true
+		} else if *gate.value < gate.min {
+			fmt.Fprintf(os.Stderr, "rag-eval: %s %.3f is below the minimum %.3f\n", gate.name, *gate.value, gate.min)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func writeJSON(path string, rep report) error {
+	out := os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "rag-eval: "+strings.TrimSuffix(format, "\n")+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"konvoq-backend/controller"
+)
+
+// questionReport is the scored outcome of one golden question. Recall and
+// KeywordCoverage are nil when the question has nothing to score them on.
+type questionReport struct {
+	ID              string                  `json:"id"`
+	Question        string                  `json:"question"`
+	Answer          string                  `json:"answer"`
+	Curated         bool                    `json:"curated"`
+	Fallback        bool                    `json:"fallback"`
+	Sources         []controller.EvalSource `json:"sources"`
+	MatchedSources  []string                `json:"matchedSources"`
+	MissingSources  []string                `json:"missingSources"`
+	Recall          *float64                `json:"recall"`
+	Hit             *bool                   `json:"hit"`
+	MatchedKeywords []string                `json:"matchedKeywords"`
+	MissingKeywords []string                `json:"missingKeywords"`
+	KeywordCoverage *float64                `json:"keywordCoverage"`
+	LatencyMs       int64                   `json:"latencyMs"`
+	Error           string                  `json:"error,omitempty"`
+}
+
+// summary averages each metric over the questions it applies to.
+type summary struct {
+	Questions       int      `json:"questions"`
+	Errors          int      `json:"errors"`
+	RetrievalRecall *float64 `json:"retrievalRecall"`
+	HitRate         *float64 `json:"hitRate"`
+	KeywordCoverage *float64 `json:"keywordCoverage"`
+	FallbackRate    float64  `json:"fallbackRate"`
+	CuratedAnswers  int      `json:"curatedAnswers"`
+	AvgLatencyMs    int64    `json:"avgLatencyMs"`
+	SourcesScored   int      `json:"sourcesScored"`
+	KeywordsScored  int      `json:"keywordsScored"`
+}
+
+type report struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	UserID      string           `json:"userId"`
+	Stub        bool             `json:"stub"`
+	Summary     summary          `json:"summary"`
+	Questions   []questionReport `json:"questions"`
+}
+
+// scoreQuestion compares one answer with its golden expectations. Sources
+// match on normalized URL; keywords match case-insensitively anywhere in the
+// answer.
+func scoreQuestion(q goldenQuestion, res controller.EvalResult, latency time.Duration, evalErr error) questionReport {
+	qr := questionReport{
+		ID:              q.ID,
+		Question:        q.Question,
+		Answer:          res.Answer,
+		Curated:         res.Curated,
+		Fallback:        res.Fallback,
+		Sources:         res.Sources,
+		MatchedSources:  []string{},
+		MissingSources:  []string{},
+		MatchedKeywords: []string{},
+		MissingKeywords: []string{},
+		LatencyMs:       latency.Milliseconds(),
+	}
+	if qr.Sources == nil {
+		qr.Sources = []controller.EvalSource{}
+	}
+	if evalErr != nil {
+		qr.Error = evalErr.Error()
+	}
+
+	retrieved := make(map[string]struct{}, len(res.Sources))
+	for _, s := range res.Sources {
+		retrieved[normalizeSourceURL(s.URL)] = struct{}{}
+	}
+	if len(q.ExpectedSources) > 0 {
+		for _, u := range q.ExpectedSources {
+			if _, ok := retrieved[normalizeSourceURL(u)]; ok {
+				qr.MatchedSources = append(qr.MatchedSources, u)
+			} else {
+				qr.MissingSources = append(qr.MissingSources, u)
+			}
+		}
+		recall := float64(len(qr.MatchedSources)) / float64(len(q.ExpectedSources))
+		hit := len(qr.MatchedSources) > 0
+		qr.Recall, qr.Hit = &recall, &hit
+	}
+
+	if len(q.ExpectedKeywords) > 0 {
+		answer := strings.ToLower(res.Answer)
+		for _, k := range q.ExpectedKeywords {
+			if strings.Contains(answer, strings.ToLower(strings.TrimSpace(k))) {
+				qr.MatchedKeywords = append(qr.MatchedKeywords, k)
+			} else {
+				qr.MissingKeywords = append(qr.MissingKeywords, k)
+			}
+		}
+		coverage := float64(len(qr.MatchedKeywords)) / float64(len(q.ExpectedKeywords))
+		qr.KeywordCoverage = &coverage
+	}
+	return qr
+}
+
+func summarize(questions []questionReport) summary {
+	s := summary{Questions: len(questions)}
+	var recallSum, coverageSum, hits float64
+	var latencySum int64
+	fallbacks := 0
+	for _, q := range questions {
+		if q.Error != "" {
+			s.Errors++
+		}
+		if q.Recall != nil {
+			s.SourcesScored++
+			recallSum += *q.Recall
+			if *q.Hit {
+				hits++
+			}
+		}
+		if q.KeywordCoverage != nil {
+			s.KeywordsScored++
+			coverageSum += *q.KeywordCoverage
+		}
+		if q.Fallback {
+			fallbacks++
+		}
+		if q.Curated {
+			s.CuratedAnswers++
+		}
+		latencySum += q.LatencyMs
+	}
+	if s.SourcesScored > 0 {
+		recall := recallSum / float64(s.SourcesScored)
+		hitRate := hits / float64(s.SourcesScored)
+		s.RetrievalRecall, s.HitRate = &recall, &hitRate
+	}
+	if s.KeywordsScored > 0 {
+		coverage := coverageSum / float64(s.KeywordsScored)
+		s.KeywordCoverage = &coverage
+	}
+	if s.Questions > 0 {
+		s.FallbackRate = float64(fallbacks) / float64(s.Questions)
+		s.AvgLatencyMs = latencySum / int64(s.Questions)
+	}
+	return s
+}
+
+func writeTable(w io.Writer, rep report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tRECALL\tHIT\tKEYWORDS\tFALLBACK\tCURATED\tLATENCY\tQUESTION")
+	for _, q := range rep.Questions {
+		hit := "-"
+		if q.Hit != nil {
+			hit = yesNo(*q.Hit)
+		}
+		question := q.Question
+		if q.Error != "" {
+			question = "ERROR: " + q.Error
+		} else if len([]rune(question)) > 60 {
+			question = string([]rune(question)[:57]) + "..."
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%dms\t%s\n",
+			q.ID, percent(q.Recall), hit, percent(q.KeywordCoverage), yesNo(q.Fallback), yesNo(q.Curated), q.LatencyMs, question)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	s := rep.Summary
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "questions\t%d\t(errors %d, curated %d)\n", s.Questions, s.Errors, s.CuratedAnswers)
+	fmt.Fprintf(tw, "retrieval recall\t%s\t(over %d questions)\n", percent(s.RetrievalRecall), s.SourcesScored)
+	fmt.Fprintf(tw, "hit rate\t%s\t(over %d questions)\n", percent(s.HitRate), s.SourcesScored)
+	fmt.Fprintf(tw, "keyword coverage\t%s\t(over %d questions)\n", percent(s.KeywordCoverage), s.KeywordsScored)
+	fmt.Fprintf(tw, "fallback rate\t%.1f%%\t\n", s.FallbackRate*100)
+	fmt.Fprintf(tw, "avg latency\t%dms\t\n", s.AvgLatencyMs)
+	return tw.Flush()
+}
+
+func percent(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", *v*100)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -45,8 +45,43 @@ type Config struct {
 	AdminBootstrapRole     string
 	EnableAutoMigration    bool
 
-	OpenAIAPIKey string
-	OpenAIModel  string
+	// LLMProvider selects the chat backend: openai, azure, anthropic,
+	// openai_compatible (vLLM, Ollama, ...) or fake. EmbeddingProvider
+	// defaults to the same backend (openai when the chat backend is anthropic).
+	LLMProvider       string
+	EmbeddingProvider string
+	OpenAIAPIKey      string
+	OpenAIModel       string
+	OpenAIBaseURL     string
+	EmbeddingModel    string
+
+	// ChatModel is the deployment chat model: LLM_MODEL when set, else the
+	// model configured for the selected provider (AnthropicModel for
+	// anthropic, OpenAIModel otherwise).
+	ChatModel string
+
+	// LLMModels lists the chat models widgets may select (widget_config.ai);
+	// it defaults to just the deployment model.
+	LLMModels []string
+
+	AzureOpenAIEndpoint            string
+	AzureOpenAIAPIKey              string
+	AzureOpenAIAPIVersion          string
+	AzureOpenAIChatDeployment      string
+	AzureOpenAIEmbeddingDeployment string
+
+	AnthropicAPIKey  string
+	AnthropicModel   string
+	AnthropicBaseURL string
+
+	LLMBaseURL string
+	LLMAPIKey  string
+
+	// VectorStore selects the retrieval index: pinecone (default), pgvector,
+	// which stores embeddings in the primary PostgreSQL database, or memory,
+	// an unpersisted in-process index for offline evaluation.
+	VectorStore     string
+	VectorDimension int
 
 	PineconeAPIKey      string
 	PineconeIndexName   string
@@ -213,6 +248,14 @@ func Load() Config {
 		adminBootstrapRole = "super_admin"
 	}
 	isProd := isProductionEnvironment(environment)
+	llmProvider := strings.ToLower(getEnv("LLM_PROVIDER", "openai"))
+	openAIModel := getEnv("OPENAI_MODEL", "gpt-4o-mini")
+	anthropicModel := getEnv("ANTHROPIC_MODEL", "claude-3-5-haiku-latest")
+	providerModel := openAIModel
+	if llmProvider == "anthropic" {
+		providerModel = anthropicModel
+	}
+	chatModel := getEnv("LLM_MODEL", providerModel)
 	corsAllowedOrigins := getEnvList("CORS_ALLOWED_ORIGINS", defaultCORSOrigins)
 
 	if isProd {
@@ -269,8 +312,30 @@ func Load() Config {
 		AdminBootstrapRole:     adminBootstrapRole,
 		EnableAutoMigration:    getEnvBool("AUTO_MIGRATE", false),
 
-		OpenAIAPIKey: getEnv("OPENAI_API_KEY", ""),
-		OpenAIModel:  getEnv("OPENAI_MODEL", "gpt-4o-mini"),
+		LLMProvider:       llmProvider,
+		EmbeddingProvider: strings.ToLower(getEnv("EMBEDDING_PROVIDER", "")),
+		OpenAIAPIKey:      getEnv("OPENAI_API_KEY", ""),
+		OpenAIModel:       openAIModel,
+		OpenAIBaseURL:     getEnv("OPENAI_BASE_URL", ""),
+		ChatModel:         chatModel,
+		LLMModels:         getEnvList("LLM_MODELS", []string{chatModel}),
+		EmbeddingModel:    getEnv("EMBEDDING_MODEL", "text-embedding-3-small"),
+
+		AzureOpenAIEndpoint:            getEnv("AZURE_OPENAI_ENDPOINT", ""),
+		AzureOpenAIAPIKey:              getEnv("AZURE_OPENAI_API_KEY", ""),
+		AzureOpenAIAPIVersion:          getEnv("AZURE_OPENAI_API_VERSION", "2024-06-01"),
+		AzureOpenAIChatDeployment:      getEnv("AZURE_OPENAI_CHAT_DEPLOYMENT", ""),
+		AzureOpenAIEmbeddingDeployment: getEnv("AZURE_OPENAI_EMBEDDING_DEPLOYMENT", ""),
+
+		AnthropicAPIKey:  getEnv("ANTHROPIC_API_KEY", ""),
+		AnthropicModel:   anthropicModel,
+		AnthropicBaseURL: getEnv("ANTHROPIC_BASE_URL", ""),
+
+		LLMBaseURL: getEnv("LLM_BASE_URL", ""),
+		LLMAPIKey:  getEnv("LLM_API_KEY", ""),
+
+		VectorStore:     strings.ToLower(getEnv("VECTOR_STORE", "pinecone")),
+		VectorDimension: getEnvInt("VECTOR_DIMENSION", 1536),
 
 		PineconeAPIKey:      getEnv("PINECONE_API_KEY", ""),
 		PineconeIndexName:   getEnv("PINECONE_INDEX_NAME", ""),
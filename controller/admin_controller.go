@@ -131,7 +131,51 @@ func (c *Controller) AdminInsights(w http.ResponseWriter, r *http.Request) {
 		}
 		plans[p] = cnt
 	}
-	utils.JSONOK(w, map[string]interface{}{"success": true, "insights": map[string]interface{}{"plans": plans}})
+
+	var promptTokens, completionTokens, costMicros int64
+	if err := c.db.QueryRow(`SELECT COALESCE(SUM(prompt_tokens),0),COALESCE(SUM(completion_tokens),0),COALESCE(SUM(cost_micros),0)
+		FROM llm_usage_daily WHERE usage_date >= date_trunc('month', CURRENT_DATE)::date`).Scan(&promptTokens, &completionTokens, &costMicros); err != nil {
+		c.logRequestWarn(r, "admin insights ai usage query failed", err)
+	}
+	topUsers := []map[string]interface{}{}
+	usageRows, err := c.db.Query(`SELECT u.id,u.email,u.plan_type,SUM(d.prompt_tokens+d.completion_tokens),SUM(d.cost_micros),u.ai_spend_cap_usd::float8
+		FROM llm_usage_daily d JOIN users u ON u.id=d.user_id
+		WHERE d.usage_date >= date_trunc('month', CURRENT_DATE)::date
+		GROUP BY u.id,u.email,u.plan_type,u.ai_spend_cap_usd
+		ORDER BY SUM(d.cost_micros) DESC,SUM(d.prompt_tokens+d.completion_tokens) DESC
+		LIMIT 20`)
+	if err != nil {
+		c.logRequestWarn(r, "admin insights ai usage top users query failed", err)
+	} else {
+		defer usageRows.Close()
+		for usageRows.Next() {
+			var id, email, plan string
+			var tokens, cost int64
+			var capUSD sql.NullFloat64
+			if err := usageRows.Scan(&id, &email, &plan, &tokens, &cost, &capUSD); err != nil {
+				c.logRequestWarn(r, "admin insights ai usage row scan failed", err)
+				continue
+			}
+			var capValue interface{}
+			if capUSD.Valid {
+				capValue = capUSD.Float64
+			}
+			topUsers = append(topUsers, map[string]interface{}{
+				"id": id, "email": email, "plan_type": plan, "tokens": tokens,
+				"costUSD": float64(cost) / 1e6, "monthlyCapUSD": capValue,
+			})
+		}
+	}
+
+	utils.JSONOK(w, map[string]interface{}{"success": true, "insights": map[string]interface{}{
+		"plans": plans,
+		"aiUsage": map[string]interface{}{
+			"promptTokens":     promptTokens,
+			"completionTokens": completionTokens,
+			"costUSD":          float64(costMicros) / 1e6,
+			"topUsers":         topUsers,
+		},
+	}})
 }
 
 func (c *Controller) AdminUsers(w http.ResponseWriter, r *http.Request) {
@@ -267,3 +311,34 @@ func (c *Controller) AdminSetPlan(w http.ResponseWriter, r *http.Request) {
 	}
 	utils.JSONOK(w, map[string]interface{}{"success": true})
 }
+
+// AdminSetSpendCap sets a user's monthly AI spend cap in USD; null removes it.
+func (c *Controller) AdminSetSpendCap(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		UserID        string   `json:"userId"`
+		MonthlyCapUSD *float64 `json:"monthlyCapUSD"`
+	}
+	if err := utils.DecodeJSON(r, &body); err != nil || strings.TrimSpace(body.UserID) == "" {
+		utils.JSONErr(w, http.StatusBadRequest, "userId is required")
+		return
+	}
+	var limit interface{}
+	if body.MonthlyCapUSD != nil {
+		if *body.MonthlyCapUSD < 0 || *body.MonthlyCapUSD > 99999999 {
+			utils.JSONErr(w, http.StatusBadRequest, "monthlyCapUSD must be between 0 and 99999999")
+			return
+		}
+		limit = *body.MonthlyCapUSD
+	}
+	result, err := c.db.Exec(`UPDATE users SET ai_spend_cap_usd=$2,updated_at=CURRENT_TIMESTAMP WHERE id=$1`, body.UserID, limit)
+	if err != nil {
+		c.logRequestError(r, "admin set spend cap update failed", err, "target_user_id", body.UserID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		utils.JSONErr(w, http.StatusNotFound, "user not found")
+		return
+	}
+	utils.JSONOK(w, map[string]interface{}{"success": true, "monthlyCapUSD": body.MonthlyCapUSD})
+}
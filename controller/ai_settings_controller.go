@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"konvoq-backend/platform/llm"
+	"konvoq-backend/utils"
+)
+
+// AISettings override the deployment's generation defaults per widget
+// (widget_config.ai). Zero values keep the default.
+type AISettings struct {
+	Model           string   `json:"model,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	MaxTokens       int      `json:"maxTokens,omitempty"`
+	FallbackMessage string   `json:"fallbackMessage,omitempty"`
+	// FallbackMessages holds translations of FallbackMessage by language code.
+	FallbackMessages map[string]string `json:"fallbackMessages,omitempty"`
+	// DefaultLanguage answers messages whose language cannot be detected and
+	// is taken to be the language of the knowledge base.
+	DefaultLanguage string `json:"defaultLanguage,omitempty"`
+
+	// language is the language the current message is answered in; see
+	// withLanguage.
+	language string
+}
+
+const (
+	minAnswerTokens = 64
+	// maxAnswerTokens bounds plans without their own cap (AnswerTokens == 0).
+	maxAnswerTokens         = 4096
+	maxTemperature          = 2.0
+	maxFallbackMessageChars = 500
+)
+
+func answerTokenCap(limits PlanLimits) int {
+	if limits.AnswerTokens > 0 {
+		return limits.AnswerTokens
+	}
+	return maxAnswerTokens
+}
+
+// selectableModels returns the chat models the plan may choose from; plans
+// without AI tuning only get the deployment default.
+func (c *Controller) selectableModels(limits PlanLimits) []string {
+	if !limits.HasAITuning || len(c.cfg.LLMModels) == 0 {
+		return []string{c.cfg.ChatModel}
+	}
+	return c.cfg.LLMModels
+}
+
+func (s AISettings) validate(limits PlanLimits, models []string) error {
+	if s.Model != "" && !slices.Contains(models, s.Model) {
+		return fmt.Errorf("model must be one of: %s", strings.Join(models, ", "))
+	}
+	if s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > maxTemperature) {
+		return fmt.Errorf("temperature must be between 0 and %g", maxTemperature)
+	}
+	if limit := answerTokenCap(limits); s.MaxTokens != 0 && (s.MaxTokens < minAnswerTokens || s.MaxTokens > limit) {
+		return fmt.Errorf("maxTokens must be between %d and %d", minAnswerTokens, limit)
+	}
+	if utf8.RuneCountInString(s.FallbackMessage) > maxFallbackMessageChars {
+		return fmt.Errorf("fallbackMessage must be at most %d characters", maxFallbackMessageChars)
+	}
+	for code, msg := range s.FallbackMessages {
+		if normalizeLanguage(code) != code {
+			return fmt.Errorf("fallbackMessages has unsupported language %q", code)
+		}
+		if utf8.RuneCountInString(msg) > maxFallbackMessageChars {
+			return fmt.Errorf("fallbackMessages.%s must be at most %d characters", code, maxFallbackMessageChars)
+		}
+	}
+	if s.DefaultLanguage != "" && normalizeLanguage(s.DefaultLanguage) != s.DefaultLanguage {
+		return fmt.Errorf("defaultLanguage %q is not supported", s.DefaultLanguage)
+	}
+	return nil
+}
+
+// apply copies the overrides onto a chat request.
+func (s AISettings) apply(req *llm.ChatRequest) {
+	if s.Model != "" {
+		req.Model = s.Model
+	}
+	if s.Temperature != nil {
+		req.Temperature = s.Temperature
+	}
+	if s.MaxTokens > 0 {
+		req.MaxTokens = s.MaxTokens
+	}
+}
+
+// fallbackAnswer is the reply used when the knowledge base has no answer, in
+// the answer language when a translation exists. A custom message without a
+// translation is used as written.
+func (s AISettings) fallbackAnswer() string {
+	if msg := s.FallbackMessages[s.language]; msg != "" {
+		return msg
+	}
+	if s.FallbackMessage != "" {
+		return s.FallbackMessage
+	}
+	if msg, ok := localizedFallbackAnswers[s.language]; ok {
+		return msg
+	}
+	return ragFallbackAnswer
+}
+
+func (c *Controller) GetAISettings(w http.ResponseWriter, r *http.Request, claims TokenClaims, user UserRecord) {
+	var cfgRaw []byte
+	err := c.db.QueryRow(`SELECT widget_config FROM widget_keys WHERE user_id=$1`, claims.UserID).Scan(&cfgRaw)
+	if err != nil {
+		utils.JSONErr(w, http.StatusNotFound, "widget not found")
+		return
+	}
+	limits := limitsForPlan(user.PlanType)
+	utils.JSONOK(w, map[string]interface{}{
+		"success":     true,
+		"ai":          c.aiSettingsFromConfig(cfgRaw, limits),
+		"hasAITuning": limits.HasAITuning,
+		"options": map[string]interface{}{
+			"models":          c.selectableModels(limits),
+			"defaultModel":    c.cfg.ChatModel,
+			"maxTemperature":  maxTemperature,
+			"minAnswerTokens": minAnswerTokens,
+			"maxAnswerTokens": answerTokenCap(limits),
+			"languages":       supportedLanguages,
+		},
+	})
+}
+
+func (c *Controller) UpdateAISettings(w http.ResponseWriter, r *http.Request, claims TokenClaims, user UserRecord) {
+	if err := c.RequireCSRF(r); err != nil {
+		utils.JSONErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+	var body AISettings
+	if err := utils.DecodeJSON(r, &body); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	body.Model = strings.TrimSpace(body.Model)
+	body.FallbackMessage = strings.TrimSpace(body.FallbackMessage)
+	body.DefaultLanguage = strings.ToLower(strings.TrimSpace(body.DefaultLanguage))
+	for code, msg := range body.FallbackMessages {
+		if msg = strings.TrimSpace(msg); msg == "" {
+			delete(body.FallbackMessages, code)
+		} else {
+			body.FallbackMessages[code] = msg
+		}
+	}
+	if body.Model == c.cfg.ChatModel {
+		body.Model = ""
+	}
+
+	limits := limitsForPlan(user.PlanType)
+	if !limits.HasAITuning && (body.Model != "" || body.Temperature != nil) {
+		utils.JSONErr(w, http.StatusPaymentRequired, "model and temperature settings require PRO plan or above")
+		return
+	}
+	if err := body.validate(limits, c.selectableModels(limits)); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	patch := map[string]interface{}{"ai": body}
+	patchJSON, _ := json.Marshal(patch)
+
+	var widgetKey string
+	err := c.db.QueryRow(`UPDATE widget_keys SET widget_config = widget_config || $2::jsonb, updated_at=CURRENT_TIMESTAMP WHERE user_id=$1 RETURNING widget_key`,
+		claims.UserID, string(patchJSON)).Scan(&widgetKey)
+	if err != nil {
+		c.logRequestError(r, "update ai settings failed", err, "user_id", claims.UserID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	_ = c.redis.Del(ctx, "widget:"+widgetKey).Err()
+
+	utils.JSONOK(w, map[string]interface{}{"success": true, "ai": body})
+}
+
+// aiSettingsFromConfig reads widget_config.ai and drops whatever the current
+// plan or model catalogue no longer allows (e.g. after a downgrade), field by
+// field so the rest of the settings survive.
+func (c *Controller) aiSettingsFromConfig(cfgRaw []byte, limits PlanLimits) AISettings {
+	var cfg struct {
+		AI AISettings `json:"ai"`
+	}
+	if err := json.Unmarshal(cfgRaw, &cfg); err != nil {
+		return AISettings{}
+	}
+	s := cfg.AI
+	if !limits.HasAITuning || !slices.Contains(c.selectableModels(limits), s.Model) {
+		s.Model = ""
+	}
+	if !limits.HasAITuning || (s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > maxTemperature)) {
+		s.Temperature = nil
+	}
+	if limit := answerTokenCap(limits); s.MaxTokens > limit {
+		s.MaxTokens = limit
+	} else if s.MaxTokens < 0 {
+		s.MaxTokens = 0
+	}
+	if utf8.RuneCountInString(s.FallbackMessage) > maxFallbackMessageChars {
+		s.FallbackMessage = ""
+	}
+	for code, msg := range s.FallbackMessages {
+		if normalizeLanguage(code) != code || utf8.RuneCountInString(msg) > maxFallbackMessageChars {
+			delete(s.FallbackMessages, code)
+		}
+	}
+	s.DefaultLanguage = normalizeLanguage(s.DefaultLanguage)
+	return s
+}
+
+// loadAISettings returns the generation settings used to answer for ownerID.
+// Plans with an answer-length cap get it applied even when nothing is stored.
+func (c *Controller) loadAISettings(ctx context.Context, ownerID string) AISettings {
+	var planType string
+	var cfgRaw []byte
+	err := c.db.QueryRowContext(ctx, `SELECT u.plan_type,w.widget_config
+		FROM widget_keys w JOIN users u ON u.id=w.user_id
+		WHERE w.user_id=$1`, ownerID).Scan(&planType, &cfgRaw)
+	limits := limitsForPlan(planType)
+	settings := AISettings{}
+	if err == nil {
+		settings = c.aiSettingsFromConfig(cfgRaw, limits)
+	}
+	if settings.MaxTokens == 0 {
+		settings.MaxTokens = limits.AnswerTokens
+	}
+	return settings
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	answerCacheTTL = 24 * time.Hour
+	// answerCacheMaxEntries bounds how many recent answers one lookup scans;
+	// each entry carries its question's embedding.
+	answerCacheMaxEntries = 100
+)
+
+// answerCache is the semantic cache of one widget's generated answers, opened
+// for a single question. Entries live under a key that includes the owner's
+// knowledge base version (bumped by every index write or delete) and the
+// widget's settings timestamp, so any change to either starts a fresh cache.
+type answerCache struct {
+	key      string
+	minScore float64
+	question string
+	language string
+	vector   []float64
+}
+
+// cachedAnswer is one entry of the cache list.
+type cachedAnswer struct {
+	Question  string         `json:"question"`
+	Language  string         `json:"language"`
+	Embedding []byte         `json:"embedding"` // encodeEmbedding
+	Answer    string         `json:"answer"`
+	Sources   []answerSource `json:"sources"`
+	FollowUps []string       `json:"followUps,omitempty"`
+	CachedAt  time.Time      `json:"cachedAt"`
+
+	score float64
+}
+
+func knowledgeVersionKey(ownerID string) string {
+	return "kbversion:" + ownerID
+}
+
+// openAnswerCache embeds the question and resolves the cache key. It returns
+// nil when the widget has the cache turned off or Redis or embeddings are
+// unavailable. The knowledge base version is read here, before retrieval, so
+// an answer built from content that changes mid-request is stored under the
+// old version and never served.
+func (c *Controller) openAnswerCache(ctx context.Context, ownerID string, widgetID int64, widgetUpdatedAt time.Time, question, language string) *answerCache {
+	question = strings.TrimSpace(question)
+	if c.redis == nil || c.embedder == nil || widgetID <= 0 || question == "" {
+		return nil
+	}
+	settings := c.loadRetrievalSettings(ctx, ownerID)
+	if !settings.AnswerCache {
+		return nil
+	}
+	version, err := c.redis.Get(ctx, knowledgeVersionKey(ownerID)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		c.logger.Warn("answer cache version read failed", "user_id", ownerID, "error", err)
+		return nil
+	}
+	vector, err := c.embedText(ctx, question, c.queryEmbeddingDimension(ctx))
+	if err != nil || len(vector) == 0 {
+		return nil
+	}
+	return &answerCache{
+		key:      fmt.Sprintf("answercache:%d:%d:%d", widgetID, version, widgetUpdatedAt.UnixNano()),
+		minScore: settings.AnswerCacheMinScore,
+		question: question,
+		language: language,
+		vector:   vector,
+	}
+}
+
+// lookupAnswerCache returns the cached answer whose question is most similar
+// to this one, or nil when none reaches the widget's threshold. Only answers
+// in the same language are considered.
+func (c *Controller) lookupAnswerCache(ctx context.Context, ac *answerCache) *cachedAnswer {
+	if ac == nil {
+		return nil
+	}
+	raw, err := c.redis.LRange(ctx, ac.key, 0, answerCacheMaxEntries-1).Result()
+	if err != nil {
+		c.logger.Warn("answer cache read failed", "error", err)
+		return nil
+	}
+	var best *cachedAnswer
+	for _, item := range raw {
+		var entry cachedAnswer
+		if err := json.Unmarshal([]byte(item), &entry); err != nil || entry.Language != ac.language {
+			continue
+		}
+		entry.score = cosineSimilarity(ac.vector, decodeEmbedding(entry.Embedding))
+		if entry.score >= ac.minScore && (best == nil || entry.score > best.score) {
+			best = &entry
+		}
+	}
+	return best
+}
+
+// storeAnswerCache adds a generated answer and its follow-ups to the cache,
+// keeping the newest answerCacheMaxEntries.
+func (c *Controller) storeAnswerCache(ctx context.Context, ac *answerCache, answer string, sources []answerSource, followUps []string) {
+	if ac == nil || strings.TrimSpace(answer) == "" {
+		return
+	}
+	b, err := json.Marshal(cachedAnswer{
+		Question:  ac.question,
+		Language:  ac.language,
+		Embedding: encodeEmbedding(ac.vector),
+		Answer:    answer,
+		Sources:   sources,
+		FollowUps: followUps,
+		CachedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Second)
+	defer cancel()
+	_, err = c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, ac.key, b)
+		pipe.LTrim(ctx, ac.key, 0, answerCacheMaxEntries-1)
+		pipe.Expire(ctx, ac.key, answerCacheTTL)
+		return nil
+	})
+	if err != nil {
+		c.logger.Warn("answer cache write failed", "error", err)
+	}
+}
+
+// invalidateAnswerCache bumps the owner's knowledge base version so answers
+// cached before a scrape, upload or delete are no longer served. Call it after
+// the index write so no answer built from the old content can be cached under
+// the new version.
+func (c *Controller) invalidateAnswerCache(ownerID string) {
+	if c.redis == nil || strings.TrimSpace(ownerID) == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.redis.Incr(ctx, knowledgeVersionKey(ownerID)).Err(); err != nil {
+		c.logger.Warn("answer cache invalidation failed", "user_id", ownerID, "error", err)
+	}
+}
+
+func (a *cachedAnswer) reference() map[string]interface{} {
+	return map[string]interface{}{
+		"question": a.Question,
+		"score":    a.score,
+		"cachedAt": a.CachedAt,
+	}
+}
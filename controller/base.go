@@ -12,6 +12,8 @@ import (
 
 	"konvoq-backend/config"
 	"konvoq-backend/controller/auth"
+	"konvoq-backend/platform/llm"
+	"konvoq-backend/platform/vectorstore"
 	"konvoq-backend/utils"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -20,13 +22,18 @@ import (
 
 // Controller holds all dependencies for request handlers.
 type Controller struct {
-	cfg       config.Config
-	db        *sql.DB
-	redis     *redis.Client
-	logger    *slog.Logger
-	Auth      *auth.Handler
-	docSem    chan struct{} // limits concurrent document-processing goroutines
-	scrapeSem chan struct{} // limits concurrent scrape-job goroutines
+	cfg        config.Config
+	db         *sql.DB
+	redis      *redis.Client
+	logger     *slog.Logger
+	Auth       *auth.Handler
+	llm        llm.Provider
+	embedder   llm.Embedder
+	vectors    vectorstore.Store
+	docSem     chan struct{} // limits concurrent document-processing goroutines
+	scrapeSem  chan struct{} // limits concurrent scrape jobs on this instance
+	scrapeWake chan struct{} // signals the scrape queue dispatcher
+	workerID   string        // this process in scrape_jobs.locked_by
 }
 
 func New(cfg config.Config, db *sql.DB, redisClient *redis.Client, logger *slog.Logger) *Controller {
@@ -34,14 +41,18 @@ func New(cfg config.Config, db *sql.DB, redisClient *redis.Client, logger *slog.
 		logger = slog.Default()
 	}
 	c := &Controller{
-		cfg:       cfg,
-		db:        db,
-		redis:     redisClient,
-		logger:    logger.With("component", "controller"),
-		docSem:    make(chan struct{}, 10),
-		scrapeSem: make(chan struct{}, 5),
+		cfg:        cfg,
+		db:         db,
+		redis:      redisClient,
+		logger:     logger.With("component", "controller"),
+		docSem:     make(chan struct{}, 10),
+		scrapeSem:  make(chan struct{}, 5),
+		scrapeWake: make(chan struct{}, 1),
+		workerID:   newScrapeWorkerID(),
 	}
 	c.Auth = auth.New(cfg.GoogleClientID, cfg.GoogleRedirectURL)
+	c.llm, c.embedder = newLLMClients(cfg, c.logger)
+	c.vectors = newVectorStore(cfg, db, c.logger)
 	return c
 }
 
@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -11,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"konvoq-backend/platform/llm"
 	"konvoq-backend/utils"
 )
 
@@ -84,7 +84,7 @@ func parseBrandHints(html string) brandHints {
 
 // ── Handler ────────────────────────────────────────────────────────────────
 
-func (c *Controller) BrandExtract(w http.ResponseWriter, r *http.Request, _ TokenClaims, _ UserRecord) {
+func (c *Controller) BrandExtract(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
 	rawURL := strings.TrimSpace(r.URL.Query().Get("url"))
 	if rawURL == "" {
 		utils.JSONErr(w, http.StatusBadRequest, "url param required")
@@ -124,14 +124,14 @@ func (c *Controller) BrandExtract(w http.ResponseWriter, r *http.Request, _ Toke
 	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 80*1024))
 	hints := parseBrandHints(string(raw))
 
-	brand := c.aiBrandColors(hints)
+	brand := c.aiBrandColors(withUsageScope(r.Context(), claims.UserID, usageKindBrand), hints)
 	utils.JSONOK(w, map[string]interface{}{"success": true, "brand": brand})
 }
 
 // ── AI color suggestion ────────────────────────────────────────────────────
 
-func (c *Controller) aiBrandColors(hints brandHints) map[string]interface{} {
-	if strings.TrimSpace(c.cfg.OpenAIAPIKey) == "" {
+func (c *Controller) aiBrandColors(ctx context.Context, hints brandHints) map[string]interface{} {
+	if c.llm == nil {
 		return buildFallbackBrand(hints)
 	}
 
@@ -165,7 +165,11 @@ Rules:
 - botName should include the website name naturally (e.g. "Acme AI" or "Acme Support AI")
 - Return valid JSON only, no extra text`, nameHint, colorHint)
 
-	result, err := c.openAIBrandChat(prompt)
+	temperature := 0.3
+	result, err := c.llmChat(ctx, llm.ChatRequest{
+		Messages:    []llm.Message{{Role: "user", Content: prompt}},
+		Temperature: &temperature,
+	})
 	if err != nil || result == "" {
 		c.logger.Warn("brand extract ai failed, using fallback", "error", err)
 		return buildFallbackBrand(hints)
@@ -224,51 +228,3 @@ func buildFallbackBrand(hints brandHints) map[string]interface{} {
 	}
 	return brand
 }
-
-func (c *Controller) openAIBrandChat(prompt string) (string, error) {
-	payload := map[string]interface{}{
-		"model": "gpt-4o-mini",
-		"messages": []map[string]string{
-			{"role": "user", "content": prompt},
-		},
-		"temperature": 0.3,
-	}
-	b, _ := json.Marshal(payload)
-
-	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", bytes.NewReader(b))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Authorization", "Bearer "+c.cfg.OpenAIAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-	req = req.WithContext(ctx)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return "", fmt.Errorf("openai status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
-	}
-
-	var out struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return "", err
-	}
-	if len(out.Choices) == 0 {
-		return "", nil
-	}
-	return strings.TrimSpace(out.Choices[0].Message.Content), nil
-}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"strings"
@@ -16,6 +17,7 @@ func (c *Controller) Chat(w http.ResponseWriter, r *http.Request, claims TokenCl
 	var body struct {
 		Message   string `json:"message"`
 		SessionID string `json:"sessionId"`
+		Language  string `json:"language"`
 	}
 	if err := utils.DecodeJSON(r, &body); err != nil || strings.TrimSpace(body.Message) == "" {
 		utils.JSONErr(w, http.StatusBadRequest, "message is required")
@@ -59,26 +61,66 @@ func (c *Controller) Chat(w http.ResponseWriter, r *http.Request, claims TokenCl
 		utils.JSONErr(w, http.StatusInternalServerError, "db error")
 		return
 	}
-	answer := "I don't have information about that. Please contact support."
-	ragMatches, ragErr := c.pineconeQuery(claims.UserID, body.Message, 5)
-	if ragErr != nil {
-		c.logRequestWarn(r, "chat context lookup failed", ragErr, "user_id", claims.UserID, "session_id", convID)
+	r = r.WithContext(withUsageScope(r.Context(), claims.UserID, usageKindChat))
+	aiSettings := c.loadAISettings(r.Context(), claims.UserID)
+	aiSettings = aiSettings.withLanguage(aiSettings.answerLanguage(body.Message, body.Language))
+	answer := aiSettings.fallbackAnswer()
+	sources := []answerSource{}
+	history := c.loadChatHistory(r.Context(), convID, claims.UserID, chatHistoryTokenBudget)
+	plan := c.planChatTurn(r.Context(), chatTurn{OwnerID: claims.UserID, Message: body.Message, History: history, AI: aiSettings})
+	curated := plan.Curated
+	var tools *chatTools
+	var rich *RichMessage
+	if curated != nil {
+		answer, rich = curated.Answer, curated.message()
+	} else if plan.RetrievalErr != nil {
+		c.logRequestWarn(r, "chat context lookup failed", plan.RetrievalErr, "user_id", claims.UserID, "session_id", convID)
 	} else {
-		relevantMatches := relevantRAGMatches(ragMatches, 0.65)
-		if len(relevantMatches) > 0 {
-			if ai, aiErr := c.openAIAnswerWithContext(body.Message, relevantMatches); aiErr == nil && strings.TrimSpace(ai) != "" {
-				answer = ai
-			} else if aiErr != nil {
+		relevantMatches := plan.Matches
+		if len(relevantMatches) == 0 {
+			c.recordKnowledgeGap(claims.UserID, 0, convID, body.Message, plan.BestScore)
+		}
+		// With tools enabled the model still runs without context, since the
+		// visitor may be asking for an action rather than an answer.
+		tools = c.loadChatTools(r.Context(), chatToolScope{OwnerID: claims.UserID, SessionID: convID})
+		if len(relevantMatches) > 0 || tools != nil {
+			if ai, _, aiErr := c.generateChatAnswer(r.Context(), ragPrompt{
+				System:  c.systemPromptForOwner(r.Context(), claims.UserID),
+				Query:   body.Message,
+				History: history,
+				Matches: relevantMatches,
+				AI:      aiSettings,
+				Tools:   tools,
+			}, nil, nil); aiErr == nil && ai != "" {
+				answer, rich = ai, tools.message(ai)
+				sources = answerSources(ai, aiSettings.fallbackAnswer(), relevantMatches)
+			} else if aiErr != nil && !errors.Is(aiErr, errSpendCapReached) {
 				c.logRequestWarn(r, "chat response generation with context failed", aiErr, "user_id", claims.UserID, "session_id", convID)
 			}
 		}
 	}
-	insertResult, err := c.db.Exec(`INSERT INTO chat_messages (conversation_id,user_id,role,content,metadata)
-		SELECT c.id,$2,v.role,v.content,'{}'::jsonb
+	meta := map[string]interface{}{"sources": sources, "language": aiSettings.language}
+	if curated != nil {
+		meta["curated"] = curated.reference()
+	}
+	if invocations := tools.logged(); invocations != nil {
+		meta["tools"] = invocations
+	}
+	if rich != nil {
+		meta["rich"] = rich
+	}
+	tokenCount, usage := messageUsage(r.Context())
+	if usage != nil {
+		meta["usage"] = usage
+	}
+	content := messageContent(answer, rich)
+	assistantMeta, _ := json.Marshal(meta)
+	insertResult, err := c.db.Exec(`INSERT INTO chat_messages (conversation_id,user_id,role,content,metadata,token_count)
+		SELECT c.id,$2,v.role,v.content,v.metadata,v.token_count
 		FROM chat_conversations c
-		JOIN (VALUES ('user'::varchar,$3),('assistant'::varchar,$4)) AS v(role,content) ON TRUE
+		JOIN (VALUES ('user'::varchar,$3,'{}'::jsonb,NULL::int),('assistant'::varchar,$4,$5::jsonb,$6::int)) AS v(role,content,metadata,token_count) ON TRUE
 		WHERE c.id=$1 AND c.user_id=$2 AND c.is_deleted=FALSE`,
-		convID, claims.UserID, body.Message, answer)
+		convID, claims.UserID, body.Message, content, string(assistantMeta), nullableTokenCount(tokenCount))
 	if err != nil {
 		c.logRequestWarn(r, "chat message insert failed", err, "user_id", claims.UserID, "session_id", convID)
 	} else if rows, rowsErr := insertResult.RowsAffected(); rowsErr == nil && rows != 2 {
@@ -91,7 +133,11 @@ func (c *Controller) Chat(w http.ResponseWriter, r *http.Request, claims TokenCl
 		convID, body.Message, claims.UserID); err != nil {
 		c.logRequestWarn(r, "chat conversation metadata update failed", err, "user_id", claims.UserID, "session_id", convID)
 	}
-	utils.JSONOK(w, map[string]interface{}{"success": true, "sessionId": convID, "response": answer, "usage": map[string]interface{}{"conversationsUsed": used, "conversationsLimit": utils.NullableInt64(limit)}})
+	resp := map[string]interface{}{"success": true, "sessionId": convID, "response": content, "sources": sources, "rich": rich, "language": aiSettings.language, "usage": map[string]interface{}{"conversationsUsed": used, "conversationsLimit": utils.NullableInt64(limit)}}
+	if curated != nil {
+		resp["curated"] = curated.reference()
+	}
+	utils.JSONOK(w, resp)
 }
 
 func (c *Controller) ChatSessions(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
@@ -134,7 +180,7 @@ func (c *Controller) ChatSession(w http.ResponseWriter, r *http.Request, claims
 		utils.JSONErr(w, http.StatusNotFound, "chat session not found")
 		return
 	}
-	rows, err := c.db.Query(`SELECT m.role,m.content,m.created_at
+	rows, err := c.db.Query(`SELECT m.role,m.content,COALESCE(m.metadata->'sources','[]'::jsonb),m.metadata->'rich',m.created_at
 		FROM chat_messages m
 		JOIN chat_conversations c ON c.id=m.conversation_id
 		WHERE m.conversation_id=$1 AND c.user_id=$2 AND c.is_deleted=FALSE
@@ -148,12 +194,20 @@ func (c *Controller) ChatSession(w http.ResponseWriter, r *http.Request, claims
 	msgs := []map[string]interface{}{}
 	for rows.Next() {
 		var role, content string
+		var sourcesRaw, richRaw []byte
 		var created time.Time
-		if err := rows.Scan(&role, &content, &created); err != nil {
+		if err := rows.Scan(&role, &content, &sourcesRaw, &richRaw, &created); err != nil {
 			c.logRequestWarn(r, "chat session messages row scan failed", err, "user_id", claims.UserID, "session_id", sid)
 			continue
 		}
-		msgs = append(msgs, map[string]interface{}{"role": role, "content": content, "createdAt": created})
+		msg := map[string]interface{}{"role": role, "content": content, "createdAt": created}
+		if role == "assistant" {
+			msg["sources"] = json.RawMessage(sourcesRaw)
+			if len(richRaw) > 0 {
+				msg["rich"] = json.RawMessage(richRaw)
+			}
+		}
+		msgs = append(msgs, msg)
 	}
 	utils.JSONOK(w, map[string]interface{}{"success": true, "session": map[string]interface{}{"id": sid, "messages": msgs}})
 }
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"konvoq-backend/platform/chunker"
+	"konvoq-backend/platform/llm"
+)
+
+const (
+	chatHistoryTokenBudget = 1500
+	chatHistoryMaxMessages = 20
+	// chatHistoryMessageChars caps a single stored turn so one long answer
+	// cannot consume the whole budget.
+	chatHistoryMessageChars = 2000
+)
+
+// loadChatHistory returns the most recent user/assistant turns of a
+// conversation, oldest first, trimmed to fit tokenBudget. It must be called
+// before the current message is stored.
+func (c *Controller) loadChatHistory(ctx context.Context, conversationID, ownerID string, tokenBudget int) []llm.Message {
+	if strings.TrimSpace(conversationID) == "" {
+		return nil
+	}
+	if tokenBudget <= 0 {
+		tokenBudget = chatHistoryTokenBudget
+	}
+	rows, err := c.db.QueryContext(ctx, `SELECT m.role,m.content
+		FROM chat_messages m
+		JOIN chat_conversations c ON c.id=m.conversation_id
+		WHERE m.conversation_id=$1 AND c.user_id=$2 AND c.is_deleted=FALSE AND m.role IN ('user','assistant')
+		ORDER BY m.created_at DESC,m.id DESC
+		LIMIT $3`, conversationID, ownerID, chatHistoryMaxMessages)
+	if err != nil {
+		c.logger.Warn("chat history query failed", "conversation_id", conversationID, "error", err)
+		return nil
+	}
+	defer rows.Close()
+
+	newestFirst := make([]llm.Message, 0, chatHistoryMaxMessages)
+	used := 0
+	for rows.Next() {
+		var msg llm.Message
+		if err := rows.Scan(&msg.Role, &msg.Content); err != nil {
+			c.logger.Warn("chat history row scan failed", "conversation_id", conversationID, "error", err)
+			continue
+		}
+		msg.Content = truncateRunes(strings.TrimSpace(msg.Content), chatHistoryMessageChars)
+		if msg.Content == "" {
+			continue
+		}
+		cost := chunker.CountTokens(msg.Content)
+		if used+cost > tokenBudget {
+			break
+		}
+		used += cost
+		newestFirst = append(newestFirst, msg)
+	}
+	if err := rows.Err(); err != nil {
+		c.logger.Warn("chat history rows failed", "conversation_id", conversationID, "error", err)
+	}
+
+	history := make([]llm.Message, 0, len(newestFirst))
+	for i := len(newestFirst) - 1; i >= 0; i-- {
+		history = append(history, newestFirst[i])
+	}
+	return history
+}
+
+// rewriteQuery turns a follow-up such as "how much does it cost?" into a
+// standalone question so vector retrieval has the missing context. It returns
+// the original message when there is no history or the rewrite fails.
+func (c *Controller) rewriteQuery(ctx context.Context, history []llm.Message, message string) string {
+	message = strings.TrimSpace(message)
+	if len(history) == 0 || c.llm == nil {
+		return message
+	}
+	recent := history
+	if len(recent) > 6 {
+		recent = recent[len(recent)-6:]
+	}
+	var transcript strings.Builder
+	for _, m := range recent {
+		transcript.WriteString(m.Role)
+		transcript.WriteString(": ")
+		transcript.WriteString(truncateRunes(m.Content, 500))
+		transcript.WriteString("\n")
+	}
+	temperature := 0.0
+	rewritten, err := c.llmChat(ctx, llm.ChatRequest{
+		Messages: []llm.Message{
+			{Role: "system", Content: "You rewrite follow-up questions into standalone search queries. Reply with the rewritten question only, in the user's language. If the question is already standalone, repeat it unchanged."},
+			{Role: "user", Content: "Conversation:\n" + transcript.String() + "\nFollow-up question: " + message},
+		},
+		Temperature: &temperature,
+		MaxTokens:   120,
+	})
+	rewritten = strings.Trim(strings.TrimSpace(rewritten), `"`)
+	if err != nil || rewritten == "" || utf8.RuneCountInString(rewritten) > 4*utf8.RuneCountInString(message)+200 {
+		return message
+	}
+	return rewritten
+}
+
+func truncateRunes(text string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:max])
+}
@@ -0,0 +1,445 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"konvoq-backend/platform/llm"
+	"konvoq-backend/utils"
+)
+
+const (
+	toolCaptureLead    = "capture_lead"
+	toolRequestHandoff = "request_handoff"
+	toolLookupOrder    = "lookup_order"
+
+	// maxToolRounds bounds how many times one answer may go model → tools →
+	// model before the reply is taken as is.
+	maxToolRounds       = 3
+	maxToolArgChars     = 255
+	maxToolResultBytes  = 8 << 10
+	orderLookupTimeout  = 10 * time.Second
+	orderLookupEvent    = "order.lookup"
+	maxHandoffReasonLen = 1000
+	// maxLeadPhoneChars matches leads.phone (VARCHAR(50)).
+	maxLeadPhoneChars = 50
+)
+
+// ChatToolSettings choose which actions the assistant may take during a
+// conversation (chat_tool_configs). An empty OrderLookupURL disables order
+// lookups.
+type ChatToolSettings struct {
+	LeadCapture    bool   `json:"leadCapture"`
+	Handoff        bool   `json:"handoff"`
+	OrderLookupURL string `json:"orderLookupUrl"`
+}
+
+func (s ChatToolSettings) any() bool {
+	return s.LeadCapture || s.Handoff || s.OrderLookupURL != ""
+}
+
+func (c *Controller) GetChatTools(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	settings, secret, err := c.chatToolSettings(r.Context(), claims.UserID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.logRequestError(r, "get chat tools failed", err, "user_id", claims.UserID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	utils.JSONOK(w, map[string]interface{}{"success": true, "tools": settings, "signingSecret": utils.Nullable(secret)})
+}
+
+func (c *Controller) UpdateChatTools(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	if err := c.RequireCSRF(r); err != nil {
+		utils.JSONErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+	var body ChatToolSettings
+	if err := utils.DecodeJSON(r, &body); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	body.OrderLookupURL = strings.TrimSpace(body.OrderLookupURL)
+	if body.OrderLookupURL != "" {
+		if _, err := c.validateWebhookTarget(body.OrderLookupURL); err != nil {
+			utils.JSONErr(w, http.StatusBadRequest, "orderLookupUrl is not allowed")
+			return
+		}
+	}
+	var secret string
+	err := c.db.QueryRow(`INSERT INTO chat_tool_configs (user_id,lead_capture_enabled,handoff_enabled,order_lookup_url,order_lookup_secret)
+		VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (user_id) DO UPDATE SET lead_capture_enabled=EXCLUDED.lead_capture_enabled,handoff_enabled=EXCLUDED.handoff_enabled,order_lookup_url=EXCLUDED.order_lookup_url,updated_at=CURRENT_TIMESTAMP
+		RETURNING order_lookup_secret`,
+		claims.UserID, body.LeadCapture, body.Handoff, utils.Nullable(body.OrderLookupURL), utils.RandomID("whsec")).Scan(&secret)
+	if err != nil {
+		c.logRequestError(r, "update chat tools failed", err, "user_id", claims.UserID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	utils.JSONOK(w, map[string]interface{}{"success": true, "tools": body, "signingSecret": secret})
+}
+
+func (c *Controller) chatToolSettings(ctx context.Context, ownerID string) (ChatToolSettings, string, error) {
+	var s ChatToolSettings
+	var secret string
+	err := c.db.QueryRowContext(ctx, `SELECT lead_capture_enabled,handoff_enabled,COALESCE(order_lookup_url,''),order_lookup_secret
+		FROM chat_tool_configs WHERE user_id=$1`, ownerID).Scan(&s.LeadCapture, &s.Handoff, &s.OrderLookupURL, &secret)
+	return s, secret, err
+}
+
+// chatToolScope is the conversation tool calls act on. WidgetID is 0 for
+// dashboard chats.
+type chatToolScope struct {
+	OwnerID   string
+	WidgetID  int64
+	SessionID string
+	SourceURL string
+	IPAddress string
+}
+
+// chatTools are the tools enabled for one answer, with a log of the calls
+// made while producing it and the rich message, if any, their results asked
+// to show.
+type chatTools struct {
+	scope       chatToolScope
+	settings    ChatToolSettings
+	secret      string
+	invocations []toolInvocation
+	rich        *RichMessage
+}
+
+// toolInvocation is what chat_messages.metadata.tools records per call.
+type toolInvocation struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Arguments  json.RawMessage `json:"arguments"`
+	Status     string          `json:"status"`
+	Result     json.RawMessage `json:"result"`
+	DurationMs int64           `json:"durationMs"`
+}
+
+// loadChatTools returns the owner's enabled tools, or nil when there are none
+// (or no model to call them), in which case answers are generated as before.
+func (c *Controller) loadChatTools(ctx context.Context, scope chatToolScope) *chatTools {
+	if c.llm == nil {
+		return nil
+	}
+	settings, secret, err := c.chatToolSettings(ctx, scope.OwnerID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			c.logger.Warn("chat tools query failed", "user_id", scope.OwnerID, "error", err)
+		}
+		return nil
+	}
+	if !settings.any() {
+		return nil
+	}
+	return &chatTools{scope: scope, settings: settings, secret: secret}
+}
+
+// logged returns the calls made so far for the message metadata, or nil.
+func (t *chatTools) logged() []toolInvocation {
+	if t == nil || len(t.invocations) == 0 {
+		return nil
+	}
+	return t.invocations
+}
+
+// message returns the rich message to show under answer, or nil.
+func (t *chatTools) message(answer string) *RichMessage {
+	if t == nil {
+		return nil
+	}
+	return t.rich.withText(answer)
+}
+
+func (t *chatTools) definitions() []llm.Tool {
+	stringProp := func(description string) map[string]interface{} {
+		return map[string]interface{}{"type": "string", "description": description}
+	}
+	tools := make([]llm.Tool, 0, 3)
+	if t.settings.LeadCapture {
+		tools = append(tools, llm.Tool{
+			Name:        toolCaptureLead,
+			Description: "Save the visitor's contact details so the team can follow up. Only use details the visitor gave in this conversation; an email or a phone number is required.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"name":  stringProp("The visitor's name."),
+					"email": stringProp("The visitor's email address."),
+					"phone": stringProp("The visitor's phone number."),
+				},
+			},
+		})
+	}
+	if t.settings.Handoff {
+		tools = append(tools, llm.Tool{
+			Name:        toolRequestHandoff,
+			Description: "Ask a human agent to take over the conversation, when the visitor asks for a person or the question needs one.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"reason": stringProp("Why a person is needed, in one sentence."),
+					"name":   stringProp("The visitor's name, if given."),
+					"email":  stringProp("The visitor's email address, if given."),
+				},
+				"required": []string{"reason"},
+			},
+		})
+	}
+	if t.settings.OrderLookupURL != "" {
+		tools = append(tools, llm.Tool{
+			Name:        toolLookupOrder,
+			Description: "Look up the status of one of the visitor's orders.",
+			Parameters: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"orderId": stringProp("The order number the visitor gave."),
+					"email":   stringProp("The email address used for the order, if given."),
+				},
+				"required": []string{"orderId"},
+			},
+		})
+	}
+	return tools
+}
+
+// promptHint is added to the grounded prompt so the model knows the
+// "context only" rule does not stop it from acting.
+func (t *chatTools) promptHint() string {
+	var actions []string
+	if t.settings.LeadCapture {
+		actions = append(actions, "leave their contact details")
+	}
+	if t.settings.Handoff {
+		actions = append(actions, "talk to a person")
+	}
+	if t.settings.OrderLookupURL != "" {
+		actions = append(actions, "check an order")
+	}
+	return fmt.Sprintf("If the user wants to %s, call the matching tool instead and reply based on its result.", strings.Join(actions, ", or "))
+}
+
+// answerWithTools runs the model with the tools available, executes the calls
+// it makes and feeds the results back until it replies in text or
+// maxToolRounds is reached.
+func (c *Controller) answerWithTools(ctx context.Context, req llm.ChatRequest, tools *chatTools) (string, error) {
+	req.Tools = tools.definitions()
+	for round := 0; ; round++ {
+		resp, err := c.llmChatResponse(ctx, req)
+		if err != nil {
+			return "", err
+		}
+		if len(resp.ToolCalls) == 0 || round == maxToolRounds {
+			return strings.TrimSpace(resp.Content), nil
+		}
+		req.Messages = append(req.Messages, llm.Message{Role: "assistant", Content: resp.Content, ToolCalls: resp.ToolCalls})
+		for _, call := range resp.ToolCalls {
+			req.Messages = append(req.Messages, llm.Message{Role: "tool", ToolCallID: call.ID, Content: c.runChatTool(ctx, tools, call)})
+		}
+	}
+}
+
+// runChatTool executes one call, logs it and returns the JSON result handed
+// back to the model. Failures are reported to the model rather than aborting
+// the answer.
+func (c *Controller) runChatTool(ctx context.Context, tools *chatTools, call llm.ToolCall) string {
+	start := time.Now()
+	args := json.RawMessage(call.Arguments)
+	if !json.Valid(args) {
+		args = json.RawMessage("{}")
+	}
+	var result interface{}
+	var err error
+	switch call.Name {
+	case toolCaptureLead:
+		if tools.settings.LeadCapture {
+			result, err = c.toolCaptureLead(ctx, tools, args)
+		}
+	case toolRequestHandoff:
+		if tools.settings.Handoff {
+			result, err = c.toolRequestHandoff(ctx, tools, args)
+		}
+	case toolLookupOrder:
+		if tools.settings.OrderLookupURL != "" {
+			result, err = c.toolLookupOrder(ctx, tools, args)
+		}
+	}
+	if result == nil && err == nil {
+		err = fmt.Errorf("unknown tool %q", call.Name)
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+		result = map[string]interface{}{"error": err.Error()}
+		c.logger.Warn("chat tool call failed", "user_id", tools.scope.OwnerID, "session_id", tools.scope.SessionID, "tool", call.Name, "error", err)
+	}
+	resultJSON, _ := json.Marshal(result)
+	tools.invocations = append(tools.invocations, toolInvocation{
+		ID:         call.ID,
+		Name:       call.Name,
+		Arguments:  args,
+		Status:     status,
+		Result:     resultJSON,
+		DurationMs: time.Since(start).Milliseconds(),
+	})
+	return string(resultJSON)
+}
+
+// toolArg returns a trimmed string argument, cut to limit runes.
+func toolArg(args map[string]interface{}, key string, limit int) string {
+	v, _ := args[key].(string)
+	return truncateRunes(strings.TrimSpace(v), limit)
+}
+
+func decodeToolArgs(raw json.RawMessage) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, errors.New("arguments must be a JSON object")
+	}
+	return args, nil
+}
+
+func (c *Controller) toolCaptureLead(ctx context.Context, tools *chatTools, raw json.RawMessage) (interface{}, error) {
+	args, err := decodeToolArgs(raw)
+	if err != nil {
+		return nil, err
+	}
+	lead := capturedLead{
+		OwnerID:   tools.scope.OwnerID,
+		WidgetID:  tools.scope.WidgetID,
+		SessionID: tools.scope.SessionID,
+		Name:      toolArg(args, "name", maxToolArgChars),
+		Email:     utils.NormalizeEmail(toolArg(args, "email", maxToolArgChars)),
+		Phone:     toolArg(args, "phone", maxToolArgChars),
+		SourceURL: tools.scope.SourceURL,
+		IPAddress: tools.scope.IPAddress,
+	}
+	if lead.Email == "" && lead.Phone == "" {
+		tools.rich = leadCaptureForm()
+		return nil, errors.New("an email or phone number is required; a contact form is shown to the visitor")
+	}
+	if lead.Email != "" && !utils.ValidateEmail(lead.Email) {
+		return nil, errors.New("email is not valid")
+	}
+	if lead.Phone != "" && (!utils.ValidatePhone(lead.Phone) || len(lead.Phone) > maxLeadPhoneChars) {
+		return nil, errors.New("phone is not valid; pass only the number (digits with optional +, spaces, dashes or parentheses), or ask the visitor to confirm it")
+	}
+	leadID, err := c.captureLead(ctx, lead)
+	if err != nil {
+		return nil, fmt.Errorf("lead could not be saved")
+	}
+	return map[string]interface{}{"saved": true, "leadId": leadID}, nil
+}
+
+func (c *Controller) toolRequestHandoff(ctx context.Context, tools *chatTools, raw json.RawMessage) (interface{}, error) {
+	args, err := decodeToolArgs(raw)
+	if err != nil {
+		return nil, err
+	}
+	// One open request per conversation; asking again reuses it.
+	var id, status string
+	err = c.db.QueryRowContext(ctx, `SELECT id,status FROM handoff_requests
+		WHERE user_id=$1 AND session_id=$2 AND status IN ('pending','claimed')
+		ORDER BY created_at DESC LIMIT 1`, tools.scope.OwnerID, tools.scope.SessionID).Scan(&id, &status)
+	if err == nil {
+		return map[string]interface{}{"requested": true, "handoffId": id, "status": status}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("handoff could not be requested")
+	}
+	id, err = c.createHandoff(ctx, tools.scope.OwnerID, tools.scope.SessionID,
+		toolArg(args, "name", maxToolArgChars), toolArg(args, "email", maxToolArgChars), toolArg(args, "reason", maxHandoffReasonLen))
+	if err != nil {
+		return nil, fmt.Errorf("handoff could not be requested")
+	}
+	return map[string]interface{}{"requested": true, "handoffId": id, "status": "pending"}, nil
+}
+
+// leadCaptureForm is shown when the model tries to capture a lead before the
+// visitor has given an email or phone number.
+func leadCaptureForm() *RichMessage {
+	return &RichMessage{
+		Type: richMessageForm,
+		Fields: []RichFormField{
+			{Name: "name", Label: "Name", Type: "text"},
+			{Name: "email", Label: "Email", Type: "email"},
+			{Name: "phone", Label: "Phone", Type: "phone"},
+		},
+		SubmitLabel: defaultRichSubmitLabel,
+	}
+}
+
+// toolLookupOrder POSTs {orderId,email,sessionId} to the owner's endpoint,
+// signed like lead webhooks, and returns its JSON reply. A 404 means the order
+// does not exist.
+func (c *Controller) toolLookupOrder(ctx context.Context, tools *chatTools, raw json.RawMessage) (interface{}, error) {
+	args, err := decodeToolArgs(raw)
+	if err != nil {
+		return nil, err
+	}
+	orderID := toolArg(args, "orderId", maxToolArgChars)
+	if orderID == "" {
+		return nil, errors.New("orderId is required")
+	}
+	if _, err := c.validateWebhookTarget(tools.settings.OrderLookupURL); err != nil {
+		return nil, fmt.Errorf("order lookup endpoint is not allowed")
+	}
+	payload, _ := json.Marshal(map[string]string{
+		"orderId":   orderID,
+		"email":     toolArg(args, "email", maxToolArgChars),
+		"sessionId": tools.scope.SessionID,
+	})
+	ctx, cancel := context.WithTimeout(ctx, orderLookupTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tools.settings.OrderLookupURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("order lookup endpoint is not allowed")
+	}
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Witzo-Event-Type", orderLookupEvent)
+	req.Header.Set("X-Witzo-Timestamp", timestamp)
+	req.Header.Set("X-Witzo-Signature", "sha256="+webhookSignature(tools.secret, timestamp, string(payload)))
+	client := &http.Client{CheckRedirect: c.checkOutboundRedirect}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("order lookup failed")
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxToolResultBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("order lookup failed")
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return map[string]interface{}{"found": false}, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("order lookup returned status %d", resp.StatusCode)
+	}
+	if len(body) > maxToolResultBytes {
+		return nil, fmt.Errorf("order lookup response is too large")
+	}
+	if json.Valid(body) {
+		// The endpoint may include a rich message (say, a card with the
+		// product image and tracking link) to show with the answer.
+		var reply struct {
+			Message json.RawMessage `json:"message"`
+		}
+		if json.Unmarshal(body, &reply) == nil {
+			if rich, err := parseRichMessage(reply.Message); err == nil && rich != nil {
+				tools.rich = rich
+			}
+		}
+		return map[string]interface{}{"found": true, "order": json.RawMessage(body)}, nil
+	}
+	return map[string]interface{}{"found": true, "order": strings.TrimSpace(string(body))}, nil
+}
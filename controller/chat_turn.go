@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"strings"
+
+	"konvoq-backend/platform/llm"
+)
+
+// chatTurn is one message to answer. Chat, PublicWebhook and EvalAnswer all
+// answer through planChatTurn and generateChatAnswer, so the evaluation
+// harness measures the production flow.
+type chatTurn struct {
+	OwnerID string
+	Message string
+	History []llm.Message
+	AI      AISettings
+	// Guard holds the widget's guardrails; nil (the owner's own chat) skips
+	// them.
+	Guard *GuardrailSettings
+	// OpenCache, when set, opens the answer cache for the rewritten query.
+	OpenCache func(searchQuery string) *answerCache
+}
+
+// chatPlan is how a turn is answered: a guardrail refusal, a curated answer,
+// a cached answer, or generation grounded on Matches. RetrievalErr is set
+// when retrieval failed.
+type chatPlan struct {
+	SearchQuery  string
+	Violation    *guardrailViolation
+	Curated      *curatedMatch
+	Cache        *answerCache
+	CacheHit     *cachedAnswer
+	Matches      []map[string]interface{}
+	BestScore    float64
+	RetrievalErr error
+	// Dropped lists the matches the guardrails removed from Matches.
+	Dropped []guardrailViolation
+}
+
+// planChatTurn runs the steps before generation in order, stopping at the
+// first that answers the turn: the input guardrails, query rewriting against
+// the history, curated answers, the answer cache, then retrieval filtered by
+// the guardrails.
+func (c *Controller) planChatTurn(ctx context.Context, t chatTurn) chatPlan {
+	var p chatPlan
+	if t.Guard != nil {
+		if p.Violation = t.Guard.checkInput(t.Message); p.Violation != nil {
+			return p
+		}
+	}
+	p.SearchQuery = c.rewriteQuery(ctx, t.History, t.Message)
+	if t.OpenCache != nil {
+		p.Cache = t.OpenCache(p.SearchQuery)
+	}
+	if p.Curated = c.matchCuratedAnswer(ctx, t.OwnerID, p.SearchQuery); p.Curated != nil {
+		return p
+	}
+	if p.CacheHit = c.lookupAnswerCache(ctx, p.Cache); p.CacheHit != nil {
+		return p
+	}
+	matches, bestScore, err := c.retrieveInLanguage(ctx, t.OwnerID, p.SearchQuery, t.AI)
+	p.BestScore = bestScore
+	if err != nil {
+		p.RetrievalErr = err
+		return p
+	}
+	p.Matches = matches
+	if t.Guard != nil {
+		p.Matches, p.Dropped = t.Guard.filterMatches(matches)
+	}
+	return p
+}
+
+// generateChatAnswer generates a grounded answer, streaming it through
+// onDelta when set, and checks it against the output guardrails unless guard
+// is nil. A guarded stream only sends text that has passed the check. On
+// error the answer holds whatever was generated before the failure.
+func (c *Controller) generateChatAnswer(ctx context.Context, prompt ragPrompt, guard *GuardrailSettings, onDelta func(string) error) (string, *guardrailViolation, error) {
+	var answer string
+	var err error
+	var gs *guardedStream
+	if onDelta != nil && guard != nil {
+		gs = &guardedStream{guard: guard, system: prompt.System, watch: guard.outputWatch(prompt.System), onDelta: onDelta}
+		onDelta = gs.write
+	}
+	if onDelta != nil {
+		answer, err = c.streamAnswerWithContext(ctx, prompt, onDelta)
+	} else {
+		answer, err = c.answerWithContext(ctx, prompt)
+	}
+	if strings.TrimSpace(answer) == "" {
+		return "", nil, err
+	}
+	if guard == nil {
+		return answer, nil, err
+	}
+	violation := guard.checkOutput(answer, prompt.System)
+	if gs != nil && violation == nil {
+		if ferr := gs.flush(); err == nil {
+			err = ferr
+		}
+	}
+	return answer, violation, err
+}
+
+// guardedStream holds streamed text back until it has passed the output
+// guardrails. Whenever the text so far passes, everything is sent except a
+// tail that could still grow into a match; the rest is sent by flush once
+// the whole answer has passed.
+type guardedStream struct {
+	guard   *GuardrailSettings
+	system  string
+	watch   outputWatch
+	onDelta func(string) error
+	text    strings.Builder
+	// sent is how many bytes of text have been forwarded.
+	sent int
+}
+
+func (g *guardedStream) write(delta string) error {
+	g.text.WriteString(delta)
+	text := g.text.String()
+	if g.guard.checkOutput(text, g.system) != nil {
+		return nil
+	}
+	return g.send(text, g.watch.pending(text))
+}
+
+func (g *guardedStream) flush() error {
+	text := g.text.String()
+	return g.send(text, len(text))
+}
+
+// send forwards text[g.sent:end] when there is any.
+func (g *guardedStream) send(text string, end int) error {
+	if end <= g.sent {
+		return nil
+	}
+	chunk := text[g.sent:end]
+	g.sent = end
+	return g.onDelta(chunk)
+}
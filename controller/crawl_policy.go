@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"konvoq-backend/platform/crawler"
+)
+
+const (
+	crawlRequestTimeout = 20 * time.Second
+	// maxCrawlDuration bounds one crawl, which a long Crawl-delay can
+	// otherwise stretch over hours; the pages fetched so far are kept.
+	maxCrawlDuration = 30 * time.Minute
+	// maxSitemapFiles bounds how many sitemaps (index children included) one
+	// crawl reads.
+	maxSitemapFiles = 25
+	// maxCrawlDelay caps the Crawl-delay honoured between requests to a
+	// host; a site asking for minutes would otherwise hold a worker for the
+	// whole crawl duration on a handful of pages.
+	maxCrawlDelay = 5 * time.Second
+)
+
+// errCrawlTimeLimit ends a Crawl-delay wait that would run past
+// maxCrawlDuration.
+var errCrawlTimeLimit = errors.New("crawl time limit reached")
+
+// crawlFetcher fetches sites the way their owners asked: pages disallowed by
+// robots.txt are skipped and requests to each host are spaced by its
+// Crawl-delay. Rules are kept per host, since a crawl may follow subdomains.
+type crawlFetcher struct {
+	c        *Controller
+	robots   map[string]*crawler.Robots
+	last     map[string]time.Time
+	deadline time.Time
+}
+
+// newCrawlFetcher loads the robots.txt of origin. A missing file (4xx) allows
+// everything; a server or network error fails the crawl, since RFC 9309 treats
+// an unreachable robots.txt as a full disallow.
+func (c *Controller) newCrawlFetcher(ctx context.Context, origin *url.URL) (*crawlFetcher, error) {
+	f := &crawlFetcher{
+		c:        c,
+		robots:   map[string]*crawler.Robots{},
+		last:     map[string]time.Time{},
+		deadline: time.Now().Add(maxCrawlDuration),
+	}
+	robots, err := f.loadRobots(ctx, origin)
+	if err != nil {
+		return nil, err
+	}
+	f.robots[origin.Host] = robots
+	return f, nil
+}
+
+func (f *crawlFetcher) loadRobots(ctx context.Context, origin *url.URL) (*crawler.Robots, error) {
+	robotsURL := origin.ResolveReference(&url.URL{Path: "/robots.txt"}).String()
+	if _, err := f.c.validateScrapeTarget(robotsURL); err != nil {
+		return nil, err
+	}
+	resp, err := f.get(ctx, robotsURL, crawler.MaxRobotsBytes, nil)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("robots.txt could not be fetched: %w", err)
+	case resp.Status >= 500:
+		return nil, fmt.Errorf("robots.txt could not be fetched: status %d", resp.Status)
+	case resp.Status >= 300:
+		// No robots.txt: everything is allowed.
+		return crawler.AllowAll(), nil
+	}
+	return crawler.ParseRobots(resp.Body, crawler.ProductToken), nil
+}
+
+// robotsFor returns the rules of u's host, loading them on first use. Another
+// host whose robots.txt cannot be fetched is skipped rather than failing the
+// crawl.
+func (f *crawlFetcher) robotsFor(ctx context.Context, u *url.URL) *crawler.Robots {
+	if robots, ok := f.robots[u.Host]; ok {
+		return robots
+	}
+	robots, err := f.loadRobots(ctx, &url.URL{Scheme: u.Scheme, Host: u.Host})
+	if err != nil {
+		f.c.logger.Warn("scrape robots.txt unavailable, skipping host", "host", u.Host, "error", err)
+		robots = crawler.DisallowAll()
+	}
+	f.robots[u.Host] = robots
+	return robots
+}
+
+// allowed reports whether robots.txt lets the crawler fetch rawURL.
+func (f *crawlFetcher) allowed(ctx context.Context, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && f.robotsFor(ctx, u).Allowed(u)
+}
+
+// expired reports whether the crawl has used up maxCrawlDuration.
+func (f *crawlFetcher) expired() bool {
+	return time.Now().After(f.deadline)
+}
+
+// wait sleeps for d, returning early with an error when ctx is cancelled or
+// the crawl deadline passes first.
+func (f *crawlFetcher) wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	deadline := time.NewTimer(time.Until(f.deadline))
+	defer deadline.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-deadline.C:
+		return errCrawlTimeLimit
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// crawlResponse is one fetched URL. ETag and LastModified are the validators
+// sent back on the next crawl's conditional request.
+type crawlResponse struct {
+	Body         []byte
+	ContentType  string
+	Status       int
+	ETag         string
+	LastModified string
+}
+
+// get fetches rawURL after waiting out the Crawl-delay (at most
+// maxCrawlDelay), reading at most limit bytes of the body. With prev set the
+// request is conditional, and an unchanged page comes back as 304 with no
+// body. Cancelling ctx or reaching the crawl deadline ends the wait.
+func (f *crawlFetcher) get(ctx context.Context, rawURL string, limit int64, prev *crawledPage) (crawlResponse, error) {
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+	if delay := min(f.robots[host].CrawlDelay(), maxCrawlDelay); delay > 0 && !f.last[host].IsZero() {
+		if wait := delay - time.Since(f.last[host]); wait > 0 {
+			if err := f.wait(ctx, wait); err != nil {
+				return crawlResponse{}, err
+			}
+		}
+	}
+	f.last[host] = time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, crawlRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return crawlResponse{}, err
+	}
+	req.Header.Set("User-Agent", crawler.UserAgent)
+	if prev != nil {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return crawlResponse{}, err
+	}
+	defer resp.Body.Close()
+	out := crawlResponse{
+		ContentType:  strings.ToLower(resp.Header.Get("Content-Type")),
+		Status:       resp.StatusCode,
+		ETag:         strings.TrimSpace(resp.Header.Get("ETag")),
+		LastModified: strings.TrimSpace(resp.Header.Get("Last-Modified")),
+	}
+	out.Body, err = io.ReadAll(io.LimitReader(resp.Body, limit))
+	return out, err
+}
+
+// sitemapSeeds returns the in-scope page URLs of the start host's sitemaps,
+// most recently modified first. Sitemaps come from robots.txt, else
+// /sitemap.xml; sitemap indexes are followed up to maxSitemapFiles files in
+// total, and no further file is read once there are maxPages entries.
+func (f *crawlFetcher) sitemapSeeds(ctx context.Context, origin *url.URL, scope *crawlScope, maxPages int) []string {
+	files := f.robots[origin.Host].Sitemaps()
+	if len(files) == 0 {
+		files = []string{origin.ResolveReference(&url.URL{Path: "/sitemap.xml"}).String()}
+	}
+	seenFiles := make(map[string]struct{}, len(files))
+	var entries []crawler.SitemapEntry
+	for i := 0; i < len(files) && i < maxSitemapFiles && len(entries) < maxPages && !f.expired() && ctx.Err() == nil; i++ {
+		file := files[i]
+		if _, seen := seenFiles[file]; seen {
+			continue
+		}
+		seenFiles[file] = struct{}{}
+		if _, err := f.c.validateScrapeTarget(file); err != nil {
+			f.c.logger.Warn("scrape sitemap skipped blocked target", "url", file, "error", err)
+			continue
+		}
+		resp, err := f.get(ctx, file, crawler.MaxSitemapBytes, nil)
+		if err != nil || resp.Status >= 300 {
+			if resp.Status != http.StatusNotFound {
+				f.c.logger.Warn("scrape sitemap fetch failed", "url", file, "status_code", resp.Status, "error", err)
+			}
+			continue
+		}
+		sitemap, err := crawler.ParseSitemap(bytes.NewReader(resp.Body))
+		if err != nil {
+			f.c.logger.Warn("scrape sitemap parse failed", "url", file, "error", err)
+			continue
+		}
+		crawler.SortEntries(sitemap.Children)
+		for _, child := range sitemap.Children {
+			files = append(files, child.Loc)
+		}
+		for _, entry := range sitemap.URLs {
+			if len(entries) >= crawler.MaxSitemapEntries {
+				break
+			}
+			u, err := url.Parse(entry.Loc)
+			if err != nil || !scope.allows(u) {
+				continue
+			}
+			if entry.Loc, err = normalizeScrapeURL(entry.Loc); err != nil || !f.allowed(ctx, entry.Loc) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	crawler.SortEntries(entries)
+	seeds := make([]string, 0, len(entries))
+	seen := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		if _, dup := seen[entry.Loc]; dup {
+			continue
+		}
+		seen[entry.Loc] = struct{}{}
+		seeds = append(seeds, entry.Loc)
+	}
+	return seeds
+}
@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxCrawlPatterns    = 20
+	maxCrawlPatternLen  = 200
+	maxCrawlDepth       = 20
+	maxCrawlExtraHosts  = 10
+	crawlRegexPrefix    = "re:"
+	crawlWildcardPrefix = "*."
+)
+
+var crawlHostPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)+$`)
+
+// CrawlRules narrow a website crawl. Include and Exclude hold glob patterns
+// ("*" within a path segment, "**" across segments) matched against the URL
+// path, or against host and path when the pattern does not start with "/";
+// patterns prefixed "re:" are regular expressions over the full URL. A page
+// must match an include pattern, if any, and no exclude pattern. MaxDepth
+// counts link hops from the start page (0 is unlimited). Subdomains follows
+// links to any subdomain of the start host's domain, and AllowedHosts adds
+// further hosts ("*.example.com" for a domain and its subdomains).
+type CrawlRules struct {
+	Include      []string `json:"include,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+	MaxDepth     int      `json:"maxDepth,omitempty"`
+	Subdomains   bool     `json:"subdomains,omitempty"`
+	AllowedHosts []string `json:"allowedHosts,omitempty"`
+}
+
+// normalize trims and validates the rules in place.
+func (r *CrawlRules) normalize() error {
+	var err error
+	if r.Include, err = normalizeCrawlPatterns(r.Include, "include"); err != nil {
+		return err
+	}
+	if r.Exclude, err = normalizeCrawlPatterns(r.Exclude, "exclude"); err != nil {
+		return err
+	}
+	if r.MaxDepth < 0 || r.MaxDepth > maxCrawlDepth {
+		return fmt.Errorf("maxDepth must be between 0 and %d", maxCrawlDepth)
+	}
+	hosts := make([]string, 0, len(r.AllowedHosts))
+	for _, h := range r.AllowedHosts {
+		h = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(h)), ".")
+		if h == "" {
+			continue
+		}
+		if !crawlHostPattern.MatchString(strings.TrimPrefix(h, crawlWildcardPrefix)) {
+			return fmt.Errorf("allowed host %q is not a host name", h)
+		}
+		hosts = append(hosts, h)
+	}
+	if len(hosts) > maxCrawlExtraHosts {
+		return fmt.Errorf("at most %d allowed hosts are supported", maxCrawlExtraHosts)
+	}
+	r.AllowedHosts = hosts
+	return nil
+}
+
+func normalizeCrawlPatterns(patterns []string, field string) ([]string, error) {
+	out := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if len(p) > maxCrawlPatternLen {
+			return nil, fmt.Errorf("%s patterns must be at most %d characters", field, maxCrawlPatternLen)
+		}
+		if _, err := compileCrawlPattern(p); err != nil {
+			return nil, fmt.Errorf("%s pattern %q: %v", field, p, err)
+		}
+		out = append(out, p)
+	}
+	if len(out) > maxCrawlPatterns {
+		return nil, fmt.Errorf("at most %d %s patterns are supported", maxCrawlPatterns, field)
+	}
+	return out, nil
+}
+
+// crawlPattern is a compiled include or exclude pattern.
+type crawlPattern struct {
+	re     *regexp.Regexp
+	target string // "path", "hostpath" or "url"
+}
+
+func compileCrawlPattern(p string) (crawlPattern, error) {
+	if expr, ok := strings.CutPrefix(p, crawlRegexPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return crawlPattern{}, errors.New("invalid regular expression")
+		}
+		return crawlPattern{re: re, target: "url"}, nil
+	}
+	target := "path"
+	if !strings.HasPrefix(p, "/") {
+		target = "hostpath"
+		p = strings.ToLower(p)
+	}
+	return crawlPattern{re: regexp.MustCompile("^" + globExpr(p) + "$"), target: target}, nil
+}
+
+// globExpr translates a glob to a regular expression. A trailing "/**" also
+// matches the directory itself, so "/docs/**" covers "/docs".
+func globExpr(glob string) string {
+	suffix := ""
+	if trimmed, ok := strings.CutSuffix(glob, "/**"); ok {
+		glob, suffix = trimmed, "(?:/.*)?"
+	}
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch ch := glob[i]; {
+		case ch == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			sb.WriteString(".*")
+			i++
+		case ch == '*':
+			sb.WriteString("[^/]*")
+		case ch == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	return sb.String() + suffix
+}
+
+func (p crawlPattern) matches(u *url.URL) bool {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	switch p.target {
+	case "url":
+		return p.re.MatchString(u.String())
+	case "hostpath":
+		return p.re.MatchString(strings.ToLower(u.Hostname()) + path)
+	default:
+		return p.re.MatchString(path)
+	}
+}
+
+// crawlScope is CrawlRules compiled for one crawl.
+type crawlScope struct {
+	baseHost string
+	domain   string // set when subdomains are followed
+	hosts    []string
+	include  []crawlPattern
+	exclude  []crawlPattern
+	maxDepth int
+}
+
+func newCrawlScope(baseHost string, rules CrawlRules) *crawlScope {
+	s := &crawlScope{baseHost: strings.ToLower(baseHost), hosts: rules.AllowedHosts, maxDepth: rules.MaxDepth}
+	if rules.Subdomains {
+		s.domain = strings.TrimPrefix(s.baseHost, "www.")
+	}
+	// Stored rules were validated on save, so compile errors are not expected.
+	for _, p := range rules.Include {
+		if cp, err := compileCrawlPattern(p); err == nil {
+			s.include = append(s.include, cp)
+		}
+	}
+	for _, p := range rules.Exclude {
+		if cp, err := compileCrawlPattern(p); err == nil {
+			s.exclude = append(s.exclude, cp)
+		}
+	}
+	return s
+}
+
+// allowsHost reports whether links to host may be followed.
+func (s *crawlScope) allowsHost(host string) bool {
+	host = strings.ToLower(host)
+	if host == s.baseHost {
+		return true
+	}
+	if s.domain != "" && (host == s.domain || strings.HasSuffix(host, "."+s.domain)) {
+		return true
+	}
+	for _, h := range s.hosts {
+		if domain, ok := strings.CutPrefix(h, crawlWildcardPrefix); ok {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether u is in scope: an allowed host, not a static asset,
+// and passing the include and exclude patterns.
+func (s *crawlScope) allows(u *url.URL) bool {
+	if !s.allowsHost(u.Hostname()) || shouldSkipCrawlPath(u.Path) {
+		return false
+	}
+	return s.matchesPatterns(u)
+}
+
+func (s *crawlScope) matchesPatterns(u *url.URL) bool {
+	for _, p := range s.exclude {
+		if p.matches(u) {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, p := range s.include {
+		if p.matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsURL is allows for a raw URL.
+func (s *crawlScope) allowsURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && s.allows(u)
+}
+
+// followsFrom reports whether links found at depth are followed.
+func (s *crawlScope) followsFrom(depth int) bool {
+	return s.maxDepth == 0 || depth < s.maxDepth
+}
+
+// sourceCrawlRules returns the crawl rules saved for a scraper source.
+func (c *Controller) sourceCrawlRules(userID, sourceURL string) CrawlRules {
+	var raw []byte
+	var rules CrawlRules
+	if err := c.db.QueryRow(`SELECT crawl_rules FROM scraper_sources WHERE user_id=$1 AND source_url=$2`,
+		userID, sourceURL).Scan(&raw); err != nil {
+		if err != sql.ErrNoRows {
+			c.logger.Warn("scrape source crawl rules query failed", "user_id", userID, "url", sourceURL, "error", err)
+		}
+		return rules
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &rules); err != nil {
+			c.logger.Warn("scrape source crawl rules decode failed", "user_id", userID, "url", sourceURL, "error", err)
+		}
+	}
+	return rules
+}
+
+// crawlRulesJSON returns stored crawl rules for a response; an unset column
+// reads as empty rules.
+func crawlRulesJSON(raw []byte) interface{} {
+	if len(raw) == 0 {
+		return CrawlRules{}
+	}
+	return json.RawMessage(raw)
+}
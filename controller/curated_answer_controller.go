@@ -0,0 +1,560 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"konvoq-backend/platform/vectorstore"
+	"konvoq-backend/utils"
+)
+
+const (
+	maxCuratedAnswers       = 500
+	maxCuratedAlternatives  = 10
+	maxCuratedQuestionChars = 500
+	maxCuratedAnswerChars   = 4000
+	// curatedSourceType marks phrasing vectors in the curated namespace.
+	curatedSourceType = "curated"
+	// curatedVectorTopK is how many phrasing vectors a message is scored
+	// against.
+	curatedVectorTopK = 20
+	// curatedIndexBatch is how many unembedded entries one background pass
+	// indexes.
+	curatedIndexBatch = 50
+)
+
+// CuratedAnswer is a customer-written answer returned verbatim, instead of a
+// generated one, when a message matches its question or an alternative. Rich,
+// when set, is shown under the answer text.
+type CuratedAnswer struct {
+	ID           int64        `json:"id"`
+	Question     string       `json:"question"`
+	Answer       string       `json:"answer"`
+	Alternatives []string     `json:"alternatives"`
+	Rich         *RichMessage `json:"rich"`
+	IsActive     bool         `json:"isActive"`
+	MatchCount   int          `json:"matchCount"`
+	CreatedAt    time.Time    `json:"createdAt"`
+	UpdatedAt    time.Time    `json:"updatedAt"`
+}
+
+type curatedAnswerInput struct {
+	Question     string       `json:"question"`
+	Answer       string       `json:"answer"`
+	Alternatives []string     `json:"alternatives"`
+	Rich         *RichMessage `json:"rich"`
+	IsActive     *bool        `json:"isActive"`
+}
+
+// normalize trims the input, drops empty and duplicate phrasings and checks
+// the size limits.
+func (in *curatedAnswerInput) normalize() error {
+	in.Question = strings.Join(strings.Fields(in.Question), " ")
+	in.Answer = strings.TrimSpace(in.Answer)
+	if in.Question == "" || in.Answer == "" {
+		return errors.New("question and answer are required")
+	}
+	if utf8.RuneCountInString(in.Question) > maxCuratedQuestionChars {
+		return fmt.Errorf("question must be at most %d characters", maxCuratedQuestionChars)
+	}
+	if utf8.RuneCountInString(in.Answer) > maxCuratedAnswerChars {
+		return fmt.Errorf("answer must be at most %d characters", maxCuratedAnswerChars)
+	}
+	seen := map[string]struct{}{strings.ToLower(in.Question): {}}
+	alternatives := make([]string, 0, len(in.Alternatives))
+	for _, alt := range in.Alternatives {
+		alt = strings.Join(strings.Fields(alt), " ")
+		if alt == "" {
+			continue
+		}
+		if _, dup := seen[strings.ToLower(alt)]; dup {
+			continue
+		}
+		if utf8.RuneCountInString(alt) > maxCuratedQuestionChars {
+			return fmt.Errorf("alternatives must be at most %d characters each", maxCuratedQuestionChars)
+		}
+		seen[strings.ToLower(alt)] = struct{}{}
+		alternatives = append(alternatives, alt)
+	}
+	if len(alternatives) > maxCuratedAlternatives {
+		return fmt.Errorf("at most %d alternatives are allowed", maxCuratedAlternatives)
+	}
+	in.Alternatives = alternatives
+	if in.Rich != nil {
+		if err := in.Rich.normalize(); err != nil {
+			return fmt.Errorf("rich: %w", err)
+		}
+		in.Rich.Text = ""
+	}
+	return nil
+}
+
+// richJSON is the rich column value: NULL when the answer is plain text.
+func (in *curatedAnswerInput) richJSON() interface{} {
+	if in.Rich == nil {
+		return nil
+	}
+	b, _ := json.Marshal(in.Rich)
+	return string(b)
+}
+
+func scanCuratedAnswer(row interface{ Scan(...interface{}) error }) (CuratedAnswer, error) {
+	var a CuratedAnswer
+	var altRaw, richRaw []byte
+	if err := row.Scan(&a.ID, &a.Question, &a.Answer, &altRaw, &richRaw, &a.IsActive, &a.MatchCount, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		return a, err
+	}
+	if err := json.Unmarshal(altRaw, &a.Alternatives); err != nil || a.Alternatives == nil {
+		a.Alternatives = []string{}
+	}
+	a.Rich, _ = parseRichMessage(richRaw)
+	return a, nil
+}
+
+const curatedAnswerColumns = `id,question,answer,alternatives,rich,is_active,match_count,created_at,updated_at`
+
+func (c *Controller) ListCuratedAnswers(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	rows, err := c.db.Query(`SELECT `+curatedAnswerColumns+` FROM curated_answers WHERE user_id=$1 ORDER BY updated_at DESC`, claims.UserID)
+	if err != nil {
+		c.logRequestError(r, "list curated answers query failed", err, "user_id", claims.UserID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+	items := []CuratedAnswer{}
+	for rows.Next() {
+		a, err := scanCuratedAnswer(rows)
+		if err != nil {
+			c.logRequestWarn(r, "list curated answers row scan failed", err, "user_id", claims.UserID)
+			continue
+		}
+		items = append(items, a)
+	}
+	utils.JSONOK(w, map[string]interface{}{"success": true, "answers": items})
+}
+
+func (c *Controller) CreateCuratedAnswer(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	if err := c.RequireCSRF(r); err != nil {
+		utils.JSONErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+	var body curatedAnswerInput
+	if err := utils.DecodeJSON(r, &body); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	if err := body.normalize(); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var count int
+	if err := c.db.QueryRow(`SELECT COUNT(*) FROM curated_answers WHERE user_id=$1`, claims.UserID).Scan(&count); err != nil {
+		c.logRequestError(r, "curated answers count failed", err, "user_id", claims.UserID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if count >= maxCuratedAnswers {
+		utils.JSONErr(w, http.StatusBadRequest, fmt.Sprintf("at most %d curated answers are allowed", maxCuratedAnswers))
+		return
+	}
+	isActive := body.IsActive == nil || *body.IsActive
+	altJSON, _ := json.Marshal(body.Alternatives)
+
+	a, err := scanCuratedAnswer(c.db.QueryRow(`INSERT INTO curated_answers (user_id,widget_key_id,question,answer,alternatives,rich,is_active)
+		SELECT $1,w.id,$2,$3,$4::jsonb,$6::jsonb,$5 FROM widget_keys w WHERE w.user_id=$1
+		RETURNING `+curatedAnswerColumns,
+		claims.UserID, body.Question, body.Answer, string(altJSON), isActive, body.richJSON()))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.JSONErr(w, http.StatusNotFound, "widget not found")
+		return
+	}
+	if err != nil {
+		c.logRequestError(r, "create curated answer failed", err, "user_id", claims.UserID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	c.indexCuratedAnswerAsync(claims.UserID, a.ID)
+	utils.JSONOK(w, map[string]interface{}{"success": true, "answer": a})
+}
+
+func (c *Controller) UpdateCuratedAnswer(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	if err := c.RequireCSRF(r); err != nil {
+		utils.JSONErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.JSONErr(w, http.StatusNotFound, "curated answer not found")
+		return
+	}
+	var body curatedAnswerInput
+	if err := utils.DecodeJSON(r, &body); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	if err := body.normalize(); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	altJSON, _ := json.Marshal(body.Alternatives)
+
+	a, err := scanCuratedAnswer(c.db.QueryRow(`UPDATE curated_answers
+		SET question=$3,answer=$4,alternatives=$5::jsonb,rich=$7::jsonb,is_active=COALESCE($6,is_active),
+		    revision=revision+1,updated_at=CURRENT_TIMESTAMP
+		WHERE id=$1 AND user_id=$2
+		RETURNING `+curatedAnswerColumns,
+		id, claims.UserID, body.Question, body.Answer, string(altJSON), body.IsActive, body.richJSON()))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.JSONErr(w, http.StatusNotFound, "curated answer not found")
+		return
+	}
+	if err != nil {
+		c.logRequestError(r, "update curated answer failed", err, "user_id", claims.UserID, "curated_answer_id", id)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	c.indexCuratedAnswerAsync(claims.UserID, a.ID)
+	utils.JSONOK(w, map[string]interface{}{"success": true, "answer": a})
+}
+
+func (c *Controller) DeleteCuratedAnswer(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	if err := c.RequireCSRF(r); err != nil {
+		utils.JSONErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		utils.JSONErr(w, http.StatusNotFound, "curated answer not found")
+		return
+	}
+	result, err := c.db.Exec(`DELETE FROM curated_answers WHERE id=$1 AND user_id=$2`, id, claims.UserID)
+	if err != nil {
+		c.logRequestError(r, "delete curated answer failed", err, "user_id", claims.UserID, "curated_answer_id", id)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		utils.JSONErr(w, http.StatusNotFound, "curated answer not found")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := c.deleteCuratedVectors(ctx, claims.UserID, id); err != nil {
+			c.logger.Warn("curated answer vector delete failed", "user_id", claims.UserID, "curated_answer_id", id, "error", err)
+		}
+	}()
+	utils.JSONOK(w, map[string]interface{}{"success": true})
+}
+
+// curatedMatch is the curated entry chosen for a message.
+type curatedMatch struct {
+	ID        int64
+	Question  string
+	Answer    string
+	Rich      *RichMessage
+	Phrasing  string
+	Score     float64
+	MatchType string
+}
+
+// message is the entry's rich message under its answer, or nil.
+func (m *curatedMatch) message() *RichMessage {
+	return m.Rich.withText(m.Answer)
+}
+
+// reference is what the assistant message records about the entry.
+func (m *curatedMatch) reference() map[string]interface{} {
+	return map[string]interface{}{
+		"id":        m.ID,
+		"question":  m.Question,
+		"phrasing":  m.Phrasing,
+		"score":     m.Score,
+		"matchType": m.MatchType,
+	}
+}
+
+// queryEmbeddingDimension is the dimension retrieval embeds queries with, so
+// curated matching shares the cached query vector.
+func (c *Controller) queryEmbeddingDimension(ctx context.Context) int {
+	if c.vectors == nil {
+		return 0
+	}
+	dimension, err := c.vectors.Dimension(ctx)
+	if err != nil {
+		return 0
+	}
+	return dimension
+}
+
+// curatedNamespace is the vector store namespace holding an owner's curated
+// answer phrasings, kept apart from the knowledge base chunks.
+func curatedNamespace(userID string) string {
+	return "curated_" + strings.TrimPrefix(vectorNamespace(userID), "user_")
+}
+
+func (c *Controller) deleteCuratedVectors(ctx context.Context, ownerID string, id int64) error {
+	if c.vectors == nil {
+		return nil
+	}
+	return c.vectors.DeleteBySource(ctx, curatedNamespace(ownerID), curatedSourceType, strconv.FormatInt(id, 10))
+}
+
+// indexCuratedAnswerAsync stores an entry's phrasing vectors in the
+// background after it is saved.
+func (c *Controller) indexCuratedAnswerAsync(ownerID string, id int64) {
+	if c.vectors == nil || c.embedder == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(withUsageScope(context.Background(), ownerID, usageKindEmbedding), 60*time.Second)
+		defer cancel()
+		if err := c.indexCuratedAnswer(ctx, ownerID, id); err != nil {
+			c.logger.Warn("curated answer indexing failed", "user_id", ownerID, "curated_answer_id", id, "error", err)
+		}
+	}()
+}
+
+// indexCuratedAnswer replaces an entry's phrasing vectors with those of its
+// current revision (none when it is inactive) and records that revision as
+// embedded.
+func (c *Controller) indexCuratedAnswer(ctx context.Context, ownerID string, id int64) error {
+	var question string
+	var altRaw []byte
+	var active bool
+	var revision int
+	err := c.db.QueryRowContext(ctx, `SELECT question,alternatives,is_active,revision FROM curated_answers WHERE id=$1 AND user_id=$2`,
+		id, ownerID).Scan(&question, &altRaw, &active, &revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.deleteCuratedVectors(ctx, ownerID, id)
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.deleteCuratedVectors(ctx, ownerID, id); err != nil {
+		return err
+	}
+	if active {
+		var alternatives []string
+		_ = json.Unmarshal(altRaw, &alternatives)
+		phrasings := append([]string{question}, alternatives...)
+		vectors, err := c.embedTexts(ctx, phrasings, c.queryEmbeddingDimension(ctx))
+		if err != nil {
+			return err
+		}
+		records := make([]vectorstore.Record, 0, len(phrasings))
+		for i, text := range phrasings {
+			if len(vectors[i]) == 0 {
+				continue
+			}
+			records = append(records, vectorstore.Record{
+				ID:         fmt.Sprintf("curated-%d-%d", id, i),
+				Values:     vectors[i],
+				UserID:     ownerID,
+				Text:       text,
+				ChunkIndex: i,
+				SourceType: curatedSourceType,
+				SourceKey:  strconv.FormatInt(id, 10),
+			})
+		}
+		if len(records) < len(phrasings) {
+			return errors.New("phrasing embeddings incomplete")
+		}
+		if err := c.vectors.Upsert(ctx, curatedNamespace(ownerID), records); err != nil {
+			return err
+		}
+	}
+	_, err = c.db.ExecContext(ctx, `UPDATE curated_answers SET embedded_revision=$2 WHERE id=$1 AND revision=$2`, id, revision)
+	return err
+}
+
+// indexPendingCuratedAnswers indexes entries saved since their phrasings
+// were last embedded, including entries created before vectors were stored.
+func (c *Controller) indexPendingCuratedAnswers(ctx context.Context) {
+	if c.vectors == nil || c.embedder == nil {
+		return
+	}
+	rows, err := c.db.QueryContext(ctx, `SELECT id,user_id FROM curated_answers
+		WHERE embedded_revision IS DISTINCT FROM revision
+		ORDER BY id
+		LIMIT $1`, curatedIndexBatch)
+	if err != nil {
+		c.logger.Warn("pending curated answers query failed", "error", err)
+		return
+	}
+	type pending struct {
+		id      int64
+		ownerID string
+	}
+	var items []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.ownerID); err != nil {
+			c.logger.Warn("pending curated answers row scan failed", "error", err)
+			continue
+		}
+		items = append(items, p)
+	}
+	rows.Close()
+	for _, p := range items {
+		itemCtx, cancel := context.WithTimeout(withUsageScope(ctx, p.ownerID, usageKindEmbedding), 60*time.Second)
+		if err := c.indexCuratedAnswer(itemCtx, p.ownerID, p.id); err != nil {
+			c.logger.Warn("curated answer indexing failed", "user_id", p.ownerID, "curated_answer_id", p.id, "error", err)
+		}
+		cancel()
+	}
+}
+
+// curatedVectorScores embeds the query and returns its similarity to the
+// nearest stored phrasings, keyed by entry ID and phrasing text.
+func (c *Controller) curatedVectorScores(ctx context.Context, ownerID, query string) map[string]float64 {
+	scores := map[string]float64{}
+	if c.vectors == nil {
+		return scores
+	}
+	emb, err := c.embedText(ctx, query, c.queryEmbeddingDimension(ctx))
+	if err != nil || len(emb) == 0 {
+		if err != nil {
+			c.logger.Warn("curated answer query embedding failed; using keyword matching", "user_id", ownerID, "error", err)
+		}
+		return scores
+	}
+	matches, err := c.vectors.Query(ctx, curatedNamespace(ownerID), emb, curatedVectorTopK)
+	if err != nil {
+		c.logger.Warn("curated answer vector query failed; using keyword matching", "user_id", ownerID, "error", err)
+		return scores
+	}
+	for _, m := range matches {
+		key, _ := m.Metadata["sourceKey"].(string)
+		text, _ := m.Metadata["text"].(string)
+		k := curatedPhrasingKey(key, text)
+		scores[k] = max(scores[k], m.Score)
+	}
+	return scores
+}
+
+func curatedPhrasingKey(entryID, text string) string {
+	return entryID + "\n" + text
+}
+
+// matchCuratedAnswer returns the owner's curated entry that best matches the
+// query, or nil when none reaches the widget's curatedMinScore. Each phrasing
+// is scored by the similarity of its stored vector to the query's and by
+// keyword overlap, keeping the higher of the two, so an exact rewording still
+// matches when embeddings are unavailable or not yet stored. Only the query
+// is embedded.
+func (c *Controller) matchCuratedAnswer(ctx context.Context, ownerID, query string) *curatedMatch {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil
+	}
+	rows, err := c.db.QueryContext(ctx, `SELECT id,question,answer,alternatives,rich FROM curated_answers
+		WHERE user_id=$1 AND is_active=TRUE
+		ORDER BY id
+		LIMIT $2`, ownerID, maxCuratedAnswers)
+	if err != nil {
+		c.logger.Warn("curated answers query failed", "user_id", ownerID, "error", err)
+		return nil
+	}
+	type phrasing struct {
+		entry int
+		text  string
+	}
+	var entries []curatedMatch
+	var phrasings []phrasing
+	for rows.Next() {
+		var m curatedMatch
+		var altRaw, richRaw []byte
+		if err := rows.Scan(&m.ID, &m.Question, &m.Answer, &altRaw, &richRaw); err != nil {
+			c.logger.Warn("curated answers row scan failed", "user_id", ownerID, "error", err)
+			continue
+		}
+		var alternatives []string
+		_ = json.Unmarshal(altRaw, &alternatives)
+		m.Rich, _ = parseRichMessage(richRaw)
+		entries = append(entries, m)
+		for _, text := range append([]string{m.Question}, alternatives...) {
+			phrasings = append(phrasings, phrasing{entry: len(entries) - 1, text: text})
+		}
+	}
+	rows.Close()
+	if len(phrasings) == 0 {
+		return nil
+	}
+
+	// Vectors are matched by phrasing text, so those of an older revision
+	// that is still being re-indexed are ignored.
+	vectorScores := c.curatedVectorScores(ctx, ownerID, query)
+	queryTerms := knowledgeGapTerms(query)
+	var best *curatedMatch
+	for _, p := range phrasings {
+		score, matchType := keywordSimilarity(queryTerms, knowledgeGapTerms(p.text)), "keyword"
+		if strings.EqualFold(strings.Join(strings.Fields(query), " "), p.text) {
+			score = 1
+		}
+		if sim := vectorScores[curatedPhrasingKey(strconv.FormatInt(entries[p.entry].ID, 10), p.text)]; sim > score {
+			score, matchType = sim, "embedding"
+		}
+		if best == nil || score > best.Score {
+			m := entries[p.entry]
+			m.Phrasing, m.Score, m.MatchType = p.text, score, matchType
+			best = &m
+		}
+	}
+	if best == nil || best.Score < c.loadRetrievalSettings(ctx, ownerID).CuratedMinScore {
+		return nil
+	}
+	go func(id int64) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := c.db.ExecContext(ctx, `UPDATE curated_answers SET match_count=match_count+1,last_matched_at=CURRENT_TIMESTAMP WHERE id=$1`, id); err != nil {
+			c.logger.Warn("curated answer match count update failed", "curated_answer_id", id, "error", err)
+		}
+	}(best.ID)
+	return best
+}
+
+// keywordSimilarity is the Jaccard similarity of two keyword sets.
+func keywordSimilarity(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	set := make(map[string]struct{}, len(b))
+	for _, t := range b {
+		set[t] = struct{}{}
+	}
+	shared := 0
+	for _, t := range a {
+		if _, ok := set[t]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(set)-shared)
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
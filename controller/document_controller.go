@@ -5,14 +5,17 @@ import (
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"konvoq-backend/platform/chunker"
 	"konvoq-backend/utils"
 )
 
@@ -74,9 +77,16 @@ func (c *Controller) UploadDocument(w http.ResponseWriter, r *http.Request, clai
 		utils.JSONErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	chunking, err := documentChunkOptions(r)
+	if err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	var id string
-	err = c.db.QueryRow(`INSERT INTO documents (user_id,file_name,file_size,mime_type) VALUES ($1,$2,$3,$4) RETURNING id`,
-		claims.UserID, name, size, utils.Nullable(mime)).Scan(&id)
+	err = c.db.QueryRow(`INSERT INTO documents (user_id,file_name,file_size,mime_type,chunk_max_tokens,chunk_overlap_tokens)
+		VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`,
+		claims.UserID, name, size, utils.Nullable(mime),
+		nullableChunkSetting(chunking.MaxTokens), nullableChunkOverlap(chunking.OverlapTokens)).Scan(&id)
 	if err != nil {
 		c.logRequestError(r, "upload document insert failed", err, "user_id", claims.UserID, "file_name", name)
 		utils.JSONErr(w, http.StatusInternalServerError, "db error")
@@ -90,7 +100,7 @@ func (c *Controller) UploadDocument(w http.ResponseWriter, r *http.Request, clai
 					c.docSem <- struct{}{}        // acquire semaphore slot
 					defer func() { <-c.docSem }() // release on done
 					if text := extractDocumentText(docName, docMime, data); text != "" {
-						if err := c.pineconeUpsert(claims.UserID, "doc:"+docID, text); err != nil {
+						if err := c.indexDocument(claims.UserID, docID, docName, text, chunking); err != nil {
 							c.logger.Warn("document index upsert failed", "user_id", claims.UserID, "document_id", docID, "error", err)
 						}
 					}
@@ -108,6 +118,11 @@ func (c *Controller) UploadMultipleDocuments(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	files := r.MultipartForm.File["documents"]
+	chunking, err := documentChunkOptions(r)
+	if err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	limits := limitsForPlan(user.PlanType)
 	var currentDocs int
 	if err := c.db.QueryRow(`SELECT COUNT(*) FROM documents WHERE user_id=$1`, claims.UserID).Scan(&currentDocs); err != nil {
@@ -133,8 +148,10 @@ func (c *Controller) UploadMultipleDocuments(w http.ResponseWriter, r *http.Requ
 	for _, fh := range files {
 		name, size, mime := docFromHeader(fh)
 		var id string
-		if err := c.db.QueryRow(`INSERT INTO documents (user_id,file_name,file_size,mime_type) VALUES ($1,$2,$3,$4) RETURNING id`,
-			claims.UserID, name, size, utils.Nullable(mime)).Scan(&id); err != nil {
+		if err := c.db.QueryRow(`INSERT INTO documents (user_id,file_name,file_size,mime_type,chunk_max_tokens,chunk_overlap_tokens)
+			VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`,
+			claims.UserID, name, size, utils.Nullable(mime),
+			nullableChunkSetting(chunking.MaxTokens), nullableChunkOverlap(chunking.OverlapTokens)).Scan(&id); err != nil {
 			c.logRequestWarn(r, "batch upload document insert failed", err, "user_id", claims.UserID, "file_name", name)
 		}
 		items = append(items, map[string]interface{}{"id": id, "fileName": name, "size": size, "mimeType": mime})
@@ -146,7 +163,7 @@ func (c *Controller) UploadMultipleDocuments(w http.ResponseWriter, r *http.Requ
 						c.docSem <- struct{}{}        // acquire semaphore slot
 						defer func() { <-c.docSem }() // release on done
 						if text := extractDocumentText(docName, docMime, data); text != "" {
-							if err := c.pineconeUpsert(claims.UserID, "doc:"+docID, text); err != nil {
+							if err := c.indexDocument(claims.UserID, docID, docName, text, chunking); err != nil {
 								c.logger.Warn("document batch index upsert failed", "user_id", claims.UserID, "document_id", docID, "error", err)
 							}
 						}
@@ -165,12 +182,12 @@ func (c *Controller) DeleteDocument(w http.ResponseWriter, r *http.Request, clai
 		utils.JSONErr(w, http.StatusBadRequest, "document id is required")
 		return
 	}
-	if err := c.pineconeDeleteBySource(claims.UserID, "document", id); err != nil {
+	if err := c.vectorDeleteBySource(claims.UserID, "document", id); err != nil {
 		c.logRequestError(r, "delete document vectors failed", err, "user_id", claims.UserID, "document_id", id)
 		utils.JSONErr(w, http.StatusBadGateway, "failed to remove document vectors")
 		return
 	}
-	if err := c.pineconeDeleteByURL(claims.UserID, "doc:"+id); err != nil {
+	if err := c.vectorDeleteByURL(claims.UserID, "doc:"+id); err != nil {
 		c.logRequestWarn(r, "delete document legacy vector cleanup failed", err, "user_id", claims.UserID, "document_id", id)
 	}
 
@@ -189,9 +206,62 @@ func (c *Controller) DeleteDocument(w http.ResponseWriter, r *http.Request, clai
 	utils.JSONOK(w, map[string]interface{}{"success": true})
 }
 
+// documentChunkOptions reads the optional chunkMaxTokens and
+// chunkOverlapTokens form fields of an upload.
+func documentChunkOptions(r *http.Request) (chunker.Options, error) {
+	var opts chunker.Options
+	maxTokens, err := formIntField(r, "chunkMaxTokens")
+	if err != nil {
+		return opts, err
+	}
+	if maxTokens != nil {
+		opts.MaxTokens = *maxTokens
+	}
+	if opts.OverlapTokens, err = formIntField(r, "chunkOverlapTokens"); err != nil {
+		return opts, err
+	}
+	return opts, opts.Validate()
+}
+
+// formIntField parses an optional integer form field; it is nil when empty.
+func formIntField(r *http.Request, name string) (*int, error) {
+	raw := strings.TrimSpace(r.FormValue(name))
+	if raw == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an integer", name)
+	}
+	return &n, nil
+}
+
+// indexDocument chunks extracted document text by its Markdown structure and
+// indexes it under the document's source key.
+func (c *Controller) indexDocument(userID, docID, fileName, text string, opts chunker.Options) error {
+	widgetKey := c.userWidgetKey(userID)
+	pieces := chunker.SplitMarkdown(text, opts)
+	chunks := make([]ragChunk, 0, len(pieces))
+	for i, piece := range pieces {
+		chunks = append(chunks, ragChunk{
+			URL:         "doc:" + docID,
+			PageTitle:   fileName,
+			HeadingPath: strings.Join(piece.HeadingPath, " > "),
+			Text:        piece.Text,
+			ChunkIndex:  i,
+			WidgetKey:   widgetKey,
+			SourceType:  "document",
+			SourceKey:   docID,
+		})
+	}
+	return c.vectorUpsertChunks(userID, chunks)
+}
+
 func extractDocumentText(filename, mime string, data []byte) string {
 	ext := strings.ToLower(filepath.Ext(filename))
 	switch {
+	case ext == ".md" || ext == ".markdown" || strings.Contains(mime, "text/markdown"):
+		return strings.TrimSpace(string(data))
 	case ext == ".txt" || strings.Contains(mime, "text/plain"):
 		return strings.TrimSpace(string(data))
 	case ext == ".csv" || strings.Contains(mime, "text/csv") || strings.Contains(mime, "application/csv"):
@@ -201,6 +271,8 @@ func extractDocumentText(filename, mime string, data []byte) string {
 	}
 }
 
+// extractCSVText renders rows as a Markdown table with the first row as the
+// header, so large files are chunked between rows with the header repeated.
 func extractCSVText(data []byte) string {
 	reader := csv.NewReader(bytes.NewReader(data))
 	reader.LazyQuotes = true
@@ -210,9 +282,15 @@ func extractCSVText(data []byte) string {
 		return strings.TrimSpace(string(data))
 	}
 	var sb strings.Builder
-	for _, row := range records {
-		sb.WriteString(strings.Join(row, " | "))
-		sb.WriteString("\n")
+	for i, row := range records {
+		cells := make([]string, len(row))
+		for j, cell := range row {
+			cells[j] = strings.ReplaceAll(normalizeWhitespace(cell), "|", "/")
+		}
+		sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		if i == 0 {
+			sb.WriteString("|" + strings.Repeat(" --- |", len(row)) + "\n")
+		}
 	}
 	return strings.TrimSpace(sb.String())
 }
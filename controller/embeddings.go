@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"konvoq-backend/platform/chunker"
+	"konvoq-backend/platform/llm"
+)
+
+const (
+	// embeddingBatchSize keeps each request well under provider input limits
+	// for 500-word chunks.
+	embeddingBatchSize = 64
+	embeddingCacheTTL  = 30 * 24 * time.Hour
+)
+
+// normalizeEmbeddingInput collapses whitespace so cosmetic HTML changes do not
+// invalidate cached vectors.
+func normalizeEmbeddingInput(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// embeddingCacheKey identifies a vector by provider, model, dimension and a
+// hash of the normalized text.
+func (c *Controller) embeddingCacheKey(text string, dimensions int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s", c.embedder.Name(), c.cfg.EmbeddingModel, dimensions, text)))
+	return "emb:" + hex.EncodeToString(sum[:])
+}
+
+// embedTexts returns one vector per input, in input order. Vectors are read
+// from the Redis cache where possible; the misses are embedded in batches and
+// written back. Inputs that could not be embedded get a nil vector, and the
+// first provider error is returned alongside the partial result.
+func (c *Controller) embedTexts(ctx context.Context, inputs []string, dimensions int) ([][]float64, error) {
+	vectors := make([][]float64, len(inputs))
+	if c.embedder == nil || len(inputs) == 0 {
+		return vectors, nil
+	}
+
+	normalized := make([]string, len(inputs))
+	keys := make([]string, len(inputs))
+	for i, input := range inputs {
+		normalized[i] = normalizeEmbeddingInput(input)
+		keys[i] = c.embeddingCacheKey(normalized[i], dimensions)
+	}
+	c.loadCachedEmbeddings(ctx, keys, vectors)
+
+	// Identical chunks (shared headers, footers) are embedded once.
+	missByKey := map[string][]int{}
+	missKeys := make([]string, 0)
+	for i, v := range vectors {
+		if v != nil || normalized[i] == "" {
+			continue
+		}
+		if _, seen := missByKey[keys[i]]; !seen {
+			missKeys = append(missKeys, keys[i])
+		}
+		missByKey[keys[i]] = append(missByKey[keys[i]], i)
+	}
+
+	var firstErr error
+	for start := 0; start < len(missKeys); start += embeddingBatchSize {
+		end := start + embeddingBatchSize
+		if end > len(missKeys) {
+			end = len(missKeys)
+		}
+		batchKeys := missKeys[start:end]
+		batch := make([]string, len(batchKeys))
+		for j, key := range batchKeys {
+			batch[j] = normalized[missByKey[key][0]]
+		}
+		batchCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		resp, err := c.embedder.EmbedBatch(batchCtx, llm.EmbeddingBatchRequest{
+			Model:      c.cfg.EmbeddingModel,
+			Inputs:     batch,
+			Dimensions: dimensions,
+		})
+		cancel()
+		if err != nil {
+			c.logLLMError("embedding batch request failed", c.embedder.Name(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		usage := resp.Usage
+		if usage.Total() == 0 {
+			for _, input := range batch {
+				usage.PromptTokens += chunker.CountTokens(input)
+			}
+		}
+		c.recordLLMUsage(ctx, usageKindEmbedding, coalesce(resp.Model, c.cfg.EmbeddingModel), usage)
+		fresh := make(map[string][]float64, len(batchKeys))
+		for j, key := range batchKeys {
+			if j >= len(resp.Vectors) || len(resp.Vectors[j]) == 0 {
+				continue
+			}
+			fresh[key] = resp.Vectors[j]
+			for _, i := range missByKey[key] {
+				vectors[i] = resp.Vectors[j]
+			}
+		}
+		c.storeCachedEmbeddings(ctx, fresh)
+	}
+	return vectors, firstErr
+}
+
+func (c *Controller) loadCachedEmbeddings(ctx context.Context, keys []string, vectors [][]float64) {
+	if c.redis == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	cached, err := c.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		c.logger.Warn("embedding cache read failed", "error", err)
+		return
+	}
+	for i, raw := range cached {
+		if s, ok := raw.(string); ok {
+			vectors[i] = decodeEmbedding([]byte(s))
+		}
+	}
+}
+
+func (c *Controller) storeCachedEmbeddings(ctx context.Context, fresh map[string][]float64) {
+	if c.redis == nil || len(fresh) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	_, err := c.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, vec := range fresh {
+			pipe.Set(ctx, key, encodeEmbedding(vec), embeddingCacheTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		c.logger.Warn("embedding cache write failed", "error", err)
+	}
+}
+
+// encodeEmbedding packs a vector as little-endian float32s (4 bytes per
+// dimension), which is the precision providers return anyway.
+func encodeEmbedding(vec []float64) []byte {
+	buf := make([]byte, 4*len(vec))
+	for i, f := range vec {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(f)))
+	}
+	return buf
+}
+
+func decodeEmbedding(buf []byte) []float64 {
+	if len(buf) == 0 || len(buf)%4 != 0 {
+		return nil
+	}
+	vec := make([]float64, len(buf)/4)
+	for i := range vec {
+		vec[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:])))
+	}
+	return vec
+}
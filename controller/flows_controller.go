@@ -3,6 +3,7 @@ package controller
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -70,6 +71,10 @@ func (c *Controller) CreateFlow(w http.ResponseWriter, r *http.Request, claims T
 	if body.FlowData == nil {
 		body.FlowData = map[string]interface{}{"nodes": []interface{}{}, "edges": []interface{}{}}
 	}
+	if err := normalizeFlowMessages(body.FlowData); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	flowJSON, _ := json.Marshal(body.FlowData)
 
 	// If setting as active, deactivate others first
@@ -132,6 +137,10 @@ func (c *Controller) UpdateFlow(w http.ResponseWriter, r *http.Request, claims T
 		utils.JSONErr(w, http.StatusBadRequest, "invalid payload")
 		return
 	}
+	if err := normalizeFlowMessages(body.FlowData); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	flowJSON, _ := json.Marshal(body.FlowData)
 	if body.IsActive {
@@ -163,3 +172,28 @@ func (c *Controller) DeleteFlow(w http.ResponseWriter, r *http.Request, claims T
 	}
 	utils.JSONOK(w, map[string]interface{}{"success": true})
 }
+
+// normalizeFlowMessages checks the rich message (data.rich) of each flow node
+// that has one and stores it normalized, so flows send the same payloads as
+// curated answers and tools.
+func normalizeFlowMessages(flowData map[string]interface{}) error {
+	nodes, _ := flowData["nodes"].([]interface{})
+	for i, n := range nodes {
+		node, _ := n.(map[string]interface{})
+		data, _ := node["data"].(map[string]interface{})
+		if data == nil || data["rich"] == nil {
+			continue
+		}
+		raw, _ := json.Marshal(data["rich"])
+		rich, err := parseRichMessage(raw)
+		if err != nil {
+			id, _ := node["id"].(string)
+			if id == "" {
+				id = fmt.Sprintf("#%d", i+1)
+			}
+			return fmt.Errorf("flow node %s: %v", id, err)
+		}
+		data["rich"] = rich
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"context"
 	"net/http"
 	"strings"
 	"time"
@@ -192,15 +193,7 @@ func (c *Controller) RequestHandoff(w http.ResponseWriter, r *http.Request, clai
 		return
 	}
 
-	var id string
-	err := c.db.QueryRow(`
-		INSERT INTO handoff_requests (user_id, session_id, visitor_name, visitor_email, trigger_reason)
-		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
-		claims.UserID,
-		strings.TrimSpace(body.SessionID),
-		utils.Nullable(strings.TrimSpace(body.VisitorName)),
-		utils.Nullable(strings.TrimSpace(body.VisitorEmail)),
-		utils.Nullable(strings.TrimSpace(body.TriggerReason))).Scan(&id)
+	id, err := c.createHandoff(r.Context(), claims.UserID, body.SessionID, body.VisitorName, body.VisitorEmail, body.TriggerReason)
 	if err != nil {
 		c.logRequestError(r, "request handoff insert failed", err, "user_id", claims.UserID)
 		utils.JSONErr(w, http.StatusInternalServerError, "db error")
@@ -208,3 +201,18 @@ func (c *Controller) RequestHandoff(w http.ResponseWriter, r *http.Request, clai
 	}
 	utils.JSONOK(w, map[string]interface{}{"success": true, "handoffId": id})
 }
+
+// createHandoff opens a pending handoff request for a conversation; it is also
+// used by the request_handoff chat tool.
+func (c *Controller) createHandoff(ctx context.Context, ownerID, sessionID, visitorName, visitorEmail, reason string) (string, error) {
+	var id string
+	err := c.db.QueryRowContext(ctx, `
+		INSERT INTO handoff_requests (user_id, session_id, visitor_name, visitor_email, trigger_reason)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		ownerID,
+		strings.TrimSpace(sessionID),
+		utils.Nullable(strings.TrimSpace(visitorName)),
+		utils.Nullable(strings.TrimSpace(visitorEmail)),
+		utils.Nullable(strings.TrimSpace(reason))).Scan(&id)
+	return id, err
+}
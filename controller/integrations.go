@@ -1,24 +1,27 @@
 package controller
 
 import (
-	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"math"
 	"net/http"
 	"net/smtp"
-	"net/url"
 	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 
+	"konvoq-backend/config"
+	"konvoq-backend/platform/llm"
+	"konvoq-backend/platform/vectorstore"
 	"konvoq-backend/utils"
 )
 
@@ -292,63 +295,168 @@ func displayNameFromEmail(email string) string {
 	return result
 }
 
-// ── OpenAI ─────────────────────────────────────────────────────────────────────
+// ── LLM ────────────────────────────────────────────────────────────────────────
+
+func newLLMClients(cfg config.Config, logger *slog.Logger) (llm.Provider, llm.Embedder) {
+	chat, embedder, err := llm.New(llm.Config{
+		Provider:                 cfg.LLMProvider,
+		EmbeddingProvider:        cfg.EmbeddingProvider,
+		OpenAIAPIKey:             cfg.OpenAIAPIKey,
+		OpenAIBaseURL:            cfg.OpenAIBaseURL,
+		AzureEndpoint:            cfg.AzureOpenAIEndpoint,
+		AzureAPIKey:              cfg.AzureOpenAIAPIKey,
+		AzureAPIVersion:          cfg.AzureOpenAIAPIVersion,
+		AzureChatDeployment:      cfg.AzureOpenAIChatDeployment,
+		AzureEmbeddingDeployment: cfg.AzureOpenAIEmbeddingDeployment,
+		AnthropicAPIKey:          cfg.AnthropicAPIKey,
+		AnthropicBaseURL:         cfg.AnthropicBaseURL,
+		CompatibleBaseURL:        cfg.LLMBaseURL,
+		CompatibleAPIKey:         cfg.LLMAPIKey,
+	})
+	if err != nil {
+		logger.Error("llm provider setup failed; AI answers are disabled", "provider", cfg.LLMProvider, "error", err)
+		return nil, nil
+	}
+	if chat == nil {
+		logger.Warn("llm provider has no credentials; AI answers are disabled", "provider", cfg.LLMProvider)
+	}
+	return chat, embedder
+}
 
-func (c *Controller) openAIChat(message string) (string, error) {
-	if strings.TrimSpace(c.cfg.OpenAIAPIKey) == "" {
-		return "", nil
+func (c *Controller) llmChat(ctx context.Context, req llm.ChatRequest) (string, error) {
+	resp, err := c.llmChatResponse(ctx, req)
+	return strings.TrimSpace(resp.Content), err
+}
+
+// llmChatResponse is llmChat returning the whole response, tool calls
+// included.
+func (c *Controller) llmChatResponse(ctx context.Context, req llm.ChatRequest) (llm.ChatResponse, error) {
+	if c.llm == nil {
+		return llm.ChatResponse{}, nil
 	}
-	payload := map[string]interface{}{
-		"model": c.cfg.OpenAIModel,
-		"messages": []map[string]string{
-			{"role": "system", "content": "You are Witzo AI assistant."},
-			{"role": "user", "content": message},
-		},
+	if c.spendCapReached(ctx) {
+		return llm.ChatResponse{}, errSpendCapReached
 	}
-	b, _ := json.Marshal(payload)
-	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/chat/completions", bytes.NewReader(b))
-	if err != nil {
-		c.logger.Error("openai request build failed", "error", err)
-		return "", err
+	if strings.TrimSpace(req.Model) == "" {
+		req.Model = c.cfg.ChatModel
 	}
-	req.Header.Set("Authorization", "Bearer "+c.cfg.OpenAIAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
-	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.llm.Chat(ctx, req)
 	if err != nil {
-		c.logger.Warn("openai request failed", "error", err)
-		return "", err
+		c.logLLMError("llm chat request failed", c.llm.Name(), err)
+		return llm.ChatResponse{}, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		err := fmt.Errorf("openai status %d", resp.StatusCode)
-		c.logger.Warn("openai returned non-success status", "status_code", resp.StatusCode, "response", strings.TrimSpace(string(body)))
-		return "", err
+	c.recordChatUsage(ctx, req, resp)
+	return resp, nil
+}
+
+// llmChatStream forwards completion fragments to onDelta as they arrive.
+// Providers without streaming support deliver the whole answer as one delta.
+func (c *Controller) llmChatStream(ctx context.Context, req llm.ChatRequest, onDelta func(string) error) (string, error) {
+	if c.llm == nil {
+		return "", nil
 	}
-	var out struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+	streamer, ok := c.llm.(llm.Streamer)
+	if !ok {
+		answer, err := c.llmChat(ctx, req)
+		if err != nil || answer == "" {
+			return answer, err
+		}
+		return answer, onDelta(answer)
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		c.logger.Warn("openai decode failed", "error", err)
-		return "", err
+	if c.spendCapReached(ctx) {
+		return "", errSpendCapReached
+	}
+	if strings.TrimSpace(req.Model) == "" {
+		req.Model = c.cfg.ChatModel
 	}
-	if len(out.Choices) == 0 {
+	ctx, cancel := context.WithTimeout(ctx, 45*time.Second)
+	defer cancel()
+	resp, err := streamer.ChatStream(ctx, req, onDelta)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		c.logLLMError("llm chat stream failed", c.llm.Name(), err)
+	}
+	// Interrupted streams are still billed for what was generated.
+	c.recordChatUsage(ctx, req, resp)
+	return strings.TrimSpace(resp.Content), err
+}
+
+// ragPrompt carries everything that shapes one grounded answer.
+type ragPrompt struct {
+	System  string // assembled by buildSystemPrompt; empty uses the default assistant
+	Query   string
+	History []llm.Message
+	Matches []map[string]interface{}
+	AI      AISettings // per-widget model, temperature, length and fallback reply
+	Tools   *chatTools // actions the model may take; nil answers from context only
+}
+
+func (c *Controller) answerWithContext(ctx context.Context, p ragPrompt) (string, error) {
+	if c.llm == nil {
 		return "", nil
 	}
-	return strings.TrimSpace(out.Choices[0].Message.Content), nil
+	req := llm.ChatRequest{Messages: ragMessages(p)}
+	p.AI.apply(&req)
+	if p.Tools != nil {
+		return c.answerWithTools(ctx, req, p.Tools)
+	}
+	return c.llmChat(ctx, req)
 }
 
-func (c *Controller) openAIAnswerWithContext(query string, matches []map[string]interface{}) (string, error) {
-	if strings.TrimSpace(c.cfg.OpenAIAPIKey) == "" {
+// streamAnswerWithContext is answerWithContext with incremental output. On
+// error the returned string holds whatever was generated before the failure.
+func (c *Controller) streamAnswerWithContext(ctx context.Context, p ragPrompt, onDelta func(string) error) (string, error) {
+	if c.llm == nil {
 		return "", nil
 	}
+	req := llm.ChatRequest{Messages: ragMessages(p)}
+	p.AI.apply(&req)
+	if p.Tools != nil {
+		// Tool calls are only reported by non-streamed requests, so the
+		// answer is generated in full and sent as one delta.
+		answer, err := c.answerWithTools(ctx, req, p.Tools)
+		if err != nil || answer == "" {
+			return answer, err
+		}
+		return answer, onDelta(answer)
+	}
+	return c.llmChatStream(ctx, req, onDelta)
+}
+
+// ragMessages builds the prompt: system turn, prior conversation turns, then
+// the current question wrapped with the retrieved context.
+func ragMessages(p ragPrompt) []llm.Message {
+	contextBlock := ragContextBlock(p.Matches)
+	hints := ""
+	if name := supportedLanguages[p.AI.language]; name != "" {
+		hints += fmt.Sprintf("Reply in %s, whatever language the context is written in.\n", name)
+	}
+	if p.Tools != nil {
+		hints += p.Tools.promptHint() + "\n"
+	}
+	prompt := fmt.Sprintf(`You are a helpful assistant for this business.
+Use only the context below to answer the question.
+If the answer is not in the context, reply exactly:
+"%s"
+%s
+Context:
+%s
+
+User Question: %s`, p.AI.fallbackAnswer(), hints, contextBlock, p.Query)
+	system := strings.TrimSpace(p.System)
+	if system == "" {
+		system = buildSystemPrompt(promptProfile{})
+	}
+	messages := make([]llm.Message, 0, len(p.History)+2)
+	messages = append(messages, llm.Message{Role: "system", Content: system})
+	messages = append(messages, p.History...)
+	return append(messages, llm.Message{Role: "user", Content: prompt})
+}
+
+// ragContextBlock formats retrieved chunks with their source and section for
+// a prompt.
+func ragContextBlock(matches []map[string]interface{}) string {
 	contextParts := make([]string, 0, len(matches))
 	for _, m := range matches {
 		meta, ok := m["metadata"].(map[string]interface{})
@@ -357,6 +465,7 @@ func (c *Controller) openAIAnswerWithContext(query string, matches []map[string]
 		}
 		text, _ := meta["text"].(string)
 		sourceURL, _ := meta["url"].(string)
+		section, _ := meta["headingPath"].(string)
 		text = strings.TrimSpace(text)
 		if text == "" {
 			continue
@@ -364,178 +473,135 @@ func (c *Controller) openAIAnswerWithContext(query string, matches []map[string]
 		if len(text) > 1000 {
 			text = text[:1000]
 		}
+		if strings.TrimSpace(section) != "" {
+			text = fmt.Sprintf("Section: %s\n%s", strings.TrimSpace(section), text)
+		}
 		if strings.TrimSpace(sourceURL) != "" {
 			contextParts = append(contextParts, fmt.Sprintf("Source: %s\n%s", sourceURL, text))
 		} else {
 			contextParts = append(contextParts, text)
 		}
 	}
-	contextBlock := "No indexed context was retrieved."
-	if len(contextParts) > 0 {
-		contextBlock = strings.Join(contextParts, "\n\n---\n\n")
+	if len(contextParts) == 0 {
+		return "No indexed context was retrieved."
 	}
-	prompt := fmt.Sprintf(`You are a helpful assistant for this business.
-Use only the context below to answer the question.
-If the answer is not in the context, reply exactly:
-"I don't have information about that. Please contact support."
-
-Context:
-%s
-
-User Question: %s`, contextBlock, query)
-	return c.openAIChat(prompt)
+	return strings.Join(contextParts, "\n\n---\n\n")
 }
 
-func (c *Controller) openAIEmbedding(input string, dimensions int) ([]float64, error) {
-	if strings.TrimSpace(c.cfg.OpenAIAPIKey) == "" {
-		return nil, nil
-	}
-	payload := map[string]interface{}{"model": "text-embedding-3-small", "input": input}
-	if dimensions > 0 {
-		payload["dimensions"] = dimensions
-	}
-	b, _ := json.Marshal(payload)
-	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/embeddings", bytes.NewReader(b))
-	if err != nil {
-		c.logger.Error("openai embedding request build failed", "error", err)
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+c.cfg.OpenAIAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		c.logger.Warn("openai embedding request failed", "error", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		err := fmt.Errorf("embedding status %d", resp.StatusCode)
-		c.logger.Warn("openai embedding returned non-success status", "status_code", resp.StatusCode, "response", strings.TrimSpace(string(body)))
-		return nil, err
-	}
-	var out struct {
-		Data []struct {
-			Embedding []float64 `json:"embedding"`
-		} `json:"data"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		c.logger.Warn("openai embedding decode failed", "error", err)
-		return nil, err
-	}
-	if len(out.Data) == 0 {
-		return nil, nil
-	}
-	return out.Data[0].Embedding, nil
+const ragFallbackAnswer = "I don't have information about that. Please contact support."
+
+// answerSource is one citation returned with an answer and stored in the
+// assistant message metadata.
+type answerSource struct {
+	ChunkID    string  `json:"chunkId"`
+	URL        string  `json:"url,omitempty"`
+	Title      string  `json:"title"`
+	Section    string  `json:"section,omitempty"`
+	SourceType string  `json:"sourceType"`
+	SourceKey  string  `json:"sourceKey,omitempty"`
+	Score      float64 `json:"score"`
 }
 
-// ── Pinecone ───────────────────────────────────────────────────────────────────
-
-type pineconeIndexInfo struct {
-	Host      string
-	Dimension int
-}
-
-func (c *Controller) pineconeIndexInfo() (pineconeIndexInfo, error) {
-	info := pineconeIndexInfo{
-		Host:      normalizePineconeHost(c.cfg.PineconeHost),
-		Dimension: c.cfg.PineconeDimension,
-	}
-
-	if strings.TrimSpace(c.cfg.PineconeIndexName) == "" || strings.TrimSpace(c.cfg.PineconeAPIKey) == "" {
-		if info.Host == "" {
-			return info, errors.New("missing pinecone index configuration")
+// ragSources turns the matches that were sent to the model into citations.
+// Document chunks carry an internal "doc:<id>" URL, which is not exposed.
+func ragSources(matches []map[string]interface{}) []answerSource {
+	sources := make([]answerSource, 0, len(matches))
+	for _, m := range matches {
+		meta, _ := m["metadata"].(map[string]interface{})
+		text, _ := meta["text"].(string)
+		if strings.TrimSpace(text) == "" {
+			continue
 		}
-		return info, nil
-	}
-
-	resolved, err := c.resolvePineconeIndexInfoFromControlPlane(c.cfg.PineconeIndexName)
-	if err != nil {
-		if info.Host != "" {
-			c.logger.Warn("pinecone index metadata resolve failed; using explicit host override",
-				"index", c.cfg.PineconeIndexName,
-				"error", err,
-			)
-			return info, nil
+		id, _ := m["id"].(string)
+		score, _ := ragMatchScore(m)
+		src := answerSource{ChunkID: id, Score: math.Round(score*10000) / 10000}
+		src.URL, _ = meta["url"].(string)
+		if canonical, _ := meta["canonicalUrl"].(string); strings.TrimSpace(canonical) != "" {
+			src.URL = canonical
+		}
+		src.Title, _ = meta["pageTitle"].(string)
+		src.Section, _ = meta["headingPath"].(string)
+		src.SourceType, _ = meta["sourceType"].(string)
+		src.SourceKey, _ = meta["sourceKey"].(string)
+		src.SourceType = normalizeRAGSourceType(src.SourceType)
+		if src.SourceType == "document" || strings.HasPrefix(src.URL, "doc:") {
+			src.SourceType = "document"
+			src.URL = ""
 		}
-		return info, err
+		if strings.TrimSpace(src.Title) == "" {
+			src.Title = src.URL
+		}
+		sources = append(sources, src)
 	}
+	return sources
+}
 
-	if info.Host == "" {
-		info.Host = resolved.Host
-	}
-	if info.Dimension <= 0 {
-		info.Dimension = resolved.Dimension
+// answerSources returns the citations for a generated answer, or an empty list
+// when the model fell back to the "no information" reply.
+func answerSources(answer, fallback string, matches []map[string]interface{}) []answerSource {
+	if strings.TrimSpace(answer) == "" || strings.Contains(answer, fallback) {
+		return []answerSource{}
 	}
-
-	return info, nil
+	return ragSources(matches)
 }
 
-func normalizePineconeHost(raw string) string {
-	host := strings.TrimSpace(raw)
-	if host == "" {
-		return ""
-	}
-	if strings.HasPrefix(host, "https://") || strings.HasPrefix(host, "http://") {
-		return host
+func (c *Controller) embedText(ctx context.Context, input string, dimensions int) ([]float64, error) {
+	vectors, err := c.embedTexts(ctx, []string{input}, dimensions)
+	if err != nil {
+		return nil, err
 	}
-	return "https://" + host
+	return vectors[0], nil
 }
 
-func (c *Controller) resolvePineconeIndexInfoFromControlPlane(indexName string) (pineconeIndexInfo, error) {
-	var info pineconeIndexInfo
-	endpoint := "https://api.pinecone.io/indexes/" + url.PathEscape(strings.TrimSpace(indexName))
-	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
-	if err != nil {
-		return info, err
+func (c *Controller) logLLMError(message, provider string, err error) {
+	var statusErr *llm.StatusError
+	if errors.As(err, &statusErr) {
+		c.logger.Warn(message, "provider", provider, "status_code", statusErr.StatusCode, "response", statusErr.Body)
+		return
 	}
-	req.Header.Set("Api-Key", c.cfg.PineconeAPIKey)
-	req.Header.Set("Accept", "application/json")
+	c.logger.Warn(message, "provider", provider, "error", err)
+}
 
+// ── Vector store ───────────────────────────────────────────────────────────────
+
+func newVectorStore(cfg config.Config, db *sql.DB, logger *slog.Logger) vectorstore.Store {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	req = req.WithContext(ctx)
-
-	resp, err := http.DefaultClient.Do(req)
+	store, err := vectorstore.New(ctx, vectorstore.Config{
+		Backend:           cfg.VectorStore,
+		PineconeAPIKey:    cfg.PineconeAPIKey,
+		PineconeIndexName: cfg.PineconeIndexName,
+		PineconeHost:      cfg.PineconeHost,
+		PineconeDimension: cfg.PineconeDimension,
+		DB:                db,
+		Dimension:         cfg.VectorDimension,
+		Logger:            logger,
+	})
 	if err != nil {
-		return info, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
-		return info, fmt.Errorf("describe index status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
-	}
-
-	var out struct {
-		Host      string `json:"host"`
-		Dimension int    `json:"dimension"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return info, err
+		logger.Error("vector store setup failed; retrieval is disabled", "backend", cfg.VectorStore, "error", err)
+		return nil
 	}
-	host := normalizePineconeHost(out.Host)
-	if host == "" {
-		return info, errors.New("empty host from pinecone control api")
+	if store == nil {
+		logger.Warn("vector store has no credentials; retrieval is disabled", "backend", cfg.VectorStore)
 	}
-	info.Host = host
-	info.Dimension = out.Dimension
-	return info, nil
+	return store
 }
 
 type ragChunk struct {
-	URL        string
-	PageTitle  string
-	Text       string
-	ChunkIndex int
-	WidgetKey  string
-	SourceType string
-	SourceKey  string
+	URL          string
+	PageTitle    string
+	HeadingPath  string
+	Text         string
+	ChunkIndex   int
+	WidgetKey    string
+	SourceType   string
+	SourceKey    string
+	Description  string
+	CanonicalURL string
+	Language     string
 }
 
-func pineconeNamespace(userID string) string {
+func vectorNamespace(userID string) string {
 	return "user_" + strings.ReplaceAll(strings.TrimSpace(userID), "-", "")
 }
 
@@ -547,39 +613,26 @@ func (c *Controller) userWidgetKey(userID string) string {
 	return strings.TrimSpace(widgetKey)
 }
 
-func (c *Controller) pineconeUpsert(userID, sourceURL, content string) error {
-	sourceType := "website"
-	sourceKey := strings.TrimSpace(sourceURL)
-	if strings.HasPrefix(sourceKey, "doc:") {
-		sourceType = "document"
-		sourceKey = strings.TrimSpace(strings.TrimPrefix(sourceKey, "doc:"))
-	}
-	chunks := []ragChunk{{
-		URL:        sourceURL,
-		PageTitle:  "",
-		Text:       content,
-		ChunkIndex: 0,
-		WidgetKey:  c.userWidgetKey(userID),
-		SourceType: sourceType,
-		SourceKey:  sourceKey,
-	}}
-	return c.pineconeUpsertChunks(userID, chunks)
-}
-
-func (c *Controller) pineconeUpsertChunks(userID string, chunks []ragChunk) error {
-	if strings.TrimSpace(c.cfg.PineconeAPIKey) == "" {
+// vectorUpsertChunks embeds and stores chunks in the vector store and mirrors
+// their text into the keyword index used by hybrid retrieval.
+func (c *Controller) vectorUpsertChunks(userID string, chunks []ragChunk) error {
+	ctx := withUsageScope(context.Background(), userID, usageKindEmbedding)
+	records := ragRecords(userID, chunks)
+	if len(records) == 0 {
 		return nil
 	}
-	indexInfo, err := c.pineconeIndexInfo()
-	if err != nil {
-		return err
-	}
-	if indexInfo.Host == "" {
-		return errors.New("pinecone host could not be resolved; set PINECONE_HOST or verify PINECONE_INDEX_NAME")
+	defer c.invalidateAnswerCache(userID)
+	if err := c.keywordIndexUpsert(ctx, userID, records); err != nil {
+		c.logger.Warn("keyword index upsert failed", "user_id", userID, "error", err)
 	}
+	return c.upsertVectorRecords(ctx, userID, records)
+}
 
-	namespace := pineconeNamespace(userID)
-	vectors := make([]map[string]interface{}, 0, len(chunks))
+// ragRecords converts chunks to vector store records with stable IDs,
+// dropping empty chunks.
+func ragRecords(userID string, chunks []ragChunk) []vectorstore.Record {
+	namespace := vectorNamespace(userID)
+	records := make([]vectorstore.Record, 0, len(chunks))
 	for _, chunk := range chunks {
 		text := strings.TrimSpace(chunk.Text)
 		if text == "" {
@@ -594,60 +647,63 @@ func (c *Controller) pineconeUpsertChunks(userID string, chunks []ragChunk) erro
 				sourceKey = strings.TrimSpace(chunk.URL)
 			}
 		}
-		emb, err := c.openAIEmbedding(text, indexInfo.Dimension)
-		if err != nil || len(emb) == 0 {
-			if err != nil {
-				c.logger.Warn("pinecone upsert embedding failed", "user_id", userID, "source_url", chunk.URL, "error", err)
-			}
-			continue
-		}
-
-		vectors = append(vectors, map[string]interface{}{
-			"id":     stablePineconeVectorID(namespace, sourceType, sourceKey, chunk.URL, chunk.ChunkIndex),
-			"values": emb,
-			"metadata": map[string]interface{}{
-				"user_id":     userID,
-				"url":         chunk.URL,
-				"pageTitle":   strings.TrimSpace(chunk.PageTitle),
-				"chunkIndex":  chunk.ChunkIndex,
-				"text":        text,
-				"widgetKey":   strings.TrimSpace(chunk.WidgetKey),
-				"sourceType":  sourceType,
-				"sourceKey":   sourceKey,
-				"namespaceId": namespace,
-			},
+		records = append(records, vectorstore.Record{
+			ID:           stableVectorID(namespace, sourceType, sourceKey, chunk.URL, chunk.ChunkIndex),
+			UserID:       userID,
+			URL:          chunk.URL,
+			PageTitle:    strings.TrimSpace(chunk.PageTitle),
+			HeadingPath:  strings.TrimSpace(chunk.HeadingPath),
+			Text:         text,
+			ChunkIndex:   chunk.ChunkIndex,
+			WidgetKey:    strings.TrimSpace(chunk.WidgetKey),
+			SourceType:   sourceType,
+			SourceKey:    sourceKey,
+			Description:  strings.TrimSpace(chunk.Description),
+			CanonicalURL: strings.TrimSpace(chunk.CanonicalURL),
+			Language:     strings.TrimSpace(chunk.Language),
 		})
 	}
+	return records
+}
 
-	if len(vectors) == 0 {
+// upsertVectorRecords embeds records and writes them to the vector store.
+func (c *Controller) upsertVectorRecords(ctx context.Context, userID string, records []vectorstore.Record) error {
+	if c.vectors == nil || len(records) == 0 {
 		return nil
 	}
+	namespace := vectorNamespace(userID)
 
-	payload := map[string]interface{}{
-		"namespace": namespace,
-		"vectors":   vectors,
-	}
-	b, _ := json.Marshal(payload)
-	req, err := http.NewRequest(http.MethodPost, indexInfo.Host+"/vectors/upsert", bytes.NewReader(b))
+	dimension, err := c.vectors.Dimension(ctx)
 	if err != nil {
-		c.logger.Error("pinecone upsert request build failed", "error", err)
 		return err
 	}
-	req.Header.Set("Api-Key", c.cfg.PineconeAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
+	// The heading path is embedded with the text so a chunk such as "$49 per
+	// month" still matches a question about the Pro plan.
+	texts := make([]string, len(records))
+	for i, record := range records {
+		texts[i] = record.Text
+		if record.HeadingPath != "" {
+			texts[i] = record.HeadingPath + "\n\n" + record.Text
+		}
+	}
+	vectors, err := c.embedTexts(ctx, texts, dimension)
 	if err != nil {
-		c.logger.Warn("pinecone upsert request failed", "user_id", userID, "namespace", namespace, "error", err)
-		return err
+		c.logger.Warn("vector upsert embedding failed", "user_id", userID, "chunks", len(records), "error", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		err := fmt.Errorf("pinecone upsert status %d: %s", resp.StatusCode, string(body))
-		c.logger.Warn("pinecone upsert returned non-success status", "status_code", resp.StatusCode, "user_id", userID, "namespace", namespace)
+	embedded := make([]vectorstore.Record, 0, len(records))
+	for i, record := range records {
+		if len(vectors[i]) == 0 {
+			continue
+		}
+		record.Values = vectors[i]
+		embedded = append(embedded, record)
+	}
+
+	if len(embedded) == 0 {
+		return nil
+	}
+	if err := c.vectors.Upsert(ctx, namespace, embedded); err != nil {
+		c.logger.Warn("vector upsert failed", "backend", c.vectors.Name(), "user_id", userID, "namespace", namespace, "error", err)
 		return err
 	}
 	return nil
@@ -662,7 +718,9 @@ func normalizeRAGSourceType(raw string) string {
 	}
 }
 
-func stablePineconeVectorID(namespace, sourceType, sourceKey, sourceURL string, chunkIndex int) string {
+// stableVectorID keeps the "pc_" prefix from the Pinecone-only days so that
+// re-indexing an existing source overwrites its vectors instead of duplicating them.
+func stableVectorID(namespace, sourceType, sourceKey, sourceURL string, chunkIndex int) string {
 	sum := sha256.Sum256([]byte(strings.Join([]string{
 		strings.TrimSpace(namespace),
 		normalizeRAGSourceType(sourceType),
@@ -673,160 +731,101 @@ func stablePineconeVectorID(namespace, sourceType, sourceKey, sourceURL string,
 	return "pc_" + hex.EncodeToString(sum[:16])
 }
 
-func (c *Controller) pineconeDeleteNamespace(userID string) error {
-	return c.pineconeDeleteAll(userID)
-}
-
-func (c *Controller) pineconeDeleteAll(userID string) error {
-	if strings.TrimSpace(c.cfg.PineconeAPIKey) == "" {
-		return nil
+func (c *Controller) vectorDeleteNamespace(userID string) error {
+	defer c.invalidateAnswerCache(userID)
+	if _, err := c.db.Exec(`DELETE FROM rag_chunks WHERE user_id=$1`, userID); err != nil {
+		c.logger.Warn("keyword index namespace delete failed", "user_id", userID, "error", err)
 	}
-	indexInfo, err := c.pineconeIndexInfo()
-	if err != nil {
-		return err
-	}
-	if indexInfo.Host == "" {
+	if c.vectors == nil {
 		return nil
 	}
-	namespace := pineconeNamespace(userID)
-	return c.pineconeDelete(indexInfo.Host, map[string]interface{}{
-		"namespace": namespace,
-		"deleteAll": true,
-	})
+	return c.vectors.DeleteNamespace(context.Background(), vectorNamespace(userID))
 }
 
-func (c *Controller) pineconeDeleteBySource(userID, sourceType, sourceKey string) error {
-	if strings.TrimSpace(sourceKey) == "" || strings.TrimSpace(c.cfg.PineconeAPIKey) == "" {
+func (c *Controller) vectorDeleteBySource(userID, sourceType, sourceKey string) error {
+	if strings.TrimSpace(sourceKey) == "" {
 		return nil
 	}
-	indexInfo, err := c.pineconeIndexInfo()
-	if err != nil {
-		return err
+	defer c.invalidateAnswerCache(userID)
+	if _, err := c.db.Exec(`DELETE FROM rag_chunks WHERE user_id=$1 AND source_type=$2 AND source_key=$3`,
+		userID, normalizeRAGSourceType(sourceType), strings.TrimSpace(sourceKey)); err != nil {
+		c.logger.Warn("keyword index source delete failed", "user_id", userID, "source_key", sourceKey, "error", err)
 	}
-	if indexInfo.Host == "" {
+	if c.vectors == nil {
 		return nil
 	}
-	namespace := pineconeNamespace(userID)
-	filter := map[string]interface{}{
-		"sourceType": map[string]interface{}{"$eq": normalizeRAGSourceType(sourceType)},
-		"sourceKey":  map[string]interface{}{"$eq": strings.TrimSpace(sourceKey)},
-	}
-	return c.pineconeDelete(indexInfo.Host, map[string]interface{}{
-		"namespace": namespace,
-		"filter":    filter,
-	})
+	return c.vectors.DeleteBySource(context.Background(), vectorNamespace(userID),
+		normalizeRAGSourceType(sourceType), strings.TrimSpace(sourceKey))
 }
 
-func (c *Controller) pineconeDeleteByURL(userID, sourceURL string) error {
-	if strings.TrimSpace(sourceURL) == "" || strings.TrimSpace(c.cfg.PineconeAPIKey) == "" {
+func (c *Controller) vectorDeleteByURL(userID, sourceURL string) error {
+	if strings.TrimSpace(sourceURL) == "" {
 		return nil
 	}
-	indexInfo, err := c.pineconeIndexInfo()
-	if err != nil {
-		return err
+	defer c.invalidateAnswerCache(userID)
+	if _, err := c.db.Exec(`DELETE FROM rag_chunks WHERE user_id=$1 AND url=$2`, userID, strings.TrimSpace(sourceURL)); err != nil {
+		c.logger.Warn("keyword index url delete failed", "user_id", userID, "url", sourceURL, "error", err)
 	}
-	if indexInfo.Host == "" {
+	if c.vectors == nil {
 		return nil
 	}
-	namespace := pineconeNamespace(userID)
-	filter := map[string]interface{}{
-		"url": map[string]interface{}{"$eq": strings.TrimSpace(sourceURL)},
-	}
-	return c.pineconeDelete(indexInfo.Host, map[string]interface{}{
-		"namespace": namespace,
-		"filter":    filter,
-	})
+	return c.vectors.DeleteByURL(context.Background(), vectorNamespace(userID), strings.TrimSpace(sourceURL))
 }
 
-func (c *Controller) pineconeDelete(host string, payload map[string]interface{}) error {
-	b, _ := json.Marshal(payload)
-	req, err := http.NewRequest(http.MethodPost, host+"/vectors/delete", bytes.NewReader(b))
-	if err != nil {
-		return err
+// vectorDeletePage removes one page of a source from the keyword index and
+// the vector store.
+func (c *Controller) vectorDeletePage(userID, sourceType, sourceKey, pageURL string) error {
+	if strings.TrimSpace(sourceKey) == "" || strings.TrimSpace(pageURL) == "" {
+		return nil
 	}
-	req.Header.Set("Api-Key", c.cfg.PineconeAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-	req = req.WithContext(ctx)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+	defer c.invalidateAnswerCache(userID)
+	sourceType = normalizeRAGSourceType(sourceType)
+	if _, err := c.db.Exec(`DELETE FROM rag_chunks WHERE user_id=$1 AND source_type=$2 AND source_key=$3 AND url=$4`,
+		userID, sourceType, strings.TrimSpace(sourceKey), strings.TrimSpace(pageURL)); err != nil {
+		c.logger.Warn("keyword index page delete failed", "user_id", userID, "source_key", sourceKey, "url", pageURL, "error", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		trimmed := strings.TrimSpace(string(body))
-		if resp.StatusCode == http.StatusNotFound {
-			lower := strings.ToLower(trimmed)
-			if strings.Contains(lower, "namespace not found") || strings.Contains(lower, `"code":5`) {
-				return nil
-			}
-		}
-		return fmt.Errorf("pinecone namespace delete status %d: %s", resp.StatusCode, trimmed)
+	if c.vectors == nil {
+		return nil
 	}
-	return nil
+	return c.vectors.DeleteByPage(context.Background(), vectorNamespace(userID), sourceType, strings.TrimSpace(sourceKey), strings.TrimSpace(pageURL))
 }
 
-func (c *Controller) pineconeQuery(userID, query string, topK int) ([]map[string]interface{}, error) {
-	if strings.TrimSpace(c.cfg.PineconeAPIKey) == "" {
+// vectorQuery returns matches as generic maps ({id, score, metadata}) so they
+// can be filtered and serialized the same way regardless of backend.
+func (c *Controller) vectorQuery(ctx context.Context, userID, query string, topK int) ([]map[string]interface{}, error) {
+	if c.vectors == nil {
 		return nil, nil
 	}
-	indexInfo, err := c.pineconeIndexInfo()
+	dimension, err := c.vectors.Dimension(ctx)
 	if err != nil {
 		return nil, err
 	}
-	if indexInfo.Host == "" {
-		return nil, nil
-	}
-	emb, err := c.openAIEmbedding(query, indexInfo.Dimension)
+	emb, err := c.embedText(ctx, query, dimension)
 	if err != nil || len(emb) == 0 {
 		if err != nil {
-			c.logger.Warn("pinecone query embedding failed", "user_id", userID, "error", err)
+			c.logger.Warn("vector query embedding failed", "user_id", userID, "error", err)
 		}
 		return nil, err
 	}
 	if topK <= 0 {
 		topK = 5
 	}
-	namespace := pineconeNamespace(userID)
-	payload := map[string]interface{}{
-		"namespace":       namespace,
-		"vector":          emb,
-		"topK":            topK,
-		"includeMetadata": true,
-	}
-	b, _ := json.Marshal(payload)
-	req, err := http.NewRequest(http.MethodPost, indexInfo.Host+"/query", bytes.NewReader(b))
-	if err != nil {
-		c.logger.Error("pinecone query request build failed", "error", err)
-		return nil, err
-	}
-	req.Header.Set("Api-Key", c.cfg.PineconeAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
-	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
+	matches, err := c.vectors.Query(ctx, vectorNamespace(userID), emb, topK)
 	if err != nil {
-		c.logger.Warn("pinecone query request failed", "user_id", userID, "error", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		err := fmt.Errorf("pinecone query status %d", resp.StatusCode)
-		c.logger.Warn("pinecone query returned non-success status", "status_code", resp.StatusCode, "user_id", userID)
+		c.logger.Warn("vector query failed", "backend", c.vectors.Name(), "user_id", userID, "error", err)
 		return nil, err
 	}
-	var out struct {
-		Matches []map[string]interface{} `json:"matches"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		c.logger.Warn("pinecone query decode failed", "user_id", userID, "error", err)
-		return nil, err
+	out := make([]map[string]interface{}, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, map[string]interface{}{
+			"id":       m.ID,
+			"score":    m.Score,
+			"metadata": m.Metadata,
+		})
 	}
-	return out.Matches, nil
+	return out, nil
 }
 
 // ── URL scraper ────────────────────────────────────────────────────────────────
@@ -1008,16 +1007,23 @@ func (c *Controller) StartBackgroundWorkers(ctx context.Context) {
 	analyticsTicker := time.NewTicker(time.Duration(c.cfg.AnalyticsFlushIntervalSec) * time.Second)
 	webhookTicker := time.NewTicker(time.Duration(c.cfg.WebhookProcessIntervalSec) * time.Second)
 	maintenanceTicker := time.NewTicker(24 * time.Hour)
+	refreshTicker := time.NewTicker(sourceRefreshTick)
 	c.logger.Info("background workers started",
 		"analytics_interval_sec", c.cfg.AnalyticsFlushIntervalSec,
 		"webhook_interval_sec", c.cfg.WebhookProcessIntervalSec,
 		"maintenance_interval_hours", 24,
+		"source_refresh_interval_min", int(sourceRefreshTick/time.Minute),
+		"scrape_worker", c.workerID,
 	)
 
+	go c.runScrapeQueue(ctx)
+	go c.indexPendingCuratedAnswers(ctx)
+
 	go func() {
 		defer analyticsTicker.Stop()
 		defer webhookTicker.Stop()
 		defer maintenanceTicker.Stop()
+		defer refreshTicker.Stop()
 		defer c.logger.Info("background workers stopped")
 		for {
 			select {
@@ -1027,6 +1033,9 @@ func (c *Controller) StartBackgroundWorkers(ctx context.Context) {
 				c.flushWidgetAnalytics(context.Background())
 			case <-webhookTicker.C:
 				c.processPendingWebhookEvents(context.Background())
+			case <-refreshTicker.C:
+				c.refreshDueSources(context.Background())
+				c.indexPendingCuratedAnswers(context.Background())
 			case <-maintenanceTicker.C:
 				if _, err := c.db.Exec(`UPDATE sessions SET is_revoked=TRUE WHERE (refresh_token_expires_at < CURRENT_TIMESTAMP OR refresh_token_expires_at IS NULL) AND is_revoked=FALSE`); err != nil {
 					c.logger.Warn("maintenance task failed: revoke expired sessions", "error", err)
@@ -0,0 +1,274 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"konvoq-backend/utils"
+)
+
+const (
+	maxKnowledgeGapQuestionChars = 1000
+	// knowledgeGapScanLimit bounds how many recent misses one report clusters.
+	knowledgeGapScanLimit = 2000
+	// knowledgeGapSimilarity is the share of the shorter question's keywords
+	// two questions must have in common to count as the same topic.
+	knowledgeGapSimilarity = 0.6
+	knowledgeGapExamples   = 3
+)
+
+// recordKnowledgeGap stores a question the knowledge base could not answer.
+// widgetID is 0 for dashboard chats. It runs in the background so the visitor
+// never waits on it.
+func (c *Controller) recordKnowledgeGap(ownerID string, widgetID int64, conversationID, question string, bestScore float64) {
+	question = strings.TrimSpace(question)
+	if ownerID == "" || question == "" {
+		return
+	}
+	var widget, score interface{}
+	if widgetID > 0 {
+		widget = widgetID
+	}
+	if bestScore > 0 {
+		score = bestScore
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := c.db.ExecContext(ctx, `INSERT INTO knowledge_gaps (user_id,widget_key_id,conversation_id,question,best_score)
+			VALUES ($1,$2,$3,$4,$5)`,
+			ownerID, widget, utils.Nullable(conversationID), truncateRunes(question, maxKnowledgeGapQuestionChars), score); err != nil {
+			c.logger.Warn("knowledge gap insert failed", "user_id", ownerID, "error", err)
+		}
+	}()
+}
+
+// KnowledgeGaps lists the topics visitors asked about that the knowledge base
+// could not answer, largest first, so customers know which content to add.
+func (c *Controller) KnowledgeGaps(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	days := 30
+	if q := r.URL.Query().Get("days"); q != "" {
+		if n, err := strconv.Atoi(q); err == nil && n > 0 && n <= 365 {
+			days = n
+		}
+	}
+	limit := 20
+	if q := r.URL.Query().Get("limit"); q != "" {
+		if n, err := strconv.Atoi(q); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+	rows, err := c.db.Query(`SELECT question,best_score,created_at FROM knowledge_gaps
+		WHERE user_id=$1 AND created_at >= CURRENT_TIMESTAMP - make_interval(days => $2)
+		ORDER BY created_at DESC
+		LIMIT $3`, claims.UserID, days, knowledgeGapScanLimit)
+	if err != nil {
+		c.logRequestError(r, "knowledge gaps query failed", err, "user_id", claims.UserID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	defer rows.Close()
+	gaps := []knowledgeGap{}
+	for rows.Next() {
+		var g knowledgeGap
+		if err := rows.Scan(&g.question, &g.bestScore, &g.askedAt); err != nil {
+			c.logRequestWarn(r, "knowledge gaps row scan failed", err, "user_id", claims.UserID)
+			continue
+		}
+		gaps = append(gaps, g)
+	}
+
+	clusters := clusterKnowledgeGaps(gaps)
+	topics := make([]map[string]interface{}, 0, limit)
+	for _, cl := range clusters {
+		if len(topics) == limit {
+			break
+		}
+		var avgScore interface{}
+		if cl.scored > 0 {
+			avgScore = cl.scoreSum / float64(cl.scored)
+		}
+		topics = append(topics, map[string]interface{}{
+			"topic":        cl.examples[0],
+			"keywords":     cl.keywords(3),
+			"count":        cl.count,
+			"examples":     cl.examples,
+			"lastAskedAt":  cl.lastAskedAt,
+			"avgBestScore": avgScore,
+		})
+	}
+	utils.JSONOK(w, map[string]interface{}{
+		"success":     true,
+		"days":        days,
+		"totalMisses": len(gaps),
+		"topics":      topics,
+	})
+}
+
+type knowledgeGap struct {
+	question  string
+	bestScore sql.NullFloat64
+	askedAt   time.Time
+}
+
+type knowledgeGapCluster struct {
+	terms       map[string]struct{}
+	termCounts  map[string]int
+	count       int
+	examples    []string
+	lastAskedAt time.Time
+	scoreSum    float64
+	scored      int
+}
+
+// keywords returns the cluster's n most frequent terms.
+func (cl *knowledgeGapCluster) keywords(n int) []string {
+	out := make([]string, 0, len(cl.termCounts))
+	for t := range cl.termCounts {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if cl.termCounts[out[i]] != cl.termCounts[out[j]] {
+			return cl.termCounts[out[i]] > cl.termCounts[out[j]]
+		}
+		return out[i] < out[j]
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// clusterKnowledgeGaps groups questions by keyword overlap. Questions with the
+// same keywords are grouped first; the groups are then merged greedily, most
+// frequent first, into the first cluster whose seed is similar enough. The
+// result is ordered by size, then recency.
+func clusterKnowledgeGaps(gaps []knowledgeGap) []*knowledgeGapCluster {
+	type group struct {
+		terms []string
+		items []knowledgeGap
+	}
+	byKey := map[string]*group{}
+	groups := []*group{}
+	for _, g := range gaps {
+		terms := knowledgeGapTerms(g.question)
+		key := strings.Join(terms, " ")
+		if key == "" {
+			key = strings.ToLower(strings.Join(strings.Fields(g.question), " "))
+		}
+		grp, ok := byKey[key]
+		if !ok {
+			grp = &group{terms: terms}
+			byKey[key] = grp
+			groups = append(groups, grp)
+		}
+		grp.items = append(grp.items, g)
+	}
+	// gaps arrive newest first, so a stable sort keeps recent groups ahead of
+	// equally common older ones.
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].items) > len(groups[j].items) })
+
+	clusters := []*knowledgeGapCluster{}
+	for _, grp := range groups {
+		var target *knowledgeGapCluster
+		for _, cl := range clusters {
+			if len(grp.terms) > 0 && termOverlap(grp.terms, cl.terms) >= knowledgeGapSimilarity {
+				target = cl
+				break
+			}
+		}
+		if target == nil {
+			target = &knowledgeGapCluster{terms: map[string]struct{}{}, termCounts: map[string]int{}}
+			for _, t := range grp.terms {
+				target.terms[t] = struct{}{}
+			}
+			clusters = append(clusters, target)
+		}
+		seen := map[string]struct{}{}
+		for _, ex := range target.examples {
+			seen[strings.ToLower(ex)] = struct{}{}
+		}
+		for _, item := range grp.items {
+			target.count++
+			for _, t := range grp.terms {
+				target.termCounts[t]++
+			}
+			if item.askedAt.After(target.lastAskedAt) {
+				target.lastAskedAt = item.askedAt
+			}
+			if item.bestScore.Valid {
+				target.scoreSum += item.bestScore.Float64
+				target.scored++
+			}
+			if len(target.examples) < knowledgeGapExamples {
+				if _, dup := seen[strings.ToLower(item.question)]; !dup {
+					seen[strings.ToLower(item.question)] = struct{}{}
+					target.examples = append(target.examples, item.question)
+				}
+			}
+		}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		if clusters[i].count != clusters[j].count {
+			return clusters[i].count > clusters[j].count
+		}
+		return clusters[i].lastAskedAt.After(clusters[j].lastAskedAt)
+	})
+	return clusters
+}
+
+// knowledgeGapFillerWords are conversational words that say nothing about the
+// topic, on top of keywordStopWords.
+var knowledgeGapFillerWords = map[string]struct{}{
+	"about": {}, "any": {}, "be": {}, "get": {}, "have": {}, "hello": {}, "hi": {}, "if": {}, "know": {},
+	"like": {}, "many": {}, "much": {}, "need": {}, "please": {}, "tell": {}, "there": {}, "this": {},
+	"that": {}, "want": {}, "was": {}, "will": {}, "would": {}, "could": {}, "should": {}, "our": {}, "us": {},
+}
+
+// knowledgeGapTerms reduces a question to its sorted, de-duplicated keywords
+// with plurals folded, so "shipping costs?" and "What does shipping cost"
+// share a key.
+func knowledgeGapTerms(question string) []string {
+	terms := keywordSearchTerms(question)
+	seen := make(map[string]struct{}, len(terms))
+	out := make([]string, 0, len(terms))
+	for _, t := range terms {
+		if _, filler := knowledgeGapFillerWords[t]; filler {
+			continue
+		}
+		switch {
+		case len(t) > 4 && strings.HasSuffix(t, "ies"):
+			t = t[:len(t)-3] + "y"
+		case len(t) > 3 && strings.HasSuffix(t, "s") && !strings.HasSuffix(t, "ss"):
+			t = t[:len(t)-1]
+		}
+		if _, dup := seen[t]; dup {
+			continue
+		}
+		seen[t] = struct{}{}
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// termOverlap is the overlap coefficient |a∩b| / min(|a|,|b|), which suits
+// short questions better than Jaccard: "how much is shipping?" is fully
+// contained in "shipping costs to Canada".
+func termOverlap(terms []string, set map[string]struct{}) float64 {
+	if len(terms) == 0 || len(set) == 0 {
+		return 0
+	}
+	shared := 0
+	for _, t := range terms {
+		if _, ok := set[t]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(min(len(terms), len(set)))
+}
@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"konvoq-backend/platform/llm"
+)
+
+const defaultLanguage = "en"
+
+// supportedLanguages are the languages the widget's language selector offers,
+// keyed by code, with the name used in prompts.
+var supportedLanguages = map[string]string{
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"de": "German",
+	"hi": "Hindi",
+	"ar": "Arabic",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"ja": "Japanese",
+	"zh": "Chinese",
+	"it": "Italian",
+	"nl": "Dutch",
+	"ko": "Korean",
+	"tr": "Turkish",
+	"pl": "Polish",
+}
+
+// localizedFallbackAnswers translate ragFallbackAnswer for visitors writing in
+// another language.
+var localizedFallbackAnswers = map[string]string{
+	"en": ragFallbackAnswer,
+	"es": "No tengo información sobre eso. Por favor, contacta con soporte.",
+	"fr": "Je n'ai pas d'information à ce sujet. Veuillez contacter le support.",
+	"de": "Dazu habe ich keine Informationen. Bitte wende dich an den Support.",
+	"hi": "मेरे पास इसके बारे में जानकारी नहीं है। कृपया सहायता टीम से संपर्क करें।",
+	"ar": "ليس لدي معلومات حول ذلك. يرجى التواصل مع الدعم.",
+	"pt": "Não tenho informações sobre isso. Entre em contato com o suporte.",
+	"ru": "У меня нет информации об этом. Пожалуйста, обратитесь в службу поддержки.",
+	"ja": "その件に関する情報はありません。サポートまでお問い合わせください。",
+	"zh": "我没有这方面的信息。请联系客服支持。",
+	"it": "Non ho informazioni a riguardo. Contatta l'assistenza.",
+	"nl": "Daar heb ik geen informatie over. Neem contact op met support.",
+	"ko": "해당 내용에 대한 정보가 없습니다. 고객 지원팀에 문의해 주세요.",
+	"tr": "Bu konuda bilgim yok. Lütfen destek ekibiyle iletişime geçin.",
+	"pl": "Nie mam informacji na ten temat. Skontaktuj się z pomocą techniczną.",
+}
+
+// normalizeLanguage returns the supported code for values such as "de",
+// "DE" or "de-AT", or "" when the language is not supported.
+func normalizeLanguage(value string) string {
+	code := strings.ToLower(strings.TrimSpace(value))
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		code = code[:i]
+	}
+	if _, ok := supportedLanguages[code]; ok {
+		return code
+	}
+	return ""
+}
+
+// latinStopwords are frequent short words that identify the Latin-script
+// languages; a word listed for several languages counts for each of them.
+var latinStopwords = map[string][]string{
+	"en": {"the", "is", "are", "was", "what", "how", "do", "does", "can", "you", "your", "my", "and", "of", "to", "for", "with", "have", "this", "that", "i", "it", "hello", "hi", "thanks", "please", "where", "when", "why", "which"},
+	"es": {"el", "la", "los", "las", "es", "qué", "que", "cómo", "como", "puedo", "tienen", "para", "por", "con", "una", "del", "mi", "hola", "gracias", "dónde", "cuánto", "cuándo", "está", "están", "y", "pero", "sus"},
+	"fr": {"le", "la", "les", "est", "quel", "quelle", "comment", "je", "vous", "pour", "avec", "une", "des", "du", "mon", "bonjour", "merci", "où", "combien", "quand", "et", "pas", "sont", "c'est", "avez", "ce"},
+	"de": {"der", "die", "das", "ist", "sind", "was", "wie", "ich", "sie", "ihr", "für", "mit", "und", "ein", "eine", "nicht", "mein", "hallo", "danke", "wo", "wann", "warum", "kann", "haben", "auf", "bitte"},
+	"pt": {"os", "é", "são", "que", "como", "eu", "você", "para", "com", "uma", "um", "do", "da", "meu", "olá", "obrigado", "obrigada", "onde", "quanto", "quando", "não", "vocês", "posso", "custa", "quero", "tem"},
+	"it": {"il", "lo", "la", "gli", "le", "è", "sono", "che", "come", "io", "per", "con", "una", "un", "del", "della", "mio", "ciao", "grazie", "dove", "quanto", "quando", "non", "posso", "avete", "cosa"},
+	"nl": {"het", "een", "is", "zijn", "wat", "hoe", "ik", "jullie", "voor", "met", "en", "niet", "mijn", "hallo", "bedankt", "dank", "waar", "wanneer", "waarom", "kan", "hebben", "op", "van", "hoeveel", "kost", "graag"},
+	"tr": {"bir", "ve", "bu", "ne", "nasıl", "ben", "siz", "için", "ile", "mi", "mı", "değil", "var", "yok", "merhaba", "teşekkürler", "teşekkür", "nerede", "zaman", "neden", "benim", "sizin", "lütfen", "nedir"},
+	"pl": {"jest", "są", "co", "jak", "ja", "dla", "nie", "mój", "moje", "cześć", "dzień", "dziękuję", "gdzie", "ile", "kiedy", "dlaczego", "czy", "mam", "macie", "proszę", "się", "na"},
+}
+
+var latinStopwordIndex = func() map[string][]string {
+	index := map[string][]string{}
+	for lang, words := range latinStopwords {
+		for _, w := range words {
+			index[w] = append(index[w], lang)
+		}
+	}
+	return index
+}()
+
+// languageMarkers are letters that only occur in some Latin-script languages.
+var languageMarkers = map[rune][]string{
+	'ñ': {"es"}, '¿': {"es"}, '¡': {"es"},
+	'ã': {"pt"}, 'õ': {"pt"},
+	'ß': {"de"},
+	'ğ': {"tr"}, 'ş': {"tr"}, 'ı': {"tr"},
+	'ą': {"pl"}, 'ę': {"pl"}, 'ł': {"pl"}, 'ń': {"pl"}, 'ś': {"pl"}, 'ź': {"pl"}, 'ż': {"pl"},
+	'ä': {"de"}, 'ö': {"de", "tr"}, 'ü': {"de", "tr"},
+	'ç': {"fr", "pt", "tr"},
+}
+
+// detectLanguage guesses the language of a visitor message from its script
+// and, for Latin-script text, from common words and accented letters. ok is
+// false when the text is too short or ambiguous to tell, e.g. "ok" or a
+// product name.
+func detectLanguage(text string) (code string, ok bool) {
+	var letters, han, kana, hangul, arabic, devanagari, cyrillic int
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Arabic, r):
+			arabic++
+		case unicode.Is(unicode.Devanagari, r):
+			devanagari++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		}
+	}
+	if letters == 0 {
+		return "", false
+	}
+	// Non-Latin scripts identify the language on their own once they make up
+	// a real share of the message (not just a quoted name).
+	enough := func(n int) bool { return n > 0 && n*10 >= letters*3 }
+	switch {
+	case enough(hangul):
+		return "ko", true
+	case enough(kana):
+		return "ja", true
+	case enough(han):
+		return "zh", true
+	case enough(arabic):
+		return "ar", true
+	case enough(devanagari):
+		return "hi", true
+	case enough(cyrillic):
+		return "ru", true
+	}
+
+	scores := map[string]int{}
+	lower := strings.ToLower(text)
+	for _, r := range lower {
+		for _, lang := range languageMarkers[r] {
+			scores[lang]++
+		}
+	}
+	words := strings.FieldsFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	for _, w := range words {
+		for _, lang := range latinStopwordIndex[strings.Trim(w, "'")] {
+			scores[lang]++
+		}
+	}
+	best, bestScore, runnerUp := "", 0, 0
+	for lang, score := range scores {
+		if score > bestScore {
+			best, runnerUp, bestScore = lang, bestScore, score
+		} else if score > runnerUp {
+			runnerUp = score
+		}
+	}
+	// A single matching word is enough for a greeting-length message; longer
+	// messages need two, and a clear lead over the next language.
+	minScore := 2
+	if len(words) <= 3 {
+		minScore = 1
+	}
+	if bestScore < minScore || bestScore == runnerUp {
+		return "", false
+	}
+	return best, true
+}
+
+// answerLanguage picks the language to answer message in: the detected
+// language of the message, else the language the widget asked for (its
+// selector, seeded from the embed's default-language), else the widget's
+// configured default.
+func (s AISettings) answerLanguage(message, requested string) string {
+	if code, ok := detectLanguage(message); ok {
+		return code
+	}
+	if code := normalizeLanguage(requested); code != "" {
+		return code
+	}
+	return s.contentLanguage()
+}
+
+// contentLanguage is the language the knowledge base is assumed to be written
+// in, which is the widget's default language.
+func (s AISettings) contentLanguage() string {
+	if code := normalizeLanguage(s.DefaultLanguage); code != "" {
+		return code
+	}
+	return defaultLanguage
+}
+
+// withLanguage returns the settings for answering one message in code.
+func (s AISettings) withLanguage(code string) AISettings {
+	s.language = normalizeLanguage(code)
+	return s
+}
+
+// retrieveInLanguage is retrieveContext for a query written in the answer
+// language. When that differs from the knowledge base's language the query
+// is also translated and both result lists are fused, so a Spanish question
+// still finds English pages.
+func (c *Controller) retrieveInLanguage(ctx context.Context, ownerID, query string, ai AISettings) ([]map[string]interface{}, float64, error) {
+	matches, bestScore, err := c.retrieveContext(ctx, ownerID, query)
+	target := ai.contentLanguage()
+	if ai.language == "" || ai.language == target || c.llm == nil {
+		return matches, bestScore, err
+	}
+	translated := c.translateQuery(ctx, query, target)
+	if translated == "" || strings.EqualFold(translated, strings.TrimSpace(query)) {
+		return matches, bestScore, err
+	}
+	more, moreScore, moreErr := c.retrieveContext(ctx, ownerID, translated)
+	if moreErr != nil {
+		c.logger.Warn("translated retrieval failed", "user_id", ownerID, "language", target, "error", moreErr)
+		return matches, bestScore, err
+	}
+	if err != nil {
+		c.logger.Warn("retrieval failed; using translated query only", "user_id", ownerID, "error", err)
+		return more, moreScore, nil
+	}
+	limit := max(len(matches), len(more))
+	return capMatches(fuseRAGMatches(more, matches), limit), max(bestScore, moreScore), nil
+}
+
+// translateQuery translates a search query into the language with the given
+// code. It returns "" when the translation fails.
+func (c *Controller) translateQuery(ctx context.Context, query, code string) string {
+	query = strings.TrimSpace(query)
+	temperature := 0.0
+	translated, err := c.llmChat(ctx, llm.ChatRequest{
+		Messages: []llm.Message{
+			{Role: "system", Content: "You translate search queries into " + supportedLanguages[code] + ". Reply with the translated query only. Keep product names, codes and numbers unchanged."},
+			{Role: "user", Content: query},
+		},
+		Temperature: &temperature,
+		MaxTokens:   120,
+	})
+	translated = strings.Trim(strings.TrimSpace(translated), `"`)
+	if err != nil || translated == "" || utf8.RuneCountInString(translated) > 4*utf8.RuneCountInString(query)+200 {
+		return ""
+	}
+	return translated
+}
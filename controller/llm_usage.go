@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"konvoq-backend/platform/chunker"
+	"konvoq-backend/platform/llm"
+)
+
+// Usage kinds recorded in llm_usage_daily.
+const (
+	usageKindChat      = "chat"
+	usageKindEmbedding = "embedding"
+	usageKindBrand     = "brand"
+)
+
+var errSpendCapReached = errors.New("monthly ai spend cap reached")
+
+// modelPrice is the list price in USD per million tokens.
+type modelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// llmModelPrices is matched by longest model-name prefix, so dated snapshots
+// ("gpt-4o-2024-08-06") price like their family. Unknown and self-hosted
+// models are metered in tokens at zero cost.
+var llmModelPrices = map[string]modelPrice{
+	"gpt-4o-mini":            {Input: 0.15, Output: 0.60},
+	"gpt-4o":                 {Input: 2.50, Output: 10.00},
+	"gpt-4.1-nano":           {Input: 0.10, Output: 0.40},
+	"gpt-4.1-mini":           {Input: 0.40, Output: 1.60},
+	"gpt-4.1":                {Input: 2.00, Output: 8.00},
+	"gpt-3.5-turbo":          {Input: 0.50, Output: 1.50},
+	"text-embedding-3-small": {Input: 0.02},
+	"text-embedding-3-large": {Input: 0.13},
+	"text-embedding-ada-002": {Input: 0.10},
+	"claude-3-haiku":         {Input: 0.25, Output: 1.25},
+	"claude-3-5-haiku":       {Input: 0.80, Output: 4.00},
+	"claude-3-5-sonnet":      {Input: 3.00, Output: 15.00},
+	"claude-3-7-sonnet":      {Input: 3.00, Output: 15.00},
+	"claude-sonnet-4":        {Input: 3.00, Output: 15.00},
+	"claude-opus-4":          {Input: 15.00, Output: 75.00},
+}
+
+// llmCostMicros prices usage in micro-dollars.
+func llmCostMicros(model string, usage llm.Usage) int64 {
+	model = strings.ToLower(strings.TrimSpace(model))
+	var price modelPrice
+	best := 0
+	for prefix, p := range llmModelPrices {
+		if len(prefix) > best && strings.HasPrefix(model, prefix) {
+			price, best = p, len(prefix)
+		}
+	}
+	return int64(math.Round(float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output))
+}
+
+// usageScope attributes the LLM calls made under a context to one tenant and
+// tallies them so a handler can store the cost of a single answer.
+type usageScope struct {
+	ownerID string
+	kind    string // kind for chat completions; embeddings are always usageKindEmbedding
+
+	mu               sync.Mutex
+	promptTokens     int
+	completionTokens int
+	embeddingTokens  int
+	costMicros       int64
+	model            string
+
+	capOnce    sync.Once
+	capReached bool
+}
+
+type usageScopeKey struct{}
+
+func withUsageScope(ctx context.Context, ownerID, kind string) context.Context {
+	return context.WithValue(ctx, usageScopeKey{}, &usageScope{ownerID: ownerID, kind: kind})
+}
+
+func usageScopeFrom(ctx context.Context) *usageScope {
+	scope, _ := ctx.Value(usageScopeKey{}).(*usageScope)
+	return scope
+}
+
+func (s *usageScope) add(kind, model string, usage llm.Usage, cost int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kind == usageKindEmbedding {
+		s.embeddingTokens += usage.PromptTokens
+	} else {
+		s.promptTokens += usage.PromptTokens
+		s.completionTokens += usage.CompletionTokens
+		s.model = model
+	}
+	s.costMicros += cost
+}
+
+// messageUsage summarizes the tally for chat_messages: the total token count
+// and the breakdown stored under metadata.usage.
+func messageUsage(ctx context.Context) (int, map[string]interface{}) {
+	s := usageScopeFrom(ctx)
+	if s == nil {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := s.promptTokens + s.completionTokens + s.embeddingTokens
+	if total == 0 {
+		return 0, nil
+	}
+	return total, map[string]interface{}{
+		"model":            s.model,
+		"promptTokens":     s.promptTokens,
+		"completionTokens": s.completionTokens,
+		"embeddingTokens":  s.embeddingTokens,
+		"costUSD":          float64(s.costMicros) / 1e6,
+	}
+}
+
+// nullableTokenCount stores "not metered" as NULL rather than 0.
+func nullableTokenCount(n int) interface{} {
+	if n <= 0 {
+		return nil
+	}
+	return n
+}
+
+// recordLLMUsage adds one provider call to the context's tally and to the
+// owner's daily rollup. Calls outside a usage scope are not attributable and
+// are skipped.
+func (c *Controller) recordLLMUsage(ctx context.Context, kind, model string, usage llm.Usage) {
+	scope := usageScopeFrom(ctx)
+	if scope == nil || scope.ownerID == "" || usage.Total() == 0 {
+		return
+	}
+	cost := llmCostMicros(model, usage)
+	scope.add(kind, model, usage, cost)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := c.db.ExecContext(ctx, `INSERT INTO llm_usage_daily (user_id,usage_date,kind,model,requests,prompt_tokens,completion_tokens,cost_micros)
+			VALUES ($1,CURRENT_DATE,$2,$3,1,$4,$5,$6)
+			ON CONFLICT (user_id,usage_date,kind,model) DO UPDATE SET
+				requests=llm_usage_daily.requests+1,
+				prompt_tokens=llm_usage_daily.prompt_tokens+EXCLUDED.prompt_tokens,
+				completion_tokens=llm_usage_daily.completion_tokens+EXCLUDED.completion_tokens,
+				cost_micros=llm_usage_daily.cost_micros+EXCLUDED.cost_micros,
+				updated_at=CURRENT_TIMESTAMP`,
+			scope.ownerID, kind, model, usage.PromptTokens, usage.CompletionTokens, cost); err != nil {
+			c.logger.Warn("llm usage rollup failed", "user_id", scope.ownerID, "kind", kind, "error", err)
+		}
+	}()
+}
+
+// recordChatUsage records a completion, estimating the counts when the
+// provider did not report them (self-hosted servers, some streams).
+func (c *Controller) recordChatUsage(ctx context.Context, req llm.ChatRequest, resp llm.ChatResponse) {
+	usage := resp.Usage
+	if usage.Total() == 0 {
+		if resp.Content == "" {
+			return
+		}
+		for _, m := range req.Messages {
+			usage.PromptTokens += chunker.CountTokens(m.Content)
+		}
+		usage.CompletionTokens = chunker.CountTokens(resp.Content)
+	}
+	kind := usageKindChat
+	if scope := usageScopeFrom(ctx); scope != nil && scope.kind != "" {
+		kind = scope.kind
+	}
+	c.recordLLMUsage(ctx, kind, coalesce(resp.Model, req.Model), usage)
+}
+
+// spendCapReached reports whether the scope's owner has used up this month's
+// AI spend cap. It is evaluated once per scope.
+func (c *Controller) spendCapReached(ctx context.Context) bool {
+	scope := usageScopeFrom(ctx)
+	if scope == nil || scope.ownerID == "" {
+		return false
+	}
+	scope.capOnce.Do(func() {
+		qctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		err := c.db.QueryRowContext(qctx, `SELECT u.ai_spend_cap_usd IS NOT NULL
+				AND COALESCE(SUM(d.cost_micros),0) >= u.ai_spend_cap_usd*1000000
+			FROM users u
+			LEFT JOIN llm_usage_daily d ON d.user_id=u.id AND d.usage_date >= date_trunc('month', CURRENT_DATE)::date
+			WHERE u.id=$1
+			GROUP BY u.id,u.ai_spend_cap_usd`, scope.ownerID).Scan(&scope.capReached)
+		if err != nil {
+			c.logger.Warn("ai spend cap lookup failed", "user_id", scope.ownerID, "error", err)
+		}
+	})
+	return scope.capReached
+}
+
+// aiUsageSummary reports the owner's metered AI usage for the current month
+// against their spend cap, plus the last 30 days of the daily rollup.
+func (c *Controller) aiUsageSummary(r *http.Request, userID string) map[string]interface{} {
+	var prompt, completion, embedding, costMicros int64
+	var capUSD sql.NullFloat64
+	if err := c.db.QueryRowContext(r.Context(), `SELECT
+			COALESCE(SUM(d.prompt_tokens) FILTER (WHERE d.kind<>'embedding'),0),
+			COALESCE(SUM(d.completion_tokens),0),
+			COALESCE(SUM(d.prompt_tokens) FILTER (WHERE d.kind='embedding'),0),
+			COALESCE(SUM(d.cost_micros),0),
+			u.ai_spend_cap_usd::float8
+		FROM users u
+		LEFT JOIN llm_usage_daily d ON d.user_id=u.id AND d.usage_date >= date_trunc('month', CURRENT_DATE)::date
+		WHERE u.id=$1
+		GROUP BY u.id,u.ai_spend_cap_usd`, userID).Scan(&prompt, &completion, &embedding, &costMicros, &capUSD); err != nil {
+		c.logRequestWarn(r, "ai usage summary query failed", err, "user_id", userID)
+	}
+
+	daily := []map[string]interface{}{}
+	rows, err := c.db.QueryContext(r.Context(), `SELECT usage_date,kind,model,requests,prompt_tokens,completion_tokens,cost_micros
+		FROM llm_usage_daily
+		WHERE user_id=$1 AND usage_date > CURRENT_DATE - 30
+		ORDER BY usage_date DESC,kind,model`, userID)
+	if err != nil {
+		c.logRequestWarn(r, "ai usage daily query failed", err, "user_id", userID)
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var day time.Time
+			var kind, model string
+			var requests, promptTokens, completionTokens, cost int64
+			if err := rows.Scan(&day, &kind, &model, &requests, &promptTokens, &completionTokens, &cost); err != nil {
+				c.logRequestWarn(r, "ai usage daily row scan failed", err, "user_id", userID)
+				continue
+			}
+			daily = append(daily, map[string]interface{}{
+				"date":             day.Format("2006-01-02"),
+				"kind":             kind,
+				"model":            model,
+				"requests":         requests,
+				"promptTokens":     promptTokens,
+				"completionTokens": completionTokens,
+				"costUSD":          float64(cost) / 1e6,
+			})
+		}
+	}
+
+	var capValue interface{}
+	if capUSD.Valid {
+		capValue = capUSD.Float64
+	}
+	return map[string]interface{}{
+		"promptTokens":     prompt,
+		"completionTokens": completion,
+		"embeddingTokens":  embedding,
+		"costUSD":          float64(costMicros) / 1e6,
+		"monthlyCapUSD":    capValue,
+		"capReached":       capUSD.Valid && float64(costMicros) >= capUSD.Float64*1e6,
+		"daily":            daily,
+	}
+}
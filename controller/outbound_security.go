@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"net/netip"
 	"net/url"
 	"strconv"
@@ -11,6 +12,8 @@ import (
 	"time"
 )
 
+const maxOutboundRedirects = 3
+
 var blockedOutboundHosts = map[string]struct{}{
 	"localhost":                {},
 	"localhost.localdomain":    {},
@@ -145,3 +148,16 @@ func (c *Controller) validateWebhookTarget(raw string) (*url.URL, error) {
 	allowHTTP := !c.cfg.IsProduction
 	return c.validateOutboundURL(raw, allowHTTP)
 }
+
+// checkOutboundRedirect is an http.Client CheckRedirect that applies the
+// webhook target checks to every hop, so a validated endpoint cannot redirect
+// into a private network.
+func (c *Controller) checkOutboundRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxOutboundRedirects {
+		return fmt.Errorf("too many redirects")
+	}
+	if _, err := c.validateWebhookTarget(req.URL.String()); err != nil {
+		return err
+	}
+	return nil
+}
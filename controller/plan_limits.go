@@ -16,6 +16,9 @@ type PlanLimits struct {
 	HasFlows      bool     // conversation flow builder
 	HasPersona    bool     // AI persona / role builder
 	HasNavigation bool     // widget navigation builder
+	HasAITuning   bool     // per-widget chat model and temperature
+	AnswerTokens  int      // max per-widget answer length in tokens; 0 = provider max
+	SourceRefresh string   // most frequent scheduled source re-crawl: daily, weekly or monthly
 	Roles         []string // available AI roles for persona
 }
 
@@ -29,6 +32,8 @@ func limitsForPlan(plan string) PlanLimits {
 			Conversations: 1500,
 			ChatHistory:   50,
 			Leads:         15,
+			AnswerTokens:  500,
+			SourceRefresh: "weekly",
 			Roles:         []string{"professional", "casual"},
 		}
 	case "pro":
@@ -44,6 +49,9 @@ func limitsForPlan(plan string) PlanLimits {
 			HasFlows:      true,
 			HasPersona:    true,
 			HasNavigation: true,
+			HasAITuning:   true,
+			AnswerTokens:  1000,
+			SourceRefresh: "daily",
 			Roles:         []string{"professional", "casual", "sales", "marketing", "hr"},
 		}
 	case "enterprise":
@@ -56,6 +64,8 @@ func limitsForPlan(plan string) PlanLimits {
 			HasFlows:      true,
 			HasPersona:    true,
 			HasNavigation: true,
+			HasAITuning:   true,
+			SourceRefresh: "daily",
 			Roles:         []string{"professional", "casual", "sales", "marketing", "hr", "custom"},
 		}
 	default: // free
@@ -66,6 +76,8 @@ func limitsForPlan(plan string) PlanLimits {
 			Conversations: 300,
 			ChatHistory:   5,
 			Leads:         3,
+			AnswerTokens:  300,
+			SourceRefresh: "monthly",
 			Roles:         []string{},
 		}
 	}
@@ -117,6 +117,7 @@ func (c *Controller) PublicWebhook(w http.ResponseWriter, r *http.Request) {
 		WidgetKey string `json:"widgetKey"`
 		Message   string `json:"message"`
 		SessionID string `json:"sessionId"`
+		Language  string `json:"language"`
 	}
 	if err := utils.DecodeJSON(r, &body); err != nil || strings.TrimSpace(body.WidgetKey) == "" {
 		utils.JSONErr(w, http.StatusBadRequest, "widgetKey is required")
@@ -130,7 +131,8 @@ func (c *Controller) PublicWebhook(w http.ResponseWriter, r *http.Request) {
 	var ownerID string
 	var widgetID int64
 	var domainsRaw string
-	if err := c.db.QueryRow(`SELECT user_id,id,COALESCE(to_json(allowed_domains),'[]'::json)::text FROM widget_keys WHERE widget_key=$1 AND is_active=TRUE`, body.WidgetKey).Scan(&ownerID, &widgetID, &domainsRaw); err != nil {
+	var widgetUpdatedAt time.Time
+	if err := c.db.QueryRow(`SELECT user_id,id,COALESCE(to_json(allowed_domains),'[]'::json)::text,updated_at FROM widget_keys WHERE widget_key=$1 AND is_active=TRUE`, body.WidgetKey).Scan(&ownerID, &widgetID, &domainsRaw, &widgetUpdatedAt); err != nil {
 		if !errors.Is(err, sql.ErrNoRows) {
 			c.logRequestError(r, "public webhook widget lookup failed", err, "widget_key", body.WidgetKey)
 		}
@@ -180,97 +182,261 @@ func (c *Controller) PublicWebhook(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	matches, matchErr := c.pineconeQuery(ownerID, body.Message, 5)
-	relevantMatches := relevantRAGMatches(matches, 0.65)
-	if matchErr != nil {
-		c.logRequestWarn(r, "public webhook context lookup failed", matchErr, "widget_key", body.WidgetKey, "session_id", sessionID)
-	}
-	answer := "I don't have information about that. Please contact support."
-	if len(relevantMatches) > 0 {
-		if ai, aiErr := c.openAIAnswerWithContext(body.Message, relevantMatches); aiErr == nil && strings.TrimSpace(ai) != "" {
-			answer = ai
-		} else if aiErr != nil {
-			c.logRequestWarn(r, "public webhook response generation with context failed", aiErr, "widget_key", body.WidgetKey, "session_id", sessionID)
+	// Open the event stream before retrieval so the widget gets headers (and
+	// its typing indicator) as early as possible.
+	var stream *sseStream
+	if r.URL.Query().Get("stream") == "1" {
+		stream = startSSEStream(w)
+	}
+
+	r = r.WithContext(withUsageScope(r.Context(), ownerID, usageKindChat))
+	aiSettings := c.loadAISettings(r.Context(), ownerID)
+	aiSettings = aiSettings.withLanguage(aiSettings.answerLanguage(body.Message, body.Language))
+	answer := aiSettings.fallbackAnswer()
+	assistantMeta := map[string]interface{}{"language": aiSettings.language}
+	guard := c.loadGuardrails(r.Context(), ownerID)
+	suggestions := c.loadSuggestionSettings(r.Context(), ownerID)
+	history := c.loadChatHistory(r.Context(), sessionID, ownerID, chatHistoryTokenBudget)
+	// Blocked messages are answered with the refusal before any model call.
+	plan := c.planChatTurn(r.Context(), chatTurn{
+		OwnerID: ownerID,
+		Message: body.Message,
+		History: history,
+		AI:      aiSettings,
+		Guard:   &guard,
+		// Only opening questions use the answer cache; later answers depend
+		// on the conversation so far.
+		OpenCache: func(searchQuery string) *answerCache {
+			if len(history) > 0 {
+				return nil
+			}
+			return c.openAnswerCache(r.Context(), ownerID, widgetID, widgetUpdatedAt, searchQuery, aiSettings.language)
+		},
+	})
+	var relevantMatches []map[string]interface{}
+	var tools *chatTools
+	sources := []answerSource{}
+	followUps := []string{}
+	var rich *RichMessage
+	switch {
+	case plan.Violation != nil:
+		answer = guard.blockedAnswer(aiSettings)
+		assistantMeta["guardrail"] = plan.Violation
+		c.reportGuardrail(r, ownerID, widgetID, sessionID, *plan.Violation)
+	case plan.Curated != nil:
+		answer, rich = plan.Curated.Answer, plan.Curated.message()
+		assistantMeta["curated"] = plan.Curated.reference()
+	case plan.CacheHit != nil:
+		answer, sources = plan.CacheHit.Answer, plan.CacheHit.Sources
+		if plan.CacheHit.FollowUps != nil {
+			followUps = plan.CacheHit.FollowUps
+		}
+		assistantMeta["cached"] = plan.CacheHit.reference()
+	case plan.RetrievalErr != nil:
+		c.logRequestWarn(r, "public webhook context lookup failed", plan.RetrievalErr, "widget_key", body.WidgetKey, "session_id", sessionID)
+	default:
+		relevantMatches = plan.Matches
+		for _, v := range plan.Dropped {
+			c.reportGuardrail(r, ownerID, widgetID, sessionID, v)
+		}
+		if len(relevantMatches) == 0 {
+			c.recordKnowledgeGap(ownerID, widgetID, sessionID, body.Message, plan.BestScore)
+		}
+		tools = c.loadChatTools(r.Context(), chatToolScope{
+			OwnerID:   ownerID,
+			WidgetID:  widgetID,
+			SessionID: sessionID,
+			SourceURL: r.Referer(),
+			IPAddress: r.RemoteAddr,
+		})
+	}
+	streamedTokens := false
+	generated := false
+	if len(relevantMatches) > 0 || tools != nil {
+		prompt := ragPrompt{
+			System:  c.systemPromptForOwner(r.Context(), ownerID),
+			Query:   body.Message,
+			History: history,
+			Matches: relevantMatches,
+			AI:      aiSettings,
+			Tools:   tools,
+		}
+		var onDelta func(string) error
+		if stream != nil {
+			onDelta = func(delta string) error {
+				streamedTokens = true
+				return stream.send(map[string]interface{}{"type": "token", "token": delta})
+			}
+		}
+		ai, outputViolation, aiErr := c.generateChatAnswer(r.Context(), prompt, &guard, onDelta)
+		// A stream cut short keeps what was generated; a failed request
+		// keeps the fallback.
+		if ai != "" && (aiErr == nil || stream != nil) {
+			answer, generated = ai, true
+			sources = answerSources(ai, aiSettings.fallbackAnswer(), relevantMatches)
+		}
+		if aiErr != nil {
+			// A stream cut short by the visitor, a timeout or the provider
+			// keeps its partial text, which gets no follow-ups and is never
+			// cached.
+			if generated {
+				assistantMeta["interrupted"] = true
+			}
+			switch {
+			case stream != nil && r.Context().Err() != nil:
+				c.requestLogger(r).Info("public webhook stream cancelled by client", "widget_key", body.WidgetKey, "session_id", sessionID)
+			case errors.Is(aiErr, errSpendCapReached):
+				// The fallback answer stands; the cap is logged when reached.
+			case stream != nil:
+				c.logRequestWarn(r, "public webhook streamed response generation failed", aiErr, "widget_key", body.WidgetKey, "session_id", sessionID)
+			default:
+				c.logRequestWarn(r, "public webhook response generation with context failed", aiErr, "widget_key", body.WidgetKey, "session_id", sessionID)
+			}
+		}
+		if generated {
+			rich = tools.message(answer)
+			if outputViolation != nil {
+				answer, rich = guard.blockedAnswer(aiSettings), nil
+				sources = []answerSource{}
+				assistantMeta["guardrail"] = outputViolation
+				c.reportGuardrail(r, ownerID, widgetID, sessionID, *outputViolation)
+				// The stream only carried text from before the violation;
+				// replace it with the refusal.
+				if streamedTokens {
+					_ = stream.send(map[string]interface{}{"type": "replace", "response": answer})
+				}
+			} else if assistantMeta["interrupted"] == nil {
+				if suggestions.FollowUps && len(sources) > 0 {
+					for _, q := range c.suggestFollowUps(r.Context(), prompt, answer) {
+						if guard.blockedTopicIn(q) == "" {
+							followUps = append(followUps, q)
+						}
+					}
+				}
+				// Answers that took actions are not reused.
+				if tools.logged() == nil {
+					c.storeAnswerCache(r.Context(), plan.Cache, answer, sources, followUps)
+				}
+			}
 		}
 	}
-	insertResult, err := c.db.Exec(`INSERT INTO chat_messages (conversation_id,user_id,role,content,metadata)
-		SELECT c.id,$2,v.role,v.content,'{}'::jsonb
+	// Rich answers are sent as their plain text, which the widget renders;
+	// the structured message follows in the done event.
+	content := messageContent(answer, rich)
+	if stream != nil {
+		switch {
+		case !streamedTokens:
+			_ = stream.send(map[string]interface{}{"type": "token", "token": content})
+		case content != answer:
+			_ = stream.send(map[string]interface{}{"type": "replace", "response": content})
+		}
+	}
+
+	assistantMeta["sources"] = sources
+	if len(followUps) > 0 {
+		assistantMeta["followUps"] = followUps
+	}
+	if invocations := tools.logged(); invocations != nil {
+		assistantMeta["tools"] = invocations
+	}
+	if rich != nil {
+		assistantMeta["rich"] = rich
+	}
+	tokenCount, usage := messageUsage(r.Context())
+	if usage != nil {
+		assistantMeta["usage"] = usage
+	}
+
+	// The visitor may already be gone; persist and count the exchange anyway.
+	ctx := context.WithoutCancel(r.Context())
+	if !c.persistWidgetExchange(r, body.WidgetKey, sessionID, ownerID, widgetID, body.Message, content, assistantMeta, tokenCount) {
+		if stream != nil {
+			_ = stream.send(map[string]interface{}{"type": "error", "message": "session not found"})
+			return
+		}
+		utils.JSONErr(w, http.StatusNotFound, "session not found")
+		return
+	}
+	c.queueWidgetAnalytics(ctx, widgetID, "message_sent", map[string]interface{}{}, r)
+
+	if stream != nil {
+		_ = stream.send(map[string]interface{}{
+			"type":         "done",
+			"sessionId":    sessionID,
+			"sources":      sources,
+			"language":     aiSettings.language,
+			"followUps":    followUps,
+			"quickReplies": suggestions.QuickReplies,
+			"rich":         rich,
+		})
+		return
+	}
+	utils.JSONOK(w, map[string]interface{}{
+		"success":      true,
+		"sessionId":    sessionID,
+		"response":     content,
+		"sources":      sources,
+		"language":     aiSettings.language,
+		"followUps":    followUps,
+		"quickReplies": suggestions.QuickReplies,
+		"rich":         rich,
+	})
+}
+
+// persistWidgetExchange stores the visitor message and the assistant reply and
+// bumps the conversation counters. It reports false when the session no longer
+// belongs to this widget owner.
+func (c *Controller) persistWidgetExchange(r *http.Request, widgetKey, sessionID, ownerID string, widgetID int64, message, answer string, assistantMeta map[string]interface{}, tokenCount int) bool {
+	metaJSON, _ := json.Marshal(assistantMeta)
+	if len(assistantMeta) == 0 {
+		metaJSON = []byte("{}")
+	}
+	insertResult, err := c.db.Exec(`INSERT INTO chat_messages (conversation_id,user_id,role,content,metadata,token_count)
+		SELECT c.id,$2,v.role,v.content,v.metadata,v.token_count
 		FROM chat_conversations c
-		JOIN (VALUES ('user'::varchar,$3),('assistant'::varchar,$4)) AS v(role,content) ON TRUE
+		JOIN (VALUES ('user'::varchar,$3,'{}'::jsonb,NULL::int),('assistant'::varchar,$4,$6::jsonb,$7::int)) AS v(role,content,metadata,token_count) ON TRUE
 		WHERE c.id=$1 AND c.user_id=$2 AND c.is_deleted=FALSE AND (c.widget_key_id IS NULL OR c.widget_key_id=$5)`,
-		sessionID, ownerID, body.Message, answer, widgetID)
+		sessionID, ownerID, message, answer, widgetID, string(metaJSON), nullableTokenCount(tokenCount))
 	if err != nil {
-		c.logRequestWarn(r, "public webhook message insert failed", err, "widget_key", body.WidgetKey, "session_id", sessionID)
+		c.logRequestWarn(r, "public webhook message insert failed", err, "widget_key", widgetKey, "session_id", sessionID)
 	} else if rows, rowsErr := insertResult.RowsAffected(); rowsErr == nil && rows != 2 {
 		c.requestLogger(r).Warn("public webhook message insert skipped due to session ownership mismatch",
-			"widget_key", body.WidgetKey, "session_id", sessionID, "rows_affected", rows)
-		utils.JSONErr(w, http.StatusNotFound, "session not found")
-		return
+			"widget_key", widgetKey, "session_id", sessionID, "rows_affected", rows)
+		return false
 	}
 	if _, err := c.db.Exec(`UPDATE chat_conversations SET message_count=message_count+2,last_message_preview=$2,last_message_at=CURRENT_TIMESTAMP,updated_at=CURRENT_TIMESTAMP WHERE id=$1 AND user_id=$3 AND is_deleted=FALSE AND (widget_key_id IS NULL OR widget_key_id=$4)`,
-		sessionID, body.Message, ownerID, widgetID); err != nil {
-		c.logRequestWarn(r, "public webhook conversation update failed", err, "widget_key", body.WidgetKey, "session_id", sessionID)
+		sessionID, message, ownerID, widgetID); err != nil {
+		c.logRequestWarn(r, "public webhook conversation update failed", err, "widget_key", widgetKey, "session_id", sessionID)
 	}
-	c.queueWidgetAnalytics(r.Context(), widgetID, "message_sent", map[string]interface{}{}, r)
-
-	if r.URL.Query().Get("stream") == "1" {
-		streamWidgetResponse(w, sessionID, answer)
-		return
-	}
-	utils.JSONOK(w, map[string]interface{}{"success": true, "sessionId": sessionID, "response": answer})
+	return true
 }
 
-func streamWidgetResponse(w http.ResponseWriter, sessionID, answer string) {
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		utils.JSONOK(w, map[string]interface{}{"success": true, "sessionId": sessionID, "response": answer})
-		return
-	}
+// sseStream writes server-sent events, flushing after each one when the
+// underlying writer supports it.
+type sseStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
 
+func startSSEStream(w http.ResponseWriter) *sseStream {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
 	w.WriteHeader(http.StatusOK)
-
-	for _, chunk := range splitAnswerChunks(answer, 4) {
-		writeSSEEvent(w, map[string]interface{}{
-			"type":  "token",
-			"token": chunk,
-		})
-		flusher.Flush()
-	}
-	writeSSEEvent(w, map[string]interface{}{
-		"type":      "done",
-		"sessionId": sessionID,
-	})
-	flusher.Flush()
+	rc := http.NewResponseController(w)
+	// Generation can outlive the server-wide write timeout.
+	_ = rc.SetWriteDeadline(time.Now().Add(2 * time.Minute))
+	_ = rc.Flush()
+	return &sseStream{w: w, rc: rc}
 }
 
-func splitAnswerChunks(answer string, wordsPerChunk int) []string {
-	text := strings.TrimSpace(answer)
-	if text == "" {
-		return []string{""}
-	}
-	if wordsPerChunk <= 0 {
-		wordsPerChunk = 4
-	}
-	words := strings.Fields(text)
-	if len(words) <= wordsPerChunk {
-		return []string{text}
-	}
-	chunks := make([]string, 0, len(words)/wordsPerChunk+1)
-	for start := 0; start < len(words); start += wordsPerChunk {
-		end := start + wordsPerChunk
-		if end > len(words) {
-			end = len(words)
-		}
-		chunk := strings.Join(words[start:end], " ")
-		if end < len(words) {
-			chunk += " "
-		}
-		chunks = append(chunks, chunk)
+func (s *sseStream) send(payload map[string]interface{}) error {
+	writeSSEEvent(s.w, payload)
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
 	}
-	return chunks
+	return nil
 }
 
 func writeSSEEvent(w http.ResponseWriter, payload map[string]interface{}) {
@@ -482,29 +648,65 @@ func (c *Controller) PublicContact(w http.ResponseWriter, r *http.Request) {
 		utils.JSONErr(w, http.StatusForbidden, "widget access denied for this domain")
 		return
 	}
-	var leadID string
-	err = c.db.QueryRow(`INSERT INTO leads (user_id,widget_key_id,session_id,name,email,phone,status,source_url,ip_address)
-		VALUES ($1,$2,$3,$4,$5,$6,'new',$7,$8)
-		ON CONFLICT (user_id,session_id) DO UPDATE SET name=COALESCE(EXCLUDED.name,leads.name),email=COALESCE(EXCLUDED.email,leads.email),phone=COALESCE(EXCLUDED.phone,leads.phone),updated_at=CURRENT_TIMESTAMP
-		RETURNING id`,
-		ownerID, widgetID, body.SessionID, utils.Nullable(body.Name), utils.Nullable(body.Email), utils.Nullable(body.Phone),
-		utils.Nullable(r.Referer()), utils.Nullable(r.RemoteAddr)).Scan(&leadID)
+	leadID, err := c.captureLead(r.Context(), capturedLead{
+		OwnerID:   ownerID,
+		WidgetID:  widgetID,
+		SessionID: body.SessionID,
+		Name:      body.Name,
+		Email:     body.Email,
+		Phone:     body.Phone,
+		SourceURL: r.Referer(),
+		IPAddress: r.RemoteAddr,
+	})
 	if err != nil {
 		c.logRequestError(r, "public contact lead upsert failed", err, "widget_key", body.WidgetKey, "owner_id", ownerID)
 		utils.JSONErr(w, http.StatusInternalServerError, "db error")
 		return
 	}
-	c.queueWebhookEvent(ownerID, leadID, "lead.created", map[string]interface{}{"leadId": leadID})
 
-	if strings.TrimSpace(body.Email) != "" {
-		email := body.Email
-		name := body.Name
+	utils.JSONOK(w, map[string]interface{}{"success": true, "lead": map[string]interface{}{"id": leadID}})
+}
+
+// capturedLead is a visitor's contact details from the widget form or the
+// capture_lead chat tool. WidgetID is 0 for dashboard chats.
+type capturedLead struct {
+	OwnerID   string
+	WidgetID  int64
+	SessionID string
+	Name      string
+	Email     string
+	Phone     string
+	SourceURL string
+	IPAddress string
+}
+
+// captureLead upserts the session's lead, queues the lead webhook and, on
+// plans with follow-ups, emails the visitor once.
+func (c *Controller) captureLead(ctx context.Context, lead capturedLead) (string, error) {
+	var widget interface{}
+	if lead.WidgetID > 0 {
+		widget = lead.WidgetID
+	}
+	var leadID string
+	err := c.db.QueryRowContext(ctx, `INSERT INTO leads (user_id,widget_key_id,session_id,name,email,phone,status,source_url,ip_address)
+		VALUES ($1,$2,$3,$4,$5,$6,'new',$7,$8)
+		ON CONFLICT (user_id,session_id) DO UPDATE SET name=COALESCE(EXCLUDED.name,leads.name),email=COALESCE(EXCLUDED.email,leads.email),phone=COALESCE(EXCLUDED.phone,leads.phone),updated_at=CURRENT_TIMESTAMP
+		RETURNING id`,
+		lead.OwnerID, widget, lead.SessionID, utils.Nullable(lead.Name), utils.Nullable(lead.Email), utils.Nullable(lead.Phone),
+		utils.Nullable(lead.SourceURL), utils.Nullable(lead.IPAddress)).Scan(&leadID)
+	if err != nil {
+		return "", err
+	}
+	c.queueWebhookEvent(lead.OwnerID, leadID, "lead.created", map[string]interface{}{"leadId": leadID})
+	if strings.TrimSpace(lead.Email) != "" {
+		email := lead.Email
+		name := lead.Name
 		lID := leadID
-		oID := ownerID
+		oID := lead.OwnerID
 		go func() {
 			var planType string
 			if err := c.db.QueryRow(`SELECT plan_type FROM users WHERE id=$1`, oID).Scan(&planType); err != nil {
-				c.logger.Warn("public contact follow-up plan lookup failed", "owner_id", oID, "lead_id", lID, "error", err)
+				c.logger.Warn("lead follow-up plan lookup failed", "owner_id", oID, "lead_id", lID, "error", err)
 				return
 			}
 			if planType != "basic" && planType != "enterprise" {
@@ -512,7 +714,7 @@ func (c *Controller) PublicContact(w http.ResponseWriter, r *http.Request) {
 			}
 			var followUpSentAt sql.NullTime
 			if err := c.db.QueryRow(`SELECT follow_up_sent_at FROM leads WHERE id=$1`, lID).Scan(&followUpSentAt); err != nil {
-				c.logger.Warn("public contact follow-up state lookup failed", "lead_id", lID, "error", err)
+				c.logger.Warn("lead follow-up state lookup failed", "lead_id", lID, "error", err)
 				return
 			}
 			if followUpSentAt.Valid {
@@ -520,17 +722,16 @@ func (c *Controller) PublicContact(w http.ResponseWriter, r *http.Request) {
 			}
 			var ownerEmail string
 			if err := c.db.QueryRow(`SELECT email FROM users WHERE id=$1`, oID).Scan(&ownerEmail); err != nil {
-				c.logger.Warn("public contact owner email lookup failed", "owner_id", oID, "lead_id", lID, "error", err)
+				c.logger.Warn("lead follow-up owner email lookup failed", "owner_id", oID, "lead_id", lID, "error", err)
 				return
 			}
 			c.sendFollowUpEmail(email, name, ownerEmail)
 			if _, err := c.db.Exec(`UPDATE leads SET follow_up_sent_at=CURRENT_TIMESTAMP WHERE id=$1`, lID); err != nil {
-				c.logger.Warn("public contact follow-up timestamp update failed", "lead_id", lID, "error", err)
+				c.logger.Warn("lead follow-up timestamp update failed", "lead_id", lID, "error", err)
 			}
 		}()
 	}
-
-	utils.JSONOK(w, map[string]interface{}{"success": true, "lead": map[string]interface{}{"id": leadID}})
+	return leadID, nil
 }
 
 func (c *Controller) PublicRating(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"konvoq-backend/platform/chunker"
+)
+
+// EvalDocument is a page indexed for an offline evaluation run.
+type EvalDocument struct {
+	URL   string
+	Title string
+	Text  string
+}
+
+// EvalSource is one retrieved chunk an evaluated answer was grounded on.
+type EvalSource struct {
+	URL     string  `json:"url,omitempty"`
+	Title   string  `json:"title"`
+	Section string  `json:"section,omitempty"`
+	Score   float64 `json:"score"`
+}
+
+// EvalResult is the outcome of one evaluated question. Sources lists every
+// retrieved chunk, whether or not the answer ended up citing it.
+type EvalResult struct {
+	Answer   string       `json:"answer"`
+	Curated  bool         `json:"curated"`
+	Fallback bool         `json:"fallback"`
+	Blocked  bool         `json:"blocked"`
+	Sources  []EvalSource `json:"sources"`
+}
+
+// EvalOptions adjusts an evaluation run without touching stored settings.
+type EvalOptions struct {
+	// Retrieval replaces the owner's widget retrieval settings when set.
+	Retrieval *RetrievalSettings
+}
+
+type retrievalOverrideKey struct{}
+
+// EvalRetrievalSettings parses retrieval settings in the widget_config.retrieval
+// JSON shape, filling unset fields with the defaults.
+func EvalRetrievalSettings(raw []byte) (RetrievalSettings, error) {
+	settings := defaultRetrievalSettings()
+	if err := json.Unmarshal(raw, &settings); err != nil {
+		return settings, err
+	}
+	return settings, settings.validate()
+}
+
+// IndexEvalDocuments chunks docs the way scraped pages are chunked and writes
+// them to the vector store only, leaving the keyword index untouched so an
+// evaluation never adds rows to a real account.
+func (c *Controller) IndexEvalDocuments(ctx context.Context, ownerID string, docs []EvalDocument, opts chunker.Options) error {
+	if c.vectors == nil {
+		return errors.New("vector store is not configured")
+	}
+	byURL := map[string][]scrapedPage{}
+	var urls []string
+	for _, d := range docs {
+		if _, ok := byURL[d.URL]; !ok {
+			urls = append(urls, d.URL)
+		}
+		byURL[d.URL] = append(byURL[d.URL], scrapedPage{URL: d.URL, Title: d.Title, Text: d.Text})
+	}
+	for _, u := range urls {
+		records := ragRecords(ownerID, c.buildRAGChunks(ownerID, u, byURL[u], opts))
+		if err := c.upsertVectorRecords(ctx, ownerID, records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EvalAnswer answers a single question for ownerID through the same steps as
+// the widget chat (planChatTurn and generateChatAnswer, with the widget's
+// guardrails) without a conversation, answer cache, tools, usage metering or
+// knowledge gap recording.
+func (c *Controller) EvalAnswer(ctx context.Context, ownerID, question string, opts EvalOptions) (EvalResult, error) {
+	if opts.Retrieval != nil {
+		ctx = context.WithValue(ctx, retrievalOverrideKey{}, *opts.Retrieval)
+	}
+	aiSettings := c.loadAISettings(ctx, ownerID)
+	aiSettings = aiSettings.withLanguage(aiSettings.answerLanguage(question, ""))
+	guard := c.loadGuardrails(ctx, ownerID)
+	result := EvalResult{Answer: aiSettings.fallbackAnswer(), Fallback: true, Sources: []EvalSource{}}
+	plan := c.planChatTurn(ctx, chatTurn{OwnerID: ownerID, Message: question, AI: aiSettings, Guard: &guard})
+	switch {
+	case plan.Violation != nil:
+		result.Answer, result.Blocked, result.Fallback = guard.blockedAnswer(aiSettings), true, false
+		return result, nil
+	case plan.Curated != nil:
+		result.Answer, result.Curated, result.Fallback = plan.Curated.Answer, true, false
+		return result, nil
+	case plan.RetrievalErr != nil:
+		return result, plan.RetrievalErr
+	}
+	for _, s := range ragSources(plan.Matches) {
+		result.Sources = append(result.Sources, EvalSource{URL: s.URL, Title: s.Title, Section: s.Section, Score: s.Score})
+	}
+	if len(plan.Matches) == 0 {
+		return result, nil
+	}
+	answer, violation, err := c.generateChatAnswer(ctx, ragPrompt{
+		System:  c.systemPromptForOwner(ctx, ownerID),
+		Query:   question,
+		Matches: plan.Matches,
+		AI:      aiSettings,
+	}, &guard, nil)
+	if err != nil {
+		return result, err
+	}
+	if violation != nil {
+		result.Answer, result.Blocked, result.Fallback = guard.blockedAnswer(aiSettings), true, false
+		return result, nil
+	}
+	if answer = strings.TrimSpace(answer); answer != "" {
+		result.Answer = answer
+		result.Fallback = strings.Contains(answer, aiSettings.fallbackAnswer())
+	}
+	return result, nil
+}
@@ -0,0 +1,394 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"konvoq-backend/platform/llm"
+	"konvoq-backend/platform/vectorstore"
+	"konvoq-backend/utils"
+)
+
+// RetrievalSettings tune context retrieval per widget (widget_config.retrieval).
+type RetrievalSettings struct {
+	TopK     int     `json:"topK"`
+	MinScore float64 `json:"minScore"`
+	Hybrid   bool    `json:"hybrid"`
+	Rerank   bool    `json:"rerank"`
+	// CuratedMinScore is the confidence a curated Q&A match needs to be
+	// returned instead of a generated answer.
+	CuratedMinScore float64 `json:"curatedMinScore"`
+	// AnswerCache serves a recent answer again when a widget visitor asks a
+	// question at least AnswerCacheMinScore similar and the knowledge base has
+	// not changed since.
+	AnswerCache         bool    `json:"answerCache"`
+	AnswerCacheMinScore float64 `json:"answerCacheMinScore"`
+}
+
+const (
+	defaultRetrievalTopK     = 5
+	defaultRetrievalMinScore = 0.65
+	maxRetrievalTopK         = 10
+	defaultCuratedMinScore   = 0.8
+	defaultAnswerCacheScore  = 0.95
+	// rrfK is the reciprocal rank fusion constant from Cormack et al.; larger
+	// values flatten the advantage of top-ranked results.
+	rrfK = 60
+	// keywordMinCoverage is the share of the query's search terms a keyword
+	// match must contain, so a chunk sharing one common word with a longer
+	// question does not count as relevant context.
+	keywordMinCoverage = 0.6
+)
+
+func defaultRetrievalSettings() RetrievalSettings {
+	return RetrievalSettings{
+		TopK:                defaultRetrievalTopK,
+		MinScore:            defaultRetrievalMinScore,
+		Hybrid:              true,
+		CuratedMinScore:     defaultCuratedMinScore,
+		AnswerCache:         true,
+		AnswerCacheMinScore: defaultAnswerCacheScore,
+	}
+}
+
+func (s RetrievalSettings) validate() error {
+	if s.TopK < 1 || s.TopK > maxRetrievalTopK {
+		return fmt.Errorf("topK must be between 1 and %d", maxRetrievalTopK)
+	}
+	if s.MinScore <= 0 || s.MinScore >= 1 {
+		return fmt.Errorf("minScore must be between 0 and 1")
+	}
+	if s.CuratedMinScore <= 0 || s.CuratedMinScore > 1 {
+		return fmt.Errorf("curatedMinScore must be greater than 0 and at most 1")
+	}
+	if s.AnswerCacheMinScore <= 0 || s.AnswerCacheMinScore > 1 {
+		return fmt.Errorf("answerCacheMinScore must be greater than 0 and at most 1")
+	}
+	return nil
+}
+
+func (c *Controller) GetRetrieval(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	var cfgRaw []byte
+	err := c.db.QueryRow(`SELECT widget_config FROM widget_keys WHERE user_id=$1`, claims.UserID).Scan(&cfgRaw)
+	if err != nil {
+		utils.JSONErr(w, http.StatusNotFound, "widget not found")
+		return
+	}
+	utils.JSONOK(w, map[string]interface{}{
+		"success":   true,
+		"retrieval": retrievalSettingsFromConfig(cfgRaw),
+	})
+}
+
+func (c *Controller) UpdateRetrieval(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	if err := c.RequireCSRF(r); err != nil {
+		utils.JSONErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+	body := defaultRetrievalSettings()
+	if err := utils.DecodeJSON(r, &body); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	if err := body.validate(); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	patch := map[string]interface{}{"retrieval": body}
+	patchJSON, _ := json.Marshal(patch)
+
+	var widgetKey string
+	err := c.db.QueryRow(`UPDATE widget_keys SET widget_config = widget_config || $2::jsonb, updated_at=CURRENT_TIMESTAMP WHERE user_id=$1 RETURNING widget_key`,
+		claims.UserID, string(patchJSON)).Scan(&widgetKey)
+	if err != nil {
+		c.logRequestError(r, "update retrieval settings failed", err, "user_id", claims.UserID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	_ = c.redis.Del(ctx, "widget:"+widgetKey).Err()
+
+	utils.JSONOK(w, map[string]interface{}{"success": true, "retrieval": body})
+}
+
+// retrievalSettingsFromConfig overlays widget_config.retrieval on the
+// defaults, discarding stored values that no longer validate.
+func retrievalSettingsFromConfig(cfgRaw []byte) RetrievalSettings {
+	settings := defaultRetrievalSettings()
+	var cfg struct {
+		Retrieval json.RawMessage `json:"retrieval"`
+	}
+	if err := json.Unmarshal(cfgRaw, &cfg); err != nil || len(cfg.Retrieval) == 0 {
+		return settings
+	}
+	stored := defaultRetrievalSettings()
+	if err := json.Unmarshal(cfg.Retrieval, &stored); err != nil || stored.validate() != nil {
+		return settings
+	}
+	return stored
+}
+
+func (c *Controller) loadRetrievalSettings(ctx context.Context, ownerID string) RetrievalSettings {
+	if s, ok := ctx.Value(retrievalOverrideKey{}).(RetrievalSettings); ok {
+		return s
+	}
+	var cfgRaw []byte
+	if err := c.db.QueryRowContext(ctx, `SELECT widget_config FROM widget_keys WHERE user_id=$1`, ownerID).Scan(&cfgRaw); err != nil {
+		return defaultRetrievalSettings()
+	}
+	return retrievalSettingsFromConfig(cfgRaw)
+}
+
+// retrieveContext returns the chunks to ground an answer on: vector matches
+// above the widget's threshold fused with keyword matches that cover most of
+// the query's terms by reciprocal rank fusion, optionally re-ranked by the
+// LLM, capped at topK. bestScore is the top
+// vector similarity before thresholding (0 when there was none), which tells
+// a near miss from a topic the knowledge base does not cover at all. An error
+// is only returned when every retrieval path failed.
+func (c *Controller) retrieveContext(ctx context.Context, ownerID, query string) (matches []map[string]interface{}, bestScore float64, err error) {
+	settings := c.loadRetrievalSettings(ctx, ownerID)
+	candidates := settings.TopK * 2
+
+	vectorMatches, vectorErr := c.vectorQuery(ctx, ownerID, query, candidates)
+	for _, m := range vectorMatches {
+		if score, ok := ragMatchScore(m); ok && score > bestScore {
+			bestScore = score
+		}
+	}
+	vectorMatches = relevantRAGMatches(vectorMatches, settings.MinScore)
+	for _, m := range vectorMatches {
+		m["matchType"] = "vector"
+	}
+	if !settings.Hybrid {
+		return capMatches(vectorMatches, settings.TopK), bestScore, vectorErr
+	}
+
+	keywordMatches, keywordErr := c.keywordQuery(ctx, ownerID, query, candidates)
+	if keywordErr != nil {
+		c.logger.Warn("keyword retrieval failed", "user_id", ownerID, "error", keywordErr)
+		if vectorErr != nil {
+			return nil, bestScore, vectorErr
+		}
+	}
+	if vectorErr != nil {
+		c.logger.Warn("vector retrieval failed; using keyword matches only", "user_id", ownerID, "error", vectorErr)
+	}
+
+	fused := fuseRAGMatches(vectorMatches, keywordMatches)
+	if settings.Rerank && len(fused) > 1 {
+		fused = c.rerankMatches(ctx, query, fused)
+	}
+	return capMatches(fused, settings.TopK), bestScore, nil
+}
+
+func capMatches(matches []map[string]interface{}, topK int) []map[string]interface{} {
+	if topK > 0 && len(matches) > topK {
+		return matches[:topK]
+	}
+	return matches
+}
+
+// fuseRAGMatches merges ranked lists by reciprocal rank fusion. A chunk found
+// by both retrievers keeps the vector match (and its cosine score).
+func fuseRAGMatches(lists ...[]map[string]interface{}) []map[string]interface{} {
+	scores := map[string]float64{}
+	byID := map[string]map[string]interface{}{}
+	order := make([]string, 0)
+	for _, list := range lists {
+		for rank, m := range list {
+			id, _ := m["id"].(string)
+			if id == "" {
+				continue
+			}
+			scores[id] += 1.0 / float64(rrfK+rank+1)
+			if existing, ok := byID[id]; ok {
+				existing["matchType"] = "hybrid"
+				continue
+			}
+			byID[id] = m
+			order = append(order, id)
+		}
+	}
+	fused := make([]map[string]interface{}, 0, len(order))
+	for _, id := range order {
+		m := byID[id]
+		m["rrfScore"] = scores[id]
+		fused = append(fused, m)
+	}
+	sort.SliceStable(fused, func(i, j int) bool {
+		return fused[i]["rrfScore"].(float64) > fused[j]["rrfScore"].(float64)
+	})
+	return fused
+}
+
+// keywordIndexUpsert mirrors chunk text into rag_chunks for full-text search.
+func (c *Controller) keywordIndexUpsert(ctx context.Context, userID string, records []vectorstore.Record) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO rag_chunks (user_id,id,url,page_title,heading_path,chunk_index,content,widget_key,source_type,source_key,
+			description,canonical_url,language)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+		ON CONFLICT (user_id,id) DO UPDATE SET
+			url=EXCLUDED.url,page_title=EXCLUDED.page_title,heading_path=EXCLUDED.heading_path,chunk_index=EXCLUDED.chunk_index,content=EXCLUDED.content,
+			widget_key=EXCLUDED.widget_key,source_type=EXCLUDED.source_type,source_key=EXCLUDED.source_key,
+			description=EXCLUDED.description,canonical_url=EXCLUDED.canonical_url,language=EXCLUDED.language,updated_at=CURRENT_TIMESTAMP`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, rec := range records {
+		if _, err := stmt.ExecContext(ctx, userID, rec.ID, rec.URL, rec.PageTitle, rec.HeadingPath, rec.ChunkIndex, rec.Text,
+			rec.WidgetKey, rec.SourceType, rec.SourceKey, rec.Description, rec.CanonicalURL, rec.Language); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// keywordQuery runs a full-text search over the owner's chunks, keeping
+// chunks that contain at least keywordMinCoverage of the query's terms (two
+// or more for multi-term queries). Matches carry ts_rank_cd as keywordRank
+// and no score, since the rank is not on the cosine scale MinScore and
+// citations use.
+func (c *Controller) keywordQuery(ctx context.Context, ownerID, query string, limit int) ([]map[string]interface{}, error) {
+	terms := keywordSearchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	minTerms := keywordMinTerms(len(terms))
+	rows, err := c.db.QueryContext(ctx, `SELECT id,url,page_title,heading_path,chunk_index,content,widget_key,source_type,source_key,
+			description,canonical_url,language,rank,matched
+		FROM (SELECT *,ts_rank_cd(search_vector,q) AS rank,
+				(SELECT COUNT(*) FROM unnest(string_to_array($4,' ')) t WHERE search_vector @@ to_tsquery('simple',t)) AS matched
+			FROM rag_chunks,to_tsquery('simple',$2) q
+			WHERE user_id=$1 AND search_vector @@ q) k
+		WHERE matched >= $5
+		ORDER BY matched DESC,rank DESC
+		LIMIT $3`, ownerID, strings.Join(terms, " | "), limit, strings.Join(terms, " "), minTerms)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	namespace := vectorNamespace(ownerID)
+	matches := make([]map[string]interface{}, 0, limit)
+	for rows.Next() {
+		var rec vectorstore.Record
+		var rank float64
+		var matched int
+		if err := rows.Scan(&rec.ID, &rec.URL, &rec.PageTitle, &rec.HeadingPath, &rec.ChunkIndex, &rec.Text, &rec.WidgetKey,
+			&rec.SourceType, &rec.SourceKey, &rec.Description, &rec.CanonicalURL, &rec.Language, &rank, &matched); err != nil {
+			return nil, err
+		}
+		rec.UserID = ownerID
+		matches = append(matches, map[string]interface{}{
+			"id":           rec.ID,
+			"keywordRank":  rank,
+			"termCoverage": float64(matched) / float64(len(terms)),
+			"metadata":     rec.Metadata(namespace),
+			"matchType":    "keyword",
+		})
+	}
+	return matches, rows.Err()
+}
+
+// keywordMinTerms is how many of n query terms a keyword match must contain.
+func keywordMinTerms(n int) int {
+	need := int(math.Ceil(float64(n) * keywordMinCoverage))
+	if n >= 2 {
+		need = max(need, 2)
+	}
+	return max(need, 1)
+}
+
+var keywordStopWords = map[string]struct{}{
+	"a": {}, "an": {}, "and": {}, "are": {}, "can": {}, "do": {}, "does": {}, "for": {}, "how": {}, "i": {},
+	"in": {}, "is": {}, "it": {}, "me": {}, "my": {}, "of": {}, "on": {}, "or": {}, "the": {}, "to": {},
+	"we": {}, "what": {}, "when": {}, "where": {}, "which": {}, "who": {}, "why": {}, "with": {}, "you": {}, "your": {},
+}
+
+// keywordSearchTerms lowercases the query into letter/digit tokens safe to
+// embed in a to_tsquery expression, dropping common question words.
+func keywordSearchTerms(query string) []string {
+	tokens := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	seen := map[string]struct{}{}
+	terms := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		if _, stop := keywordStopWords[t]; stop {
+			continue
+		}
+		if _, dup := seen[t]; dup {
+			continue
+		}
+		seen[t] = struct{}{}
+		terms = append(terms, t)
+		if len(terms) == 16 {
+			break
+		}
+	}
+	return terms
+}
+
+// rerankMatches asks the chat model to order the candidates by relevance. Any
+// failure keeps the fused order; passages the model omits are appended last.
+func (c *Controller) rerankMatches(ctx context.Context, query string, matches []map[string]interface{}) []map[string]interface{} {
+	if c.llm == nil {
+		return matches
+	}
+	var passages strings.Builder
+	for i, m := range matches {
+		meta, _ := m["metadata"].(map[string]interface{})
+		text, _ := meta["text"].(string)
+		fmt.Fprintf(&passages, "[%d] %s\n", i+1, strings.Join(strings.Fields(truncateRunes(text, 400)), " "))
+	}
+	temperature := 0.0
+	reply, err := c.llmChat(ctx, llm.ChatRequest{
+		Messages: []llm.Message{
+			{Role: "system", Content: "You rank passages by how well they answer a question. Reply with a JSON array of passage numbers, most relevant first, and nothing else."},
+			{Role: "user", Content: "Question: " + query + "\n\nPassages:\n" + passages.String()},
+		},
+		Temperature: &temperature,
+		MaxTokens:   60,
+	})
+	if err != nil {
+		return matches
+	}
+	start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]")
+	if start < 0 || end <= start {
+		return matches
+	}
+	var order []int
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &order); err != nil || len(order) == 0 {
+		return matches
+	}
+	used := make([]bool, len(matches))
+	ranked := make([]map[string]interface{}, 0, len(matches))
+	for _, n := range order {
+		if n < 1 || n > len(matches) || used[n-1] {
+			continue
+		}
+		used[n-1] = true
+		ranked = append(ranked, matches[n-1])
+	}
+	for i, m := range matches {
+		if !used[i] {
+			ranked = append(ranked, m)
+		}
+	}
+	return ranked
+}
@@ -0,0 +1,329 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rich message types. A rich message is stored in chat_messages.metadata.rich
+// while chat_messages.content keeps its plain-text fallback, so history,
+// previews and channels that only show text keep working.
+const (
+	richMessageCard     = "card"
+	richMessageCarousel = "carousel"
+	richMessageButtons  = "buttons"
+	richMessageForm     = "form"
+)
+
+const (
+	maxRichCards           = 10
+	maxRichButtons         = 10
+	maxRichCardButtons     = 3
+	maxRichFormFields      = 10
+	maxRichFieldOptions    = 20
+	maxRichTitleChars      = 80
+	maxRichSubtitleChars   = 300
+	maxRichLabelChars      = 40
+	maxRichValueChars      = 200
+	maxRichURLChars        = 2048
+	defaultRichSubmitLabel = "Submit"
+)
+
+// RichMessage is a structured assistant reply. Text is the reply text the
+// components are shown under; owners leave it empty and it is filled from the
+// answer when the message is sent.
+type RichMessage struct {
+	Type        string          `json:"type"`
+	Text        string          `json:"text,omitempty"`
+	Cards       []RichCard      `json:"cards,omitempty"`
+	Buttons     []RichButton    `json:"buttons,omitempty"`
+	Fields      []RichFormField `json:"fields,omitempty"`
+	SubmitLabel string          `json:"submitLabel,omitempty"`
+}
+
+// RichCard is one card of a card or carousel message.
+type RichCard struct {
+	Title    string       `json:"title"`
+	Subtitle string       `json:"subtitle,omitempty"`
+	ImageURL string       `json:"imageUrl,omitempty"`
+	URL      string       `json:"url,omitempty"`
+	Buttons  []RichButton `json:"buttons,omitempty"`
+}
+
+// RichButton either sends Value as the visitor's next message ("reply") or
+// opens the Value URL ("link").
+type RichButton struct {
+	Label  string `json:"label"`
+	Action string `json:"action"`
+	Value  string `json:"value"`
+}
+
+// RichFormField is one input of a form message. The widget sends the filled
+// form back as the visitor's next message.
+type RichFormField struct {
+	Name     string   `json:"name"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required,omitempty"`
+	Options  []string `json:"options,omitempty"`
+}
+
+var (
+	richFieldNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,39}$`)
+	richFieldTypes       = map[string]bool{"text": true, "email": true, "phone": true, "textarea": true, "select": true}
+)
+
+// normalize trims the message, fills defaults and checks it against its type's
+// limits. Text is left alone; callers set it.
+func (m *RichMessage) normalize() error {
+	m.Type = strings.ToLower(strings.TrimSpace(m.Type))
+	switch m.Type {
+	case richMessageCard, richMessageCarousel:
+		if m.Type == richMessageCard && len(m.Cards) != 1 {
+			return errors.New("a card message needs exactly one card")
+		}
+		if m.Type == richMessageCarousel && (len(m.Cards) < 2 || len(m.Cards) > maxRichCards) {
+			return fmt.Errorf("a carousel message needs 2-%d cards", maxRichCards)
+		}
+		for i := range m.Cards {
+			if err := m.Cards[i].normalize(); err != nil {
+				return err
+			}
+		}
+		m.Buttons, m.Fields, m.SubmitLabel = nil, nil, ""
+	case richMessageButtons:
+		if len(m.Buttons) == 0 || len(m.Buttons) > maxRichButtons {
+			return fmt.Errorf("a buttons message needs 1-%d buttons", maxRichButtons)
+		}
+		for i := range m.Buttons {
+			if err := m.Buttons[i].normalize(); err != nil {
+				return err
+			}
+		}
+		m.Cards, m.Fields, m.SubmitLabel = nil, nil, ""
+	case richMessageForm:
+		if len(m.Fields) == 0 || len(m.Fields) > maxRichFormFields {
+			return fmt.Errorf("a form message needs 1-%d fields", maxRichFormFields)
+		}
+		seen := map[string]bool{}
+		for i := range m.Fields {
+			if err := m.Fields[i].normalize(); err != nil {
+				return err
+			}
+			if seen[m.Fields[i].Name] {
+				return fmt.Errorf("form field %q is repeated", m.Fields[i].Name)
+			}
+			seen[m.Fields[i].Name] = true
+		}
+		m.SubmitLabel = strings.TrimSpace(m.SubmitLabel)
+		if m.SubmitLabel == "" {
+			m.SubmitLabel = defaultRichSubmitLabel
+		}
+		if utf8.RuneCountInString(m.SubmitLabel) > maxRichLabelChars {
+			return fmt.Errorf("submitLabel must be at most %d characters", maxRichLabelChars)
+		}
+		m.Cards, m.Buttons = nil, nil
+	default:
+		return errors.New("type must be one of card, carousel, buttons or form")
+	}
+	return nil
+}
+
+func (c *RichCard) normalize() error {
+	c.Title = strings.TrimSpace(c.Title)
+	c.Subtitle = strings.TrimSpace(c.Subtitle)
+	if c.Title == "" || utf8.RuneCountInString(c.Title) > maxRichTitleChars {
+		return fmt.Errorf("each card title must be 1-%d characters", maxRichTitleChars)
+	}
+	if utf8.RuneCountInString(c.Subtitle) > maxRichSubtitleChars {
+		return fmt.Errorf("card subtitles must be at most %d characters", maxRichSubtitleChars)
+	}
+	var err error
+	if c.ImageURL, err = richURL(c.ImageURL, "imageUrl"); err != nil {
+		return err
+	}
+	if c.URL, err = richURL(c.URL, "url"); err != nil {
+		return err
+	}
+	if len(c.Buttons) > maxRichCardButtons {
+		return fmt.Errorf("cards can have at most %d buttons", maxRichCardButtons)
+	}
+	for i := range c.Buttons {
+		if err := c.Buttons[i].normalize(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *RichButton) normalize() error {
+	b.Label = strings.TrimSpace(b.Label)
+	b.Action = strings.ToLower(strings.TrimSpace(b.Action))
+	b.Value = strings.TrimSpace(b.Value)
+	if b.Label == "" || utf8.RuneCountInString(b.Label) > maxRichLabelChars {
+		return fmt.Errorf("each button label must be 1-%d characters", maxRichLabelChars)
+	}
+	switch b.Action {
+	case "", "reply":
+		b.Action = "reply"
+		if b.Value == "" {
+			b.Value = b.Label
+		}
+		if utf8.RuneCountInString(b.Value) > maxRichValueChars {
+			return fmt.Errorf("reply button values must be at most %d characters", maxRichValueChars)
+		}
+	case "link":
+		link, err := richURL(b.Value, "link button value")
+		if err != nil {
+			return err
+		}
+		if link == "" {
+			return errors.New("link buttons need a url value")
+		}
+		b.Value = link
+	default:
+		return errors.New("button action must be reply or link")
+	}
+	return nil
+}
+
+func (f *RichFormField) normalize() error {
+	f.Name = strings.TrimSpace(f.Name)
+	f.Label = strings.TrimSpace(f.Label)
+	f.Type = strings.ToLower(strings.TrimSpace(f.Type))
+	if !richFieldNamePattern.MatchString(f.Name) {
+		return errors.New("form field names must start with a letter and use only letters, digits and underscores")
+	}
+	if f.Label == "" {
+		f.Label = f.Name
+	}
+	if utf8.RuneCountInString(f.Label) > maxRichLabelChars {
+		return fmt.Errorf("form field labels must be at most %d characters", maxRichLabelChars)
+	}
+	if f.Type == "" {
+		f.Type = "text"
+	}
+	if !richFieldTypes[f.Type] {
+		return errors.New("form field type must be text, email, phone, textarea or select")
+	}
+	if f.Type != "select" {
+		f.Options = nil
+		return nil
+	}
+	options := make([]string, 0, len(f.Options))
+	for _, o := range f.Options {
+		if o = strings.TrimSpace(o); o != "" {
+			options = append(options, truncateRunes(o, maxRichLabelChars))
+		}
+	}
+	if len(options) == 0 || len(options) > maxRichFieldOptions {
+		return fmt.Errorf("select fields need 1-%d options", maxRichFieldOptions)
+	}
+	f.Options = options
+	return nil
+}
+
+// richURL checks an optional absolute http(s) URL.
+func richURL(raw, field string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(raw) > maxRichURLChars {
+		return "", fmt.Errorf("%s must be an http(s) URL", field)
+	}
+	return raw, nil
+}
+
+// parseRichMessage decodes and normalizes a stored or submitted message. It
+// returns nil for an empty value.
+func parseRichMessage(raw []byte) (*RichMessage, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var m RichMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, errors.New("rich message must be an object")
+	}
+	if err := m.normalize(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// withText returns a copy of the message shown under text.
+func (m *RichMessage) withText(text string) *RichMessage {
+	if m == nil {
+		return nil
+	}
+	out := *m
+	out.Text = text
+	return &out
+}
+
+// plainText renders the message for channels that only show text: the reply
+// text followed by the cards, buttons or form fields as lines.
+func (m *RichMessage) plainText() string {
+	if m == nil {
+		return ""
+	}
+	var lines []string
+	if text := strings.TrimSpace(m.Text); text != "" {
+		lines = append(lines, text, "")
+	}
+	button := func(b RichButton) string {
+		if b.Action == "link" {
+			return "- " + b.Label + ": " + b.Value
+		}
+		return "- " + b.Label
+	}
+	switch m.Type {
+	case richMessageCard, richMessageCarousel:
+		for i, card := range m.Cards {
+			if i > 0 {
+				lines = append(lines, "")
+			}
+			lines = append(lines, card.Title)
+			if card.Subtitle != "" {
+				lines = append(lines, card.Subtitle)
+			}
+			if card.URL != "" {
+				lines = append(lines, card.URL)
+			}
+			for _, b := range card.Buttons {
+				lines = append(lines, button(b))
+			}
+		}
+	case richMessageButtons:
+		for _, b := range m.Buttons {
+			lines = append(lines, button(b))
+		}
+	case richMessageForm:
+		for _, f := range m.Fields {
+			line := f.Label
+			if len(f.Options) > 0 {
+				line += " (" + strings.Join(f.Options, " / ") + ")"
+			}
+			if f.Required {
+				line += " *"
+			}
+			lines = append(lines, line+":")
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// messageContent is what chat_messages.content stores for an answer: the
+// answer itself, or the plain-text fallback of its rich message.
+func messageContent(answer string, rich *RichMessage) string {
+	if rich == nil {
+		return answer
+	}
+	return rich.plainText()
+}
@@ -0,0 +1,391 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"konvoq-backend/utils"
+)
+
+const (
+	// scrapeQueuePoll is how often an idle worker looks for queued jobs; new
+	// jobs from this instance wake it right away.
+	scrapeQueuePoll = 5 * time.Second
+	// scrapeHeartbeatInterval is how often a running job records that its
+	// worker is alive and checks for a cancel request.
+	scrapeHeartbeatInterval = 15 * time.Second
+	// scrapeStaleAfter is how long a running job can go without a heartbeat
+	// before it is queued again for another worker.
+	scrapeStaleAfter = 2 * time.Minute
+	// scrapeRecoverInterval is how often stale jobs are looked for.
+	scrapeRecoverInterval = time.Minute
+	// scrapeJobMaxAttempts bounds how often an interrupted job is resumed.
+	scrapeJobMaxAttempts = 3
+	// scrapeProgressEvery is how many crawled pages pass between progress
+	// updates.
+	scrapeProgressEvery = 10
+)
+
+// scrapeJob is a claimed scrape_jobs row.
+type scrapeJob struct {
+	ID          string
+	UserID      string
+	SourceURL   string
+	TriggeredBy string
+	MaxPages    int
+}
+
+// newScrapeWorkerID names this process in scrape_jobs.locked_by.
+func newScrapeWorkerID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", coalesce(host, "worker"), os.Getpid(), hex.EncodeToString(b))
+}
+
+// wakeScrapeQueue tells this instance's dispatcher a job may be waiting.
+func (c *Controller) wakeScrapeQueue() {
+	select {
+	case c.scrapeWake <- struct{}{}:
+	default:
+	}
+}
+
+// runScrapeQueue dispatches queued scrape jobs until ctx is done, running up
+// to cap(scrapeSem) at once. Stale jobs are recovered first, so a restart
+// resumes the jobs a previous process left running.
+func (c *Controller) runScrapeQueue(ctx context.Context) {
+	c.recoverStaleScrapeJobs(ctx)
+	poll := time.NewTicker(scrapeQueuePoll)
+	recoverTicker := time.NewTicker(scrapeRecoverInterval)
+	defer poll.Stop()
+	defer recoverTicker.Stop()
+	for {
+		c.dispatchScrapeJobs(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-c.scrapeWake:
+		case <-recoverTicker.C:
+			c.recoverStaleScrapeJobs(ctx)
+		}
+	}
+}
+
+func (c *Controller) dispatchScrapeJobs(ctx context.Context) {
+	for ctx.Err() == nil {
+		select {
+		case c.scrapeSem <- struct{}{}:
+		default:
+			return
+		}
+		job, err := c.claimScrapeJob(ctx)
+		if err != nil || job == nil {
+			<-c.scrapeSem
+			if err != nil && ctx.Err() == nil {
+				c.logger.Warn("scrape job claim failed", "error", err)
+			}
+			return
+		}
+		go func() {
+			defer func() {
+				<-c.scrapeSem
+				c.wakeScrapeQueue()
+			}()
+			c.runClaimedScrapeJob(ctx, job)
+		}()
+	}
+}
+
+// claimScrapeJob locks the oldest queued job for this worker, or returns nil
+// when none is waiting.
+func (c *Controller) claimScrapeJob(ctx context.Context) (*scrapeJob, error) {
+	var job scrapeJob
+	err := c.db.QueryRowContext(ctx, `UPDATE scrape_jobs
+		SET status='scraping',locked_by=$1,heartbeat_at=CURRENT_TIMESTAMP,attempts=attempts+1,
+		    started_at=COALESCE(started_at,CURRENT_TIMESTAMP),updated_at=CURRENT_TIMESTAMP
+		WHERE id=(SELECT id FROM scrape_jobs
+			WHERE status='queued' AND cancel_requested=FALSE
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id,user_id,source_url,triggered_by,max_pages`, c.workerID).Scan(
+		&job.ID, &job.UserID, &job.SourceURL, &job.TriggeredBy, &job.MaxPages)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// runClaimedScrapeJob runs a job under a heartbeat. A cancel request stops the
+// crawl and marks the job cancelled; a shutdown or lost lock puts it back in
+// the queue with its checkpoints, so the next worker resumes it.
+func (c *Controller) runClaimedScrapeJob(parent context.Context, job *scrapeJob) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	var cancelRequested atomic.Bool
+	stopHeartbeat := make(chan struct{})
+	defer close(stopHeartbeat)
+	go func() {
+		ticker := time.NewTicker(scrapeHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopHeartbeat:
+				return
+			case <-ticker.C:
+			}
+			var requested bool
+			err := c.db.QueryRow(`UPDATE scrape_jobs SET heartbeat_at=CURRENT_TIMESTAMP
+				WHERE id=$1 AND locked_by=$2 RETURNING cancel_requested`, job.ID, c.workerID).Scan(&requested)
+			switch {
+			case err == sql.ErrNoRows:
+				c.logger.Warn("scrape job lock lost", "job_id", job.ID)
+				cancel()
+				return
+			case err != nil:
+				c.logger.Warn("scrape job heartbeat failed", "job_id", job.ID, "error", err)
+			case requested:
+				cancelRequested.Store(true)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	if job.TriggeredBy == "scheduled" {
+		c.runSourceRefresh(ctx, job)
+	} else {
+		c.runScrapeJob(ctx, job)
+	}
+
+	switch {
+	case cancelRequested.Load():
+		c.finishScrapeJob(job.ID, "cancelled", "Cancelled", "")
+	case ctx.Err() != nil && c.requeueScrapeJob(job.ID):
+		return
+	}
+	// After a lost lock the job may be running elsewhere; its checkpoints
+	// are only dropped once it has ended.
+	c.clearScrapeCheckpoints(job.ID)
+}
+
+// finishScrapeJob ends a job this worker is still running, e.g. after a
+// cancel; jobs that already reached done or failed, or that another worker
+// took over, are left alone.
+func (c *Controller) finishScrapeJob(jobID, status, message, errMsg string) {
+	if _, err := c.db.Exec(`UPDATE scrape_jobs
+		SET status=$2,progress=100,message=$3,error_message=$4,locked_by=NULL,
+		    completed_at=CURRENT_TIMESTAMP,updated_at=CURRENT_TIMESTAMP
+		WHERE id=$1 AND locked_by=$5 AND status IN ('queued','scraping','indexing')`,
+		jobID, status, utils.Nullable(message), utils.Nullable(errMsg), c.workerID); err != nil {
+		c.logger.Warn("scrape job finish failed", "job_id", jobID, "status", status, "error", err)
+	}
+}
+
+// requeueScrapeJob puts an interrupted job back in the queue. It reports
+// false when the job had already finished or belongs to another worker.
+func (c *Controller) requeueScrapeJob(jobID string) bool {
+	res, err := c.db.Exec(`UPDATE scrape_jobs
+		SET status='queued',message='Waiting to resume',locked_by=NULL,heartbeat_at=NULL,updated_at=CURRENT_TIMESTAMP
+		WHERE id=$1 AND locked_by=$2 AND status IN ('scraping','indexing')`, jobID, c.workerID)
+	if err != nil {
+		// The stale job recovery queues it once the heartbeat lapses.
+		c.logger.Warn("scrape job requeue failed", "job_id", jobID, "error", err)
+		return true
+	}
+	n, _ := res.RowsAffected()
+	return n > 0
+}
+
+// recoverStaleScrapeJobs queues running jobs whose worker stopped sending
+// heartbeats, and fails those that were already resumed too often.
+func (c *Controller) recoverStaleScrapeJobs(ctx context.Context) {
+	staleSeconds := int64(scrapeStaleAfter / time.Second)
+	res, err := c.db.ExecContext(ctx, `UPDATE scrape_jobs
+		SET status='failed',progress=100,message='Scraping failed',error_message='interrupted too many times',
+		    locked_by=NULL,completed_at=CURRENT_TIMESTAMP,updated_at=CURRENT_TIMESTAMP
+		WHERE status IN ('scraping','indexing') AND attempts >= $1
+		  AND COALESCE(heartbeat_at,updated_at) < CURRENT_TIMESTAMP - ($2 * INTERVAL '1 second')`,
+		scrapeJobMaxAttempts, staleSeconds)
+	if err != nil {
+		c.logger.Warn("stale scrape job cleanup failed", "error", err)
+		return
+	}
+	failed, _ := res.RowsAffected()
+	res, err = c.db.ExecContext(ctx, `UPDATE scrape_jobs
+		SET status=CASE WHEN cancel_requested THEN 'cancelled' ELSE 'queued' END,
+		    message=CASE WHEN cancel_requested THEN 'Cancelled' ELSE 'Waiting to resume' END,
+		    completed_at=CASE WHEN cancel_requested THEN CURRENT_TIMESTAMP ELSE completed_at END,
+		    locked_by=NULL,heartbeat_at=NULL,updated_at=CURRENT_TIMESTAMP
+		WHERE status IN ('scraping','indexing')
+		  AND COALESCE(heartbeat_at,updated_at) < CURRENT_TIMESTAMP - ($1 * INTERVAL '1 second')`, staleSeconds)
+	if err != nil {
+		c.logger.Warn("stale scrape job recovery failed", "error", err)
+		return
+	}
+	if requeued, _ := res.RowsAffected(); requeued > 0 || failed > 0 {
+		c.logger.Info("recovered stale scrape jobs", "requeued", requeued, "failed", failed)
+		c.wakeScrapeQueue()
+	}
+}
+
+// crawlCheckpoint is one crawled URL of a job, saved as it is crawled so an
+// interrupted job can resume without fetching it again.
+type crawlCheckpoint struct {
+	Page  scrapedPage
+	Depth int
+	// Kept is false for a start page outside the include patterns, which is
+	// only crawled for its links.
+	Kept  bool
+	Gone  bool
+	Links []string
+}
+
+// crawlForJob crawls a job's source, resuming from its checkpoints and saving
+// new ones as it goes.
+func (c *Controller) crawlForJob(ctx context.Context, job *scrapeJob, known map[string]crawledPage) (crawlResult, error) {
+	resume, err := c.loadScrapeCheckpoints(job.ID)
+	if err != nil {
+		c.logger.Warn("scrape checkpoint load failed", "job_id", job.ID, "error", err)
+		resume = nil
+	}
+	crawled := countKept(resume)
+	return c.crawlWebsite(ctx, crawlSpec{
+		StartURL: job.SourceURL,
+		MaxPages: job.MaxPages,
+		Rules:    c.sourceCrawlRules(job.UserID, job.SourceURL),
+		Known:    known,
+		Resume:   resume,
+		Checkpoint: func(cp crawlCheckpoint) {
+			c.saveScrapeCheckpoint(job.ID, cp)
+			if cp.Kept && !cp.Gone {
+				crawled++
+				if crawled%scrapeProgressEvery == 0 {
+					c.updateScrapeJob(job.ID, "scraping", 20+30*crawled/max(job.MaxPages, 1),
+						fmt.Sprintf("Crawled %d of up to %d pages", crawled, job.MaxPages), "")
+				}
+			}
+		},
+	})
+}
+
+func countKept(checkpoints []crawlCheckpoint) int {
+	n := 0
+	for _, cp := range checkpoints {
+		if cp.Kept && !cp.Gone {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Controller) saveScrapeCheckpoint(jobID string, cp crawlCheckpoint) {
+	links, _ := json.Marshal(cp.Links)
+	p := cp.Page
+	if _, err := c.db.Exec(`INSERT INTO scrape_job_pages
+		(job_id,url,depth,kept,gone,not_modified,title,description,canonical_url,language,content,content_hash,etag,last_modified,links)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15::jsonb)
+		ON CONFLICT (job_id,url) DO NOTHING`,
+		jobID, p.URL, cp.Depth, cp.Kept, cp.Gone, p.NotModified, p.Title, p.Description, p.CanonicalURL, p.Language,
+		p.Text, p.ContentHash, utils.Nullable(p.ETag), utils.Nullable(p.LastModified), string(links)); err != nil {
+		c.logger.Warn("scrape checkpoint save failed", "job_id", jobID, "url", p.URL, "error", err)
+	}
+}
+
+func (c *Controller) loadScrapeCheckpoints(jobID string) ([]crawlCheckpoint, error) {
+	rows, err := c.db.Query(`SELECT url,depth,kept,gone,not_modified,title,description,canonical_url,language,content,content_hash,
+			COALESCE(etag,''),COALESCE(last_modified,''),links
+		FROM scrape_job_pages WHERE job_id=$1 ORDER BY created_at`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []crawlCheckpoint
+	for rows.Next() {
+		var cp crawlCheckpoint
+		var links []byte
+		p := &cp.Page
+		if err := rows.Scan(&p.URL, &cp.Depth, &cp.Kept, &cp.Gone, &p.NotModified, &p.Title, &p.Description, &p.CanonicalURL,
+			&p.Language, &p.Text, &p.ContentHash, &p.ETag, &p.LastModified, &links); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(links, &cp.Links)
+		out = append(out, cp)
+	}
+	return out, rows.Err()
+}
+
+// clearScrapeCheckpoints drops the checkpoints of a job that has ended. A
+// job still queued or running keeps them for the worker resuming it.
+func (c *Controller) clearScrapeCheckpoints(jobID string) {
+	if _, err := c.db.Exec(`DELETE FROM scrape_job_pages WHERE job_id=$1
+		AND EXISTS (SELECT 1 FROM scrape_jobs WHERE id=$1 AND status IN ('done','failed','cancelled'))`, jobID); err != nil {
+		c.logger.Warn("scrape checkpoint cleanup failed", "job_id", jobID, "error", err)
+	}
+}
+
+// CancelScrapeJob stops a queued or running scrape job. A queued job is
+// cancelled at once; a running one stops at its next heartbeat, unless its
+// crawl has already finished and indexing is under way.
+func (c *Controller) CancelScrapeJob(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	if err := c.RequireCSRF(r); err != nil {
+		utils.JSONErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+	jobID := strings.TrimSpace(chi.URLParam(r, "id"))
+	if jobID == "" {
+		utils.JSONErr(w, http.StatusBadRequest, "job id is required")
+		return
+	}
+	var status string
+	err := c.db.QueryRowContext(r.Context(), `UPDATE scrape_jobs
+		SET cancel_requested=TRUE,
+		    status=CASE WHEN status='queued' THEN 'cancelled' ELSE status END,
+		    progress=CASE WHEN status='queued' THEN 100 ELSE progress END,
+		    message=CASE WHEN status='queued' THEN 'Cancelled' ELSE 'Cancelling' END,
+		    completed_at=CASE WHEN status='queued' THEN CURRENT_TIMESTAMP ELSE completed_at END,
+		    updated_at=CURRENT_TIMESTAMP
+		WHERE id=$1 AND user_id=$2 AND status IN ('queued','scraping','indexing')
+		RETURNING status`, jobID, claims.UserID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		var current string
+		if err := c.db.QueryRowContext(r.Context(), `SELECT status FROM scrape_jobs WHERE id=$1 AND user_id=$2`,
+			jobID, claims.UserID).Scan(&current); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				utils.JSONErr(w, http.StatusNotFound, "scrape job not found")
+				return
+			}
+			c.logRequestError(r, "cancel scrape job lookup failed", err, "user_id", claims.UserID, "job_id", jobID)
+			utils.JSONErr(w, http.StatusInternalServerError, "db error")
+			return
+		}
+		utils.JSONErr(w, http.StatusConflict, "scrape job already "+current)
+		return
+	}
+	if err != nil {
+		c.logRequestError(r, "cancel scrape job failed", err, "user_id", claims.UserID, "job_id", jobID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	if status == "cancelled" {
+		c.clearScrapeCheckpoints(jobID)
+	}
+	utils.JSONOK(w, map[string]interface{}{"success": true, "jobId": jobID, "status": status})
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
-	"io"
 	"net/http"
 	"net/url"
 	"path"
@@ -17,30 +17,76 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"konvoq-backend/platform/chunker"
 	"konvoq-backend/utils"
 )
 
-var (
-	htmlTitleRegex   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
-	htmlStripRegex   = regexp.MustCompile(`(?s)<[^>]+>`)
-	scriptStyleRegex = regexp.MustCompile(`(?is)<script[^>]*>.*?</script>|<style[^>]*>.*?</style>`)
-	hrefAttrRegex    = regexp.MustCompile(`(?is)href\s*=\s*(?:"([^"]+)"|'([^']+)'|([^\s"'<>]+))`)
-)
+var hrefAttrRegex = regexp.MustCompile(`(?is)href\s*=\s*(?:"([^"]+)"|'([^']+)'|([^\s"'<>]+))`)
 
 type scrapedPage struct {
 	URL   string
 	Title string
 	Text  string
+	// Description, CanonicalURL and Language come from the page's head and
+	// are stored with each chunk.
+	Description  string
+	CanonicalURL string
+	Language     string
+	// ContentHash identifies the page's extracted content between crawls.
+	ContentHash  string
+	ETag         string
+	LastModified string
+	// NotModified is set when a conditional request came back 304; Text is
+	// empty and the other fields are carried over from the previous crawl.
+	NotModified bool
+}
+
+// crawlSpec is one crawl of a source. Known holds the pages of the previous
+// crawl, if any. Resume holds the checkpoints of an interrupted run of the
+// same job, and Checkpoint, when set, receives every URL as it is crawled.
+type crawlSpec struct {
+	StartURL   string
+	MaxPages   int
+	Rules      CrawlRules
+	Known      map[string]crawledPage
+	Resume     []crawlCheckpoint
+	Checkpoint func(crawlCheckpoint)
+}
+
+// crawlResult is one crawl of a source. Gone lists previously crawled pages
+// that now return 404 or 410 or are disallowed by robots.txt.
+type crawlResult struct {
+	Pages []scrapedPage
+	Gone  []string
 }
 
 func (c *Controller) Scrape(w http.ResponseWriter, r *http.Request, claims TokenClaims, user UserRecord) {
 	var body struct {
-		URL string `json:"url"`
+		URL        string           `json:"url"`
+		Chunking   *chunker.Options `json:"chunking"`
+		CrawlRules *CrawlRules      `json:"crawlRules"`
 	}
 	if err := utils.DecodeJSON(r, &body); err != nil || strings.TrimSpace(body.URL) == "" {
 		utils.JSONErr(w, http.StatusBadRequest, "url is required")
 		return
 	}
+	var chunkMax, chunkOverlap interface{}
+	if body.Chunking != nil {
+		if err := body.Chunking.Validate(); err != nil {
+			utils.JSONErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		chunkMax, chunkOverlap = nullableChunkSetting(body.Chunking.MaxTokens), nullableChunkOverlap(body.Chunking.OverlapTokens)
+	}
+	var crawlRules interface{}
+	if body.CrawlRules != nil {
+		if err := body.CrawlRules.normalize(); err != nil {
+			utils.JSONErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		b, _ := json.Marshal(body.CrawlRules)
+		crawlRules = string(b)
+	}
 	sourceURL, err := normalizeScrapeURL(strings.TrimSpace(body.URL))
 	if err != nil {
 		utils.JSONErr(w, http.StatusBadRequest, "invalid url")
@@ -88,9 +134,16 @@ func (c *Controller) Scrape(w http.ResponseWriter, r *http.Request, claims Token
 		return
 	}
 
-	_, err = c.db.Exec(`INSERT INTO scraper_sources (user_id,source_url,source_title,scraped_pages) VALUES ($1,$2,$2,$3)
-		ON CONFLICT (user_id,source_url) DO UPDATE SET source_title=EXCLUDED.source_title,updated_at=CURRENT_TIMESTAMP`,
-		claims.UserID, sourceURL, currentSourcePages)
+	// Chunk settings and crawl rules are only replaced when the request
+	// carries them, so a plain re-scrape keeps the source's existing settings.
+	_, err = c.db.Exec(`INSERT INTO scraper_sources (user_id,source_url,source_title,scraped_pages,chunk_max_tokens,chunk_overlap_tokens,crawl_rules)
+		VALUES ($1,$2,$2,$3,$4,$5,$7::jsonb)
+		ON CONFLICT (user_id,source_url) DO UPDATE SET source_title=EXCLUDED.source_title,
+			chunk_max_tokens=CASE WHEN $6 THEN EXCLUDED.chunk_max_tokens ELSE scraper_sources.chunk_max_tokens END,
+			chunk_overlap_tokens=CASE WHEN $6 THEN EXCLUDED.chunk_overlap_tokens ELSE scraper_sources.chunk_overlap_tokens END,
+			crawl_rules=CASE WHEN $8 THEN EXCLUDED.crawl_rules ELSE scraper_sources.crawl_rules END,
+			updated_at=CURRENT_TIMESTAMP`,
+		claims.UserID, sourceURL, currentSourcePages, chunkMax, chunkOverlap, body.Chunking != nil, crawlRules, body.CrawlRules != nil)
 	if err != nil {
 		c.logRequestError(r, "scrape source upsert failed", err, "user_id", claims.UserID, "url", sourceURL)
 		utils.JSONErr(w, http.StatusInternalServerError, "db error")
@@ -98,19 +151,14 @@ func (c *Controller) Scrape(w http.ResponseWriter, r *http.Request, claims Token
 	}
 
 	var jobID string
-	if err := c.db.QueryRow(`INSERT INTO scrape_jobs (user_id,source_url,status,progress,message,started_at)
-		VALUES ($1,$2,'queued',5,'Queued for scraping',CURRENT_TIMESTAMP) RETURNING id`,
-		claims.UserID, sourceURL).Scan(&jobID); err != nil {
+	if err := c.db.QueryRow(`INSERT INTO scrape_jobs (user_id,source_url,status,progress,message,max_pages)
+		VALUES ($1,$2,'queued',5,'Queued for scraping',$3) RETURNING id`,
+		claims.UserID, sourceURL, remainingPages).Scan(&jobID); err != nil {
 		c.logRequestError(r, "scrape job insert failed", err, "user_id", claims.UserID, "url", sourceURL)
 		utils.JSONErr(w, http.StatusInternalServerError, "db error")
 		return
 	}
-
-	go func() {
-		c.scrapeSem <- struct{}{}
-		defer func() { <-c.scrapeSem }()
-		c.runScrapeJob(claims.UserID, sourceURL, jobID, remainingPages)
-	}()
+	c.wakeScrapeQueue()
 
 	utils.JSONOK(w, map[string]interface{}{
 		"success":  true,
@@ -126,19 +174,26 @@ func (c *Controller) Scrape(w http.ResponseWriter, r *http.Request, claims Token
 	})
 }
 
-func (c *Controller) runScrapeJob(userID, sourceURL, jobID string, maxPages int) {
+// runScrapeJob crawls a source in full and replaces its index. A crawl stopped
+// by ctx leaves the job to runClaimedScrapeJob, before anything is indexed.
+func (c *Controller) runScrapeJob(ctx context.Context, job *scrapeJob) {
+	userID, sourceURL, jobID, maxPages := job.UserID, job.SourceURL, job.ID, job.MaxPages
 	if maxPages <= 0 {
 		c.updateScrapeJob(jobID, "failed", 100, "Scraping failed", "scraped page limit reached")
 		return
 	}
 
 	c.updateScrapeJob(jobID, "scraping", 20, fmt.Sprintf("Crawling up to %d pages", maxPages), "")
-	pages, err := c.crawlWebsite(sourceURL, maxPages)
+	result, err := c.crawlForJob(ctx, job, nil)
+	if ctx.Err() != nil {
+		return
+	}
 	if err != nil {
 		c.logger.Warn("scrape extraction failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
 		c.updateScrapeJob(jobID, "failed", 100, "Scraping failed", err.Error())
 		return
 	}
+	pages := result.Pages
 	if len(pages) == 0 {
 		errMsg := "no text content found at url"
 		c.logger.Warn("scrape extraction produced empty content", "user_id", userID, "url", sourceURL, "job_id", jobID)
@@ -147,24 +202,24 @@ func (c *Controller) runScrapeJob(userID, sourceURL, jobID string, maxPages int)
 	}
 
 	c.updateScrapeJob(jobID, "scraping", 55, "Chunking scraped pages", "")
-	chunks := c.buildRAGChunks(userID, sourceURL, pages)
+	chunks := c.buildRAGChunks(userID, sourceURL, pages, c.sourceChunkOptions(userID, sourceURL))
 	if len(chunks) == 0 {
 		c.updateScrapeJob(jobID, "failed", 100, "Scraping failed", "no chunks generated from scraped pages")
 		return
 	}
 
 	c.updateScrapeJob(jobID, "indexing", 70, "Refreshing vectors for source", "")
-	if err := c.pineconeDeleteBySource(userID, "website", sourceURL); err != nil {
+	if err := c.vectorDeleteBySource(userID, "website", sourceURL); err != nil {
 		c.logger.Warn("scrape source vector cleanup failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
 		c.updateScrapeJob(jobID, "failed", 100, "Indexing failed", err.Error())
 		return
 	}
-	if err := c.pineconeDeleteByURL(userID, sourceURL); err != nil {
+	if err := c.vectorDeleteByURL(userID, sourceURL); err != nil {
 		c.logger.Warn("scrape legacy root-url vector cleanup failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
 	}
 
 	c.updateScrapeJob(jobID, "indexing", 85, "Indexing content", "")
-	if err := c.pineconeUpsertChunks(userID, chunks); err != nil {
+	if err := c.vectorUpsertChunks(userID, chunks); err != nil {
 		c.logger.Warn("scrape index upsert failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
 		c.updateScrapeJob(jobID, "failed", 100, "Indexing failed", err.Error())
 		return
@@ -178,95 +233,186 @@ func (c *Controller) runScrapeJob(userID, sourceURL, jobID string, maxPages int)
 		userID, sourceURL, title, len(pages)); err != nil {
 		c.logger.Warn("scrape source page update failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
 	}
+	if err := c.saveCrawledPages(userID, sourceURL, pages, nil, true); err != nil {
+		c.logger.Warn("scrape source crawled pages save failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
+	}
+	c.scheduleSourceRefresh(userID, sourceURL, true)
 
 	c.updateScrapeJob(jobID, "done", 100, "Scraping complete", "")
 }
 
-func (c *Controller) crawlWebsite(startURL string, maxPages int) ([]scrapedPage, error) {
-	start, err := normalizeScrapeURL(startURL)
+// crawlWebsite crawls the start URL's host within robots.txt and the source's
+// crawl rules. The site's sitemap URLs, newest first, are queued right after
+// the start page, and links found on fetched pages follow them, so orphan
+// pages are reached and the most recently changed pages make it in under the
+// plan's page limit. Sitemap URLs count as one hop from the start page. The
+// start page is always fetched for its links but only kept when it matches
+// the include and exclude patterns.
+//
+// Known pages are queued after the sitemap and fetched conditionally, so a
+// page that answers 304 is still counted without being downloaded again.
+// Known pages now outside the rules are reported as gone. The crawl stops
+// with ctx's error when ctx is done.
+func (c *Controller) crawlWebsite(ctx context.Context, spec crawlSpec) (crawlResult, error) {
+	var result crawlResult
+	maxPages, known := spec.MaxPages, spec.Known
+	start, err := normalizeScrapeURL(spec.StartURL)
 	if err != nil {
-		return nil, err
+		return result, err
 	}
 	base, err := url.Parse(start)
 	if err != nil {
-		return nil, fmt.Errorf("invalid url")
+		return result, fmt.Errorf("invalid url")
 	}
-	baseHost := strings.ToLower(base.Hostname())
+	scope := newCrawlScope(base.Hostname(), spec.Rules)
+	origin := &url.URL{Scheme: base.Scheme, Host: base.Host}
 
-	queue := []string{start}
+	fetcher, err := c.newCrawlFetcher(ctx, origin)
+	if err != nil {
+		return result, err
+	}
+	if !fetcher.allowed(ctx, start) {
+		return result, fmt.Errorf("url is disallowed by robots.txt")
+	}
+
+	type queued struct {
+		url   string
+		depth int
+	}
+	queue := []queued{{url: start}}
+	for _, seed := range fetcher.sitemapSeeds(ctx, origin, scope, maxPages) {
+		queue = append(queue, queued{url: seed, depth: 1})
+	}
+	knownURLs := make([]string, 0, len(known))
+	for u := range known {
+		knownURLs = append(knownURLs, u)
+	}
+	sort.Strings(knownURLs)
+	for _, u := range knownURLs {
+		queue = append(queue, queued{url: u, depth: 1})
+	}
 	visited := make(map[string]struct{})
 	pages := make([]scrapedPage, 0, maxPages)
+	for _, cp := range spec.Resume {
+		visited[cp.Page.URL] = struct{}{}
+		switch {
+		case cp.Gone:
+			result.Gone = append(result.Gone, cp.Page.URL)
+		case cp.Kept:
+			pages = append(pages, cp.Page)
+		}
+		for _, link := range cp.Links {
+			queue = append(queue, queued{url: link, depth: cp.Depth + 1})
+		}
+	}
+	checkpoint := func(cp crawlCheckpoint) {
+		if spec.Checkpoint != nil {
+			spec.Checkpoint(cp)
+		}
+	}
 
 	for len(queue) > 0 && len(pages) < maxPages {
-		current := queue[0]
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if fetcher.expired() {
+			c.logger.Warn("scrape crawl stopped at time limit", "url", start, "pages", len(pages))
+			break
+		}
+		item := queue[0]
 		queue = queue[1:]
+		current := item.url
 		if _, seen := visited[current]; seen {
 			continue
 		}
 		visited[current] = struct{}{}
+		prev, isKnown := known[current]
+		keep := scope.allowsURL(current)
+		if (!keep && current != start) || !fetcher.allowed(ctx, current) {
+			if isKnown {
+				result.Gone = append(result.Gone, current)
+				checkpoint(crawlCheckpoint{Page: scrapedPage{URL: current}, Depth: item.depth, Gone: true})
+			}
+			continue
+		}
 		if _, err := c.validateScrapeTarget(current); err != nil {
 			c.logger.Warn("scrape crawl skipped blocked target", "url", current, "error", err)
 			continue
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		req, reqErr := http.NewRequestWithContext(ctx, http.MethodGet, current, nil)
-		if reqErr != nil {
-			cancel()
-			continue
+		var conditional *crawledPage
+		if isKnown && keep {
+			conditional = &prev
 		}
-		req.Header.Set("User-Agent", "KonvoqCrawler/1.0")
-		resp, reqErr := http.DefaultClient.Do(req)
+		resp, reqErr := fetcher.get(ctx, current, 2<<20, conditional)
 		if reqErr != nil {
-			cancel()
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
 			c.logger.Warn("scrape crawl request failed", "url", current, "error", reqErr)
 			continue
 		}
-
-		contentType := strings.ToLower(resp.Header.Get("Content-Type"))
-		body, readErr := io.ReadAll(io.LimitReader(resp.Body, 2<<20))
-		_ = resp.Body.Close()
-		cancel()
-		if readErr != nil {
-			c.logger.Warn("scrape crawl read failed", "url", current, "error", readErr)
+		if resp.Status == http.StatusNotModified && conditional != nil {
+			page := scrapedPage{
+				URL:          current,
+				Title:        prev.Title,
+				ContentHash:  prev.ContentHash,
+				ETag:         coalesce(resp.ETag, prev.ETag),
+				LastModified: coalesce(resp.LastModified, prev.LastModified),
+				NotModified:  true,
+			}
+			pages = append(pages, page)
+			checkpoint(crawlCheckpoint{Page: page, Depth: item.depth, Kept: true})
 			continue
 		}
-		if resp.StatusCode >= 300 {
-			c.logger.Warn("scrape crawl non-success status", "url", current, "status_code", resp.StatusCode)
+		if (resp.Status == http.StatusNotFound || resp.Status == http.StatusGone) && isKnown {
+			result.Gone = append(result.Gone, current)
+			checkpoint(crawlCheckpoint{Page: scrapedPage{URL: current}, Depth: item.depth, Gone: true})
 			continue
 		}
-
-		raw := string(body)
-		text := normalizeWhitespace(raw)
-		title := ""
-		links := []string{}
-		if strings.Contains(contentType, "text/html") || strings.Contains(strings.ToLower(raw), "<html") {
-			text = stripHTML(raw)
-			title = extractHTMLTitle(raw)
-			links = extractInternalLinks(baseHost, current, raw)
-		}
-		if strings.TrimSpace(text) == "" {
+		if resp.Status >= 300 {
+			c.logger.Warn("scrape crawl non-success status", "url", current, "status_code", resp.Status)
 			continue
 		}
 
-		pages = append(pages, scrapedPage{
-			URL:   current,
-			Title: title,
-			Text:  text,
-		})
-
+		raw := string(resp.Body)
+		page := scrapedPage{URL: current, Text: strings.TrimSpace(raw), ETag: resp.ETag, LastModified: resp.LastModified}
+		links := []string{}
+		if strings.Contains(resp.ContentType, "text/html") || strings.Contains(strings.ToLower(raw), "<html") {
+			extracted := chunker.ExtractPage(raw)
+			page.Text = extracted.Markdown
+			page.Title = extracted.Title
+			page.Description = extracted.Description
+			page.CanonicalURL = resolveCanonicalURL(current, extracted.CanonicalURL)
+			page.Language = extracted.Language
+			if scope.followsFrom(item.depth) {
+				links = extractInternalLinks(scope, current, raw)
+			}
+		}
 		for _, link := range links {
 			if _, seen := visited[link]; !seen {
-				queue = append(queue, link)
+				queue = append(queue, queued{url: link, depth: item.depth + 1})
 			}
 		}
+		if !keep || strings.TrimSpace(page.Text) == "" {
+			gone := !keep && isKnown
+			if gone {
+				result.Gone = append(result.Gone, current)
+			}
+			checkpoint(crawlCheckpoint{Page: scrapedPage{URL: current}, Depth: item.depth, Gone: gone, Links: links})
+			continue
+		}
+		page.ContentHash = pageContentHash(page.Title, page.Text)
+		pages = append(pages, page)
+		checkpoint(crawlCheckpoint{Page: page, Depth: item.depth, Kept: true, Links: links})
 	}
 
 	if len(pages) == 0 {
-		return nil, fmt.Errorf("no crawlable pages found")
+		return result, fmt.Errorf("no crawlable pages found")
 	}
 
-	return pages, nil
+	result.Pages = pages
+	return result, nil
 }
 
 func normalizeScrapeURL(raw string) (string, error) {
@@ -290,25 +436,35 @@ func normalizeScrapeURL(raw string) (string, error) {
 	return u.String(), nil
 }
 
-func extractHTMLTitle(raw string) string {
-	match := htmlTitleRegex.FindStringSubmatch(raw)
-	if len(match) < 2 {
+// resolveCanonicalURL resolves a page's rel=canonical href against the page
+// URL, dropping anything that is not http(s).
+func resolveCanonicalURL(pageURL, href string) string {
+	if strings.TrimSpace(href) == "" {
 		return ""
 	}
-	return normalizeWhitespace(html.UnescapeString(match[1]))
-}
-
-func stripHTML(raw string) string {
-	withoutScripts := scriptStyleRegex.ReplaceAllString(raw, " ")
-	withoutTags := htmlStripRegex.ReplaceAllString(withoutScripts, " ")
-	return normalizeWhitespace(html.UnescapeString(withoutTags))
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	canonical := base.ResolveReference(ref)
+	if canonical.Scheme != "http" && canonical.Scheme != "https" {
+		return ""
+	}
+	canonical.Fragment = ""
+	return canonical.String()
 }
 
 func normalizeWhitespace(raw string) string {
 	return strings.Join(strings.Fields(strings.TrimSpace(raw)), " ")
 }
 
-func extractInternalLinks(baseHost, currentURL, rawHTML string) []string {
+// extractInternalLinks returns the page's links that are in the crawl scope,
+// normalized and sorted.
+func extractInternalLinks(scope *crawlScope, currentURL, rawHTML string) []string {
 	current, err := url.Parse(currentURL)
 	if err != nil {
 		return nil
@@ -339,15 +495,8 @@ func extractInternalLinks(baseHost, currentURL, rawHTML string) []string {
 		if err != nil {
 			continue
 		}
-		absolute := current.ResolveReference(ref)
-		if !strings.EqualFold(absolute.Hostname(), baseHost) {
-			continue
-		}
-		if shouldSkipCrawlPath(absolute.Path) {
-			continue
-		}
-		normalized, err := normalizeScrapeURL(absolute.String())
-		if err != nil {
+		normalized, err := normalizeScrapeURL(current.ResolveReference(ref).String())
+		if err != nil || !scope.allowsURL(normalized) {
 			continue
 		}
 		unique[normalized] = struct{}{}
@@ -374,56 +523,66 @@ func shouldSkipCrawlPath(rawPath string) bool {
 	}
 }
 
-func (c *Controller) buildRAGChunks(userID, sourceURL string, pages []scrapedPage) []ragChunk {
+// buildRAGChunks splits each page (Markdown rendered from its HTML) along its
+// section structure. Chunk indexes are per page.
+func (c *Controller) buildRAGChunks(userID, sourceURL string, pages []scrapedPage, opts chunker.Options) []ragChunk {
 	widgetKey := c.userWidgetKey(userID)
 	chunks := make([]ragChunk, 0, len(pages)*2)
 	for _, page := range pages {
-		pageChunks := chunkText(page.Text, 500, 75)
-		for i, chunk := range pageChunks {
+		for i, chunk := range chunker.SplitMarkdown(page.Text, opts) {
 			chunks = append(chunks, ragChunk{
-				URL:        page.URL,
-				PageTitle:  page.Title,
-				Text:       chunk,
-				ChunkIndex: i,
-				WidgetKey:  widgetKey,
-				SourceType: "website",
-				SourceKey:  sourceURL,
+				URL:          page.URL,
+				PageTitle:    page.Title,
+				HeadingPath:  strings.Join(chunk.HeadingPath, " > "),
+				Text:         chunk.Text,
+				ChunkIndex:   i,
+				WidgetKey:    widgetKey,
+				SourceType:   "website",
+				SourceKey:    sourceURL,
+				Description:  page.Description,
+				CanonicalURL: page.CanonicalURL,
+				Language:     page.Language,
 			})
 		}
 	}
 	return chunks
 }
 
-func chunkText(raw string, chunkSize int, overlap int) []string {
-	words := strings.Fields(strings.TrimSpace(raw))
-	if len(words) == 0 {
-		return nil
-	}
-	if chunkSize <= 0 {
-		chunkSize = 500
+// sourceChunkOptions returns the chunk settings saved for a scraper source;
+// unset columns fall back to the chunker defaults.
+func (c *Controller) sourceChunkOptions(userID, sourceURL string) chunker.Options {
+	var maxTokens, overlapTokens sql.NullInt64
+	if err := c.db.QueryRow(`SELECT chunk_max_tokens,chunk_overlap_tokens FROM scraper_sources WHERE user_id=$1 AND source_url=$2`,
+		userID, sourceURL).Scan(&maxTokens, &overlapTokens); err != nil && err != sql.ErrNoRows {
+		c.logger.Warn("scrape source chunk settings query failed", "user_id", userID, "url", sourceURL, "error", err)
 	}
-	if overlap < 0 || overlap >= chunkSize {
-		overlap = 75
+	opts := chunker.Options{MaxTokens: int(maxTokens.Int64)}
+	if overlapTokens.Valid {
+		overlap := int(overlapTokens.Int64)
+		opts.OverlapTokens = &overlap
 	}
-	step := chunkSize - overlap
-	if step <= 0 {
-		step = chunkSize
+	return opts
+}
+
+// nullableChunkSetting stores 0 ("use the default") as NULL.
+func nullableChunkSetting(v int) interface{} {
+	if v == 0 {
+		return nil
 	}
+	return v
+}
 
-	out := make([]string, 0, len(words)/step+1)
-	for start := 0; start < len(words); start += step {
-		end := start + chunkSize
-		if end > len(words) {
-			end = len(words)
-		}
-		out = append(out, strings.Join(words[start:end], " "))
-		if end == len(words) {
-			break
-		}
+// nullableChunkOverlap stores an unset overlap as NULL; 0 is kept and turns
+// overlap off.
+func nullableChunkOverlap(v *int) interface{} {
+	if v == nil {
+		return nil
 	}
-	return out
+	return *v
 }
 
+// updateScrapeJob records the progress of a job this worker holds; a worker
+// that lost its lock no longer writes to the job.
 func (c *Controller) updateScrapeJob(jobID, status string, progress int, message, errMsg string) {
 	if progress < 0 {
 		progress = 0
@@ -431,14 +590,14 @@ func (c *Controller) updateScrapeJob(jobID, status string, progress int, message
 	if progress > 100 {
 		progress = 100
 	}
-	completedAt := "NULL"
+	completedAt, lockedBy := "NULL", "locked_by"
 	if status == "done" || status == "failed" {
-		completedAt = "CURRENT_TIMESTAMP"
+		completedAt, lockedBy = "CURRENT_TIMESTAMP", "NULL"
 	}
 	if _, err := c.db.Exec(`UPDATE scrape_jobs
 		SET status=$2,progress=$3,message=$4,error_message=$5,
-		    completed_at=`+completedAt+`,updated_at=CURRENT_TIMESTAMP
-		WHERE id=$1`, jobID, status, progress, utils.Nullable(message), utils.Nullable(errMsg)); err != nil {
+		    completed_at=`+completedAt+`,locked_by=`+lockedBy+`,updated_at=CURRENT_TIMESTAMP
+		WHERE id=$1 AND locked_by=$6`, jobID, status, progress, utils.Nullable(message), utils.Nullable(errMsg), c.workerID); err != nil {
 		c.logger.Warn("scrape job update failed", "job_id", jobID, "status", status, "error", err)
 	}
 }
@@ -450,16 +609,19 @@ func (c *Controller) GetScrapeJob(w http.ResponseWriter, r *http.Request, claims
 		return
 	}
 
-	var sourceURL, status string
-	var progress int
+	var sourceURL, status, triggeredBy string
+	var progress, attempts int
+	var cancelRequested bool
 	var message, errMsg sql.NullString
+	var summary []byte
 	var createdAt, updatedAt time.Time
 	var startedAt, completedAt sql.NullTime
 
-	err := c.db.QueryRow(`SELECT source_url,status,progress,message,error_message,created_at,started_at,completed_at,updated_at
+	err := c.db.QueryRow(`SELECT source_url,status,progress,message,error_message,triggered_by,summary,attempts,cancel_requested,
+			created_at,started_at,completed_at,updated_at
 		FROM scrape_jobs WHERE id=$1 AND user_id=$2`,
 		jobID, claims.UserID).Scan(
-		&sourceURL, &status, &progress, &message, &errMsg,
+		&sourceURL, &status, &progress, &message, &errMsg, &triggeredBy, &summary, &attempts, &cancelRequested,
 		&createdAt, &startedAt, &completedAt, &updatedAt,
 	)
 	if err != nil {
@@ -472,21 +634,26 @@ func (c *Controller) GetScrapeJob(w http.ResponseWriter, r *http.Request, claims
 		return
 	}
 
-	utils.JSONOK(w, map[string]interface{}{
-		"success": true,
-		"job": map[string]interface{}{
-			"id":          jobID,
-			"url":         sourceURL,
-			"status":      status,
-			"progress":    progress,
-			"message":     utils.NullString(message),
-			"error":       utils.NullString(errMsg),
-			"createdAt":   createdAt,
-			"startedAt":   utils.NullTime(startedAt),
-			"completedAt": utils.NullTime(completedAt),
-			"updatedAt":   updatedAt,
-		},
-	})
+	job := map[string]interface{}{
+		"id":              jobID,
+		"url":             sourceURL,
+		"status":          status,
+		"progress":        progress,
+		"message":         utils.NullString(message),
+		"error":           utils.NullString(errMsg),
+		"triggeredBy":     triggeredBy,
+		"summary":         nil,
+		"attempts":        attempts,
+		"cancelRequested": cancelRequested,
+		"createdAt":       createdAt,
+		"startedAt":       utils.NullTime(startedAt),
+		"completedAt":     utils.NullTime(completedAt),
+		"updatedAt":       updatedAt,
+	}
+	if len(summary) > 0 {
+		job["summary"] = json.RawMessage(summary)
+	}
+	utils.JSONOK(w, map[string]interface{}{"success": true, "job": job})
 }
 
 func (c *Controller) QueryDocuments(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
@@ -497,27 +664,50 @@ func (c *Controller) QueryDocuments(w http.ResponseWriter, r *http.Request, clai
 		utils.JSONErr(w, http.StatusBadRequest, "query is required")
 		return
 	}
-	var sources, docs int
-	if err := c.db.QueryRow(`SELECT COUNT(*) FROM scraper_sources WHERE user_id=$1`, claims.UserID).Scan(&sources); err != nil {
+	var sourceCount, docs int
+	if err := c.db.QueryRow(`SELECT COUNT(*) FROM scraper_sources WHERE user_id=$1`, claims.UserID).Scan(&sourceCount); err != nil {
 		c.logRequestWarn(r, "query documents source count failed", err, "user_id", claims.UserID)
 	}
 	if err := c.db.QueryRow(`SELECT COUNT(*) FROM documents WHERE user_id=$1`, claims.UserID).Scan(&docs); err != nil {
 		c.logRequestWarn(r, "query documents count failed", err, "user_id", claims.UserID)
 	}
-	matches, matchErr := c.pineconeQuery(claims.UserID, body.Query, 5)
-	relevantMatches := relevantRAGMatches(matches, 0.65)
-	if matchErr != nil {
-		c.logRequestWarn(r, "document context lookup failed", matchErr, "user_id", claims.UserID)
+	r = r.WithContext(withUsageScope(r.Context(), claims.UserID, usageKindChat))
+	aiSettings := c.loadAISettings(r.Context(), claims.UserID)
+	aiSettings = aiSettings.withLanguage(aiSettings.answerLanguage(body.Query, ""))
+	answer := aiSettings.fallbackAnswer()
+	sources := []answerSource{}
+	relevantMatches := []map[string]interface{}{}
+	curated := c.matchCuratedAnswer(r.Context(), claims.UserID, body.Query)
+	if curated != nil {
+		answer = curated.Answer
+	} else {
+		var matchErr error
+		relevantMatches, _, matchErr = c.retrieveInLanguage(r.Context(), claims.UserID, body.Query, aiSettings)
+		if matchErr != nil {
+			c.logRequestWarn(r, "document context lookup failed", matchErr, "user_id", claims.UserID)
+		}
 	}
-	answer := "I don't have information about that. Please contact support."
 	if len(relevantMatches) > 0 {
-		if ai, err := c.openAIAnswerWithContext(body.Query, relevantMatches); err == nil && strings.TrimSpace(ai) != "" {
+		if ai, err := c.answerWithContext(r.Context(), ragPrompt{
+			System:  c.systemPromptForOwner(r.Context(), claims.UserID),
+			Query:   body.Query,
+			Matches: relevantMatches,
+			AI:      aiSettings,
+		}); err == nil && strings.TrimSpace(ai) != "" {
 			answer = ai
-		} else if err != nil {
+			sources = answerSources(ai, aiSettings.fallbackAnswer(), relevantMatches)
+		} else if err != nil && !errors.Is(err, errSpendCapReached) {
 			c.logRequestWarn(r, "document response generation failed", err, "user_id", claims.UserID)
 		}
 	}
-	utils.JSONOK(w, map[string]interface{}{"success": true, "answer": answer, "documentsSearched": sources + docs, "matches": relevantMatches})
+	resp := map[string]interface{}{"success": true, "answer": answer, "documentsSearched": sourceCount + docs, "matches": relevantMatches, "sources": sources}
+	if curated != nil {
+		resp["curated"] = curated.reference()
+	}
+	if _, usage := messageUsage(r.Context()); usage != nil {
+		resp["usage"] = usage
+	}
+	utils.JSONOK(w, resp)
 }
 
 func relevantRAGMatches(matches []map[string]interface{}, minScore float64) []map[string]interface{} {
@@ -575,12 +765,12 @@ func (c *Controller) DeleteSource(w http.ResponseWriter, r *http.Request, claims
 		utils.JSONErr(w, http.StatusBadRequest, "invalid url")
 		return
 	}
-	if err := c.pineconeDeleteBySource(claims.UserID, "website", normalizedURL); err != nil {
+	if err := c.vectorDeleteBySource(claims.UserID, "website", normalizedURL); err != nil {
 		c.logRequestError(r, "delete source vector cleanup failed", err, "user_id", claims.UserID, "url", normalizedURL)
 		utils.JSONErr(w, http.StatusBadGateway, "failed to remove source vectors")
 		return
 	}
-	if err := c.pineconeDeleteByURL(claims.UserID, normalizedURL); err != nil {
+	if err := c.vectorDeleteByURL(claims.UserID, normalizedURL); err != nil {
 		c.logRequestWarn(r, "delete source legacy vector cleanup failed", err, "user_id", claims.UserID, "url", normalizedURL)
 	}
 	_, err = c.db.Exec(`DELETE FROM scraper_sources WHERE user_id=$1 AND source_url=$2`, claims.UserID, normalizedURL)
@@ -593,7 +783,7 @@ func (c *Controller) DeleteSource(w http.ResponseWriter, r *http.Request, claims
 }
 
 func (c *Controller) DeleteAllSources(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
-	if err := c.pineconeDeleteAll(claims.UserID); err != nil {
+	if err := c.vectorDeleteNamespace(claims.UserID); err != nil {
 		c.logRequestError(r, "delete all source vectors failed", err, "user_id", claims.UserID)
 		utils.JSONErr(w, http.StatusBadGateway, "failed to remove vectors")
 		return
@@ -637,7 +827,9 @@ func (c *Controller) SourceStats(w http.ResponseWriter, r *http.Request, claims
 }
 
 func (c *Controller) GetSources(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
-	rows, err := c.db.Query(`SELECT id,source_url,source_title,scraped_pages,created_at FROM scraper_sources WHERE user_id=$1 ORDER BY created_at DESC`, claims.UserID)
+	rows, err := c.db.Query(`SELECT id,source_url,source_title,scraped_pages,chunk_max_tokens,chunk_overlap_tokens,crawl_rules,
+			refresh_interval,last_crawled_at,next_refresh_at,created_at
+		FROM scraper_sources WHERE user_id=$1 ORDER BY created_at DESC`, claims.UserID)
 	if err != nil {
 		c.logRequestError(r, "get sources query failed", err, "user_id", claims.UserID)
 		utils.JSONErr(w, http.StatusInternalServerError, "db error")
@@ -647,14 +839,31 @@ func (c *Controller) GetSources(w http.ResponseWriter, r *http.Request, claims T
 	items := []map[string]interface{}{}
 	for rows.Next() {
 		var id, url string
-		var title sql.NullString
+		var title, refreshInterval sql.NullString
 		var scrapedPages int
+		var chunkMax, chunkOverlap sql.NullInt64
+		var rawRules []byte
+		var lastCrawled, nextRefresh sql.NullTime
 		var created time.Time
-		if err := rows.Scan(&id, &url, &title, &scrapedPages, &created); err != nil {
+		if err := rows.Scan(&id, &url, &title, &scrapedPages, &chunkMax, &chunkOverlap, &rawRules,
+			&refreshInterval, &lastCrawled, &nextRefresh, &created); err != nil {
 			c.logRequestWarn(r, "get sources row scan failed", err, "user_id", claims.UserID)
 			continue
 		}
-		items = append(items, map[string]interface{}{"id": id, "url": url, "title": utils.NullString(title), "scrapedPages": scrapedPages, "createdAt": created})
+		items = append(items, map[string]interface{}{
+			"id":           id,
+			"url":          url,
+			"title":        utils.NullString(title),
+			"scrapedPages": scrapedPages,
+			"chunking":     map[string]interface{}{"maxTokens": utils.NullableInt64(chunkMax), "overlapTokens": utils.NullableInt64(chunkOverlap)},
+			"crawlRules":   crawlRulesJSON(rawRules),
+			"refresh": map[string]interface{}{
+				"interval":      utils.NullString(refreshInterval),
+				"lastCrawledAt": utils.NullTime(lastCrawled),
+				"nextRefreshAt": utils.NullTime(nextRefresh),
+			},
+			"createdAt": created,
+		})
 	}
 	utils.JSONOK(w, map[string]interface{}{"success": true, "sources": items})
 }
@@ -0,0 +1,418 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"konvoq-backend/utils"
+)
+
+const (
+	// sourceRefreshTick is how often the background worker looks for due sources.
+	sourceRefreshTick = 10 * time.Minute
+	// sourceRefreshBatch bounds how many refreshes one tick starts; the rest
+	// wait for the next tick.
+	sourceRefreshBatch = 5
+	// sourceRefreshLease pushes a claimed source's next_refresh_at out while its
+	// refresh runs, so another instance does not claim it and a crashed run is
+	// retried later.
+	sourceRefreshLease = 6 * time.Hour
+	// maxSummaryURLs bounds each URL list in a refresh's change summary.
+	maxSummaryURLs = 50
+)
+
+// sourceRefreshIntervals are the refresh_interval values a source can use,
+// besides "off".
+var sourceRefreshIntervals = map[string]time.Duration{
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+}
+
+// sourceRefreshInterval resolves a source's refresh_interval against the
+// owner's plan. An empty setting follows the plan default, "off" turns the
+// schedule off (0), and a setting faster than the plan allows is slowed to it.
+func sourceRefreshInterval(setting, plan string) time.Duration {
+	planInterval := sourceRefreshIntervals[limitsForPlan(plan).SourceRefresh]
+	switch setting = strings.ToLower(strings.TrimSpace(setting)); setting {
+	case "":
+		return planInterval
+	case "off":
+		return 0
+	}
+	interval, ok := sourceRefreshIntervals[setting]
+	if !ok || planInterval == 0 {
+		return planInterval
+	}
+	if interval < planInterval {
+		return planInterval
+	}
+	return interval
+}
+
+// crawledPage is what the last crawl stored about a page in scraper_pages.
+type crawledPage struct {
+	Title        string
+	ContentHash  string
+	ETag         string
+	LastModified string
+}
+
+// pageContentHash is the content hash stored for a page; a refresh re-indexes
+// a page only when it changes.
+func pageContentHash(title, text string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(title) + "\n" + strings.TrimSpace(text)))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadCrawledPages returns the pages stored for a source, keyed by URL.
+func (c *Controller) loadCrawledPages(userID, sourceURL string) (map[string]crawledPage, error) {
+	rows, err := c.db.Query(`SELECT p.url,p.title,p.content_hash,COALESCE(p.etag,''),COALESCE(p.last_modified,'')
+		FROM scraper_pages p JOIN scraper_sources s ON s.id=p.source_id
+		WHERE s.user_id=$1 AND s.source_url=$2`, userID, sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pages := map[string]crawledPage{}
+	for rows.Next() {
+		var pageURL string
+		var page crawledPage
+		if err := rows.Scan(&pageURL, &page.Title, &page.ContentHash, &page.ETag, &page.LastModified); err != nil {
+			return nil, err
+		}
+		pages[pageURL] = page
+	}
+	return pages, rows.Err()
+}
+
+// saveCrawledPages records a crawl in scraper_pages: pages are upserted and
+// removed URLs deleted. With replaceAll every page missing from the crawl is
+// deleted, as a full scrape replaces the source's index.
+func (c *Controller) saveCrawledPages(userID, sourceURL string, pages []scrapedPage, removed []string, replaceAll bool) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var sourceID string
+	if err := tx.QueryRow(`SELECT id FROM scraper_sources WHERE user_id=$1 AND source_url=$2`, userID, sourceURL).Scan(&sourceID); err != nil {
+		return err
+	}
+	if replaceAll {
+		if _, err := tx.Exec(`DELETE FROM scraper_pages WHERE source_id=$1`, sourceID); err != nil {
+			return err
+		}
+	}
+	for _, pageURL := range removed {
+		if _, err := tx.Exec(`DELETE FROM scraper_pages WHERE source_id=$1 AND url=$2`, sourceID, pageURL); err != nil {
+			return err
+		}
+	}
+	for _, page := range pages {
+		if _, err := tx.Exec(`INSERT INTO scraper_pages (source_id,url,title,content_hash,etag,last_modified)
+			VALUES ($1,$2,$3,$4,$5,$6)
+			ON CONFLICT (source_id,url) DO UPDATE SET title=EXCLUDED.title,content_hash=EXCLUDED.content_hash,
+				etag=EXCLUDED.etag,last_modified=EXCLUDED.last_modified,last_seen_at=CURRENT_TIMESTAMP`,
+			sourceID, page.URL, page.Title, page.ContentHash, utils.Nullable(page.ETag), utils.Nullable(page.LastModified)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// scheduleSourceRefresh sets a source's next_refresh_at from its interval and
+// the owner's plan, counting from now. crawled marks a successful crawl.
+func (c *Controller) scheduleSourceRefresh(userID, sourceURL string, crawled bool) {
+	var setting sql.NullString
+	var plan string
+	if err := c.db.QueryRow(`SELECT s.refresh_interval,COALESCE(u.plan_type,'free')
+		FROM scraper_sources s JOIN users u ON u.id=s.user_id
+		WHERE s.user_id=$1 AND s.source_url=$2`, userID, sourceURL).Scan(&setting, &plan); err != nil {
+		if err != sql.ErrNoRows {
+			c.logger.Warn("source refresh schedule query failed", "user_id", userID, "url", sourceURL, "error", err)
+		}
+		return
+	}
+	var seconds interface{}
+	if interval := sourceRefreshInterval(setting.String, plan); interval > 0 {
+		seconds = int64(interval / time.Second)
+	}
+	if _, err := c.db.Exec(`UPDATE scraper_sources
+		SET last_crawled_at=CASE WHEN $3 THEN CURRENT_TIMESTAMP ELSE last_crawled_at END,
+		    next_refresh_at=CURRENT_TIMESTAMP + ($4 * INTERVAL '1 second')
+		WHERE user_id=$1 AND source_url=$2`, userID, sourceURL, crawled, seconds); err != nil {
+		c.logger.Warn("source refresh schedule update failed", "user_id", userID, "url", sourceURL, "error", err)
+	}
+}
+
+// refreshDueSources claims sources whose next_refresh_at has passed and queues
+// a refresh job for each. Sources with a scrape already queued or running are
+// skipped; SKIP LOCKED and the lease keep two instances from claiming the same source.
+func (c *Controller) refreshDueSources(ctx context.Context) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.logger.Warn("source refresh claim failed", "error", err)
+		return
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `SELECT s.user_id,s.source_url,COALESCE(u.plan_type,'free')
+		FROM scraper_sources s JOIN users u ON u.id=s.user_id
+		WHERE s.next_refresh_at <= CURRENT_TIMESTAMP
+		  AND NOT EXISTS (SELECT 1 FROM scrape_jobs j
+			WHERE j.user_id=s.user_id AND j.source_url=s.source_url
+			  AND j.status IN ('queued','scraping','indexing'))
+		ORDER BY s.next_refresh_at
+		LIMIT $1
+		FOR UPDATE OF s SKIP LOCKED`, sourceRefreshBatch)
+	if err != nil {
+		c.logger.Warn("source refresh due query failed", "error", err)
+		return
+	}
+	type claim struct{ userID, sourceURL, plan string }
+	var claims []claim
+	for rows.Next() {
+		var cl claim
+		if err := rows.Scan(&cl.userID, &cl.sourceURL, &cl.plan); err != nil {
+			c.logger.Warn("source refresh due row scan failed", "error", err)
+			continue
+		}
+		claims = append(claims, cl)
+	}
+	rows.Close()
+	if len(claims) == 0 {
+		return
+	}
+
+	for _, cl := range claims {
+		if _, err := tx.ExecContext(ctx, `UPDATE scraper_sources SET next_refresh_at=CURRENT_TIMESTAMP + ($3 * INTERVAL '1 second')
+			WHERE user_id=$1 AND source_url=$2`, cl.userID, cl.sourceURL, int64(sourceRefreshLease/time.Second)); err != nil {
+			c.logger.Warn("source refresh lease failed", "user_id", cl.userID, "url", cl.sourceURL, "error", err)
+			return
+		}
+		var otherPages int
+		if err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(scraped_pages), 0) FROM scraper_sources WHERE user_id=$1 AND source_url<>$2`,
+			cl.userID, cl.sourceURL).Scan(&otherPages); err != nil {
+			c.logger.Warn("source refresh usage query failed", "user_id", cl.userID, "url", cl.sourceURL, "error", err)
+			return
+		}
+		maxPages := limitsForPlan(cl.plan).ScrapedPages - otherPages
+		if _, err := tx.ExecContext(ctx, `INSERT INTO scrape_jobs (user_id,source_url,status,progress,message,triggered_by,max_pages)
+			VALUES ($1,$2,'queued',5,'Queued for scheduled refresh','scheduled',$3)`,
+			cl.userID, cl.sourceURL, maxPages); err != nil {
+			c.logger.Warn("source refresh job insert failed", "user_id", cl.userID, "url", cl.sourceURL, "error", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.logger.Warn("source refresh claim commit failed", "error", err)
+		return
+	}
+	c.wakeScrapeQueue()
+}
+
+// sourceChangeSummary is stored in scrape_jobs.summary for a refresh.
+type sourceChangeSummary struct {
+	Changed     int                 `json:"changed"`
+	Added       int                 `json:"added"`
+	Removed     int                 `json:"removed"`
+	Unchanged   int                 `json:"unchanged"`
+	NotModified int                 `json:"notModified"`
+	Pages       map[string][]string `json:"pages"`
+}
+
+func (s *sourceChangeSummary) addPage(kind, pageURL string) {
+	if len(s.Pages[kind]) < maxSummaryURLs {
+		s.Pages[kind] = append(s.Pages[kind], pageURL)
+	}
+}
+
+// runSourceRefresh re-crawls a source with conditional requests and re-indexes
+// only what changed: changed and new pages are re-chunked and upserted, and
+// the vectors of removed pages are deleted. Known pages the crawl did not
+// reach are kept, unless the crawl stopped at the page limit, in which case
+// they are dropped as a full scrape would. A crawl stopped by ctx returns
+// before anything is re-indexed.
+func (c *Controller) runSourceRefresh(ctx context.Context, job *scrapeJob) {
+	userID, sourceURL, jobID, maxPages := job.UserID, job.SourceURL, job.ID, job.MaxPages
+	crawled := false
+	defer func() { c.scheduleSourceRefresh(userID, sourceURL, crawled) }()
+
+	if maxPages <= 0 {
+		c.updateScrapeJob(jobID, "failed", 100, "Refresh failed", "scraped page limit reached")
+		return
+	}
+	known, err := c.loadCrawledPages(userID, sourceURL)
+	if err != nil {
+		c.logger.Warn("source refresh page load failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
+		c.updateScrapeJob(jobID, "failed", 100, "Refresh failed", "could not load crawled pages")
+		return
+	}
+
+	c.updateScrapeJob(jobID, "scraping", 20, fmt.Sprintf("Checking up to %d pages for changes", maxPages), "")
+	result, err := c.crawlForJob(ctx, job, known)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		c.logger.Warn("source refresh crawl failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
+		c.updateScrapeJob(jobID, "failed", 100, "Refresh failed", err.Error())
+		return
+	}
+
+	summary := sourceChangeSummary{Pages: map[string][]string{}}
+	seen := make(map[string]struct{}, len(result.Pages))
+	reindex := make([]scrapedPage, 0, len(result.Pages))
+	for _, page := range result.Pages {
+		seen[page.URL] = struct{}{}
+		prev, isKnown := known[page.URL]
+		switch {
+		case page.NotModified:
+			summary.NotModified++
+		case !isKnown:
+			summary.Added++
+			summary.addPage("added", page.URL)
+			reindex = append(reindex, page)
+		case prev.ContentHash != page.ContentHash:
+			summary.Changed++
+			summary.addPage("changed", page.URL)
+			reindex = append(reindex, page)
+		default:
+			summary.Unchanged++
+		}
+	}
+	removed := append([]string{}, result.Gone...)
+	if len(result.Pages) >= maxPages {
+		for _, pageURL := range result.Gone {
+			seen[pageURL] = struct{}{}
+		}
+		for pageURL := range known {
+			if _, ok := seen[pageURL]; !ok {
+				removed = append(removed, pageURL)
+			}
+		}
+	}
+	summary.Removed = len(removed)
+	for _, pageURL := range removed {
+		summary.addPage("removed", pageURL)
+	}
+
+	// Sources scraped before pages were tracked have no known pages; their
+	// index is replaced as a whole so chunks of the untracked crawl go too.
+	replaceAll := len(known) == 0
+	c.updateScrapeJob(jobID, "indexing", 60, fmt.Sprintf("Re-indexing %d changed pages", len(reindex)), "")
+	if replaceAll {
+		if err := c.vectorDeleteBySource(userID, "website", sourceURL); err != nil {
+			c.logger.Warn("source refresh vector cleanup failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
+			c.updateScrapeJob(jobID, "failed", 100, "Indexing failed", err.Error())
+			return
+		}
+	} else {
+		for _, pageURL := range removed {
+			if err := c.vectorDeletePage(userID, "website", sourceURL, pageURL); err != nil {
+				c.logger.Warn("source refresh page delete failed", "user_id", userID, "url", pageURL, "job_id", jobID, "error", err)
+				c.updateScrapeJob(jobID, "failed", 100, "Indexing failed", err.Error())
+				return
+			}
+		}
+		for _, page := range reindex {
+			if err := c.vectorDeletePage(userID, "website", sourceURL, page.URL); err != nil {
+				c.logger.Warn("source refresh page delete failed", "user_id", userID, "url", page.URL, "job_id", jobID, "error", err)
+				c.updateScrapeJob(jobID, "failed", 100, "Indexing failed", err.Error())
+				return
+			}
+		}
+	}
+	if chunks := c.buildRAGChunks(userID, sourceURL, reindex, c.sourceChunkOptions(userID, sourceURL)); len(chunks) > 0 {
+		c.updateScrapeJob(jobID, "indexing", 85, "Indexing changed content", "")
+		if err := c.vectorUpsertChunks(userID, chunks); err != nil {
+			c.logger.Warn("source refresh index upsert failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
+			c.updateScrapeJob(jobID, "failed", 100, "Indexing failed", err.Error())
+			return
+		}
+	}
+
+	if err := c.saveCrawledPages(userID, sourceURL, result.Pages, removed, replaceAll); err != nil {
+		c.logger.Warn("source refresh page save failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
+	}
+	if _, err := c.db.Exec(`UPDATE scraper_sources s
+		SET scraped_pages=(SELECT COUNT(*) FROM scraper_pages p WHERE p.source_id=s.id),updated_at=CURRENT_TIMESTAMP
+		WHERE s.user_id=$1 AND s.source_url=$2`, userID, sourceURL); err != nil {
+		c.logger.Warn("source refresh page count update failed", "user_id", userID, "url", sourceURL, "job_id", jobID, "error", err)
+	}
+	crawled = true
+
+	if b, err := json.Marshal(summary); err == nil {
+		if _, err := c.db.Exec(`UPDATE scrape_jobs SET summary=$2::jsonb WHERE id=$1`, jobID, string(b)); err != nil {
+			c.logger.Warn("source refresh summary update failed", "job_id", jobID, "error", err)
+		}
+	}
+	c.updateScrapeJob(jobID, "done", 100, fmt.Sprintf("Refresh complete: %d changed, %d added, %d removed, %d unchanged",
+		summary.Changed, summary.Added, summary.Removed, summary.Unchanged+summary.NotModified), "")
+}
+
+// UpdateSourceRefresh sets a source's refresh schedule: daily, weekly, monthly,
+// off, or empty for the plan default.
+func (c *Controller) UpdateSourceRefresh(w http.ResponseWriter, r *http.Request, claims TokenClaims, user UserRecord) {
+	if err := c.RequireCSRF(r); err != nil {
+		utils.JSONErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+	var body struct {
+		URL      string `json:"url"`
+		Interval string `json:"interval"`
+	}
+	if err := utils.DecodeJSON(r, &body); err != nil || strings.TrimSpace(body.URL) == "" {
+		utils.JSONErr(w, http.StatusBadRequest, "url is required")
+		return
+	}
+	sourceURL, err := normalizeScrapeURL(body.URL)
+	if err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, "invalid url")
+		return
+	}
+	interval := strings.ToLower(strings.TrimSpace(body.Interval))
+	if _, ok := sourceRefreshIntervals[interval]; !ok && interval != "" && interval != "off" {
+		utils.JSONErr(w, http.StatusBadRequest, "interval must be daily, weekly, monthly or off")
+		return
+	}
+	planRefresh := limitsForPlan(user.PlanType).SourceRefresh
+	if d, ok := sourceRefreshIntervals[interval]; ok && d < sourceRefreshIntervals[planRefresh] {
+		utils.JSONErr(w, http.StatusPaymentRequired, fmt.Sprintf("your plan refreshes sources at most %s", planRefresh))
+		return
+	}
+
+	var seconds interface{}
+	if d := sourceRefreshInterval(interval, user.PlanType); d > 0 {
+		seconds = int64(d / time.Second)
+	}
+	var nextRefresh sql.NullTime
+	err = c.db.QueryRow(`UPDATE scraper_sources
+		SET refresh_interval=$3,
+		    next_refresh_at=CASE WHEN last_crawled_at IS NULL THEN NULL ELSE last_crawled_at + ($4 * INTERVAL '1 second') END,
+		    updated_at=CURRENT_TIMESTAMP
+		WHERE user_id=$1 AND source_url=$2
+		RETURNING next_refresh_at`, claims.UserID, sourceURL, utils.Nullable(interval), seconds).Scan(&nextRefresh)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.JSONErr(w, http.StatusNotFound, "source not found")
+			return
+		}
+		c.logRequestError(r, "source refresh update failed", err, "user_id", claims.UserID, "url", sourceURL)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+	utils.JSONOK(w, map[string]interface{}{
+		"success":         true,
+		"url":             sourceURL,
+		"refreshInterval": utils.Nullable(interval),
+		"nextRefreshAt":   utils.NullTime(nextRefresh),
+	})
+}
@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"konvoq-backend/platform/llm"
+	"konvoq-backend/utils"
+)
+
+// SuggestionSettings control the chips shown under widget answers
+// (widget_config.suggestions).
+type SuggestionSettings struct {
+	// FollowUps adds up to maxFollowUps questions generated from the
+	// retrieved context to grounded answers.
+	FollowUps bool `json:"followUps"`
+	// QuickReplies are owner-defined buttons returned with every answer.
+	QuickReplies []QuickReply `json:"quickReplies"`
+}
+
+// QuickReply is a button whose Message is sent as the visitor's next message.
+type QuickReply struct {
+	Label   string `json:"label"`
+	Message string `json:"message"`
+}
+
+const (
+	maxFollowUps              = 3
+	maxFollowUpChars          = 150
+	maxQuickReplies           = 6
+	maxQuickReplyLabelChars   = 40
+	maxQuickReplyMessageChars = 200
+)
+
+func defaultSuggestionSettings() SuggestionSettings {
+	return SuggestionSettings{QuickReplies: []QuickReply{}}
+}
+
+func (s SuggestionSettings) validate() error {
+	if len(s.QuickReplies) > maxQuickReplies {
+		return fmt.Errorf("quickReplies can have at most %d entries", maxQuickReplies)
+	}
+	for _, q := range s.QuickReplies {
+		if q.Label == "" || utf8.RuneCountInString(q.Label) > maxQuickReplyLabelChars {
+			return fmt.Errorf("each quick reply label must be 1-%d characters", maxQuickReplyLabelChars)
+		}
+		if q.Message == "" || utf8.RuneCountInString(q.Message) > maxQuickReplyMessageChars {
+			return fmt.Errorf("each quick reply message must be 1-%d characters", maxQuickReplyMessageChars)
+		}
+	}
+	return nil
+}
+
+func (c *Controller) loadSuggestionSettings(ctx context.Context, ownerID string) SuggestionSettings {
+	var cfgRaw []byte
+	if err := c.db.QueryRowContext(ctx, `SELECT widget_config FROM widget_keys WHERE user_id=$1`, ownerID).Scan(&cfgRaw); err != nil {
+		return defaultSuggestionSettings()
+	}
+	return suggestionSettingsFromConfig(cfgRaw)
+}
+
+// suggestionSettingsFromConfig overlays widget_config.suggestions on the
+// defaults, discarding stored values that no longer validate.
+func suggestionSettingsFromConfig(cfgRaw []byte) SuggestionSettings {
+	settings := defaultSuggestionSettings()
+	var cfg struct {
+		Suggestions json.RawMessage `json:"suggestions"`
+	}
+	if err := json.Unmarshal(cfgRaw, &cfg); err != nil || len(cfg.Suggestions) == 0 {
+		return settings
+	}
+	stored := defaultSuggestionSettings()
+	if err := json.Unmarshal(cfg.Suggestions, &stored); err != nil || stored.validate() != nil {
+		return settings
+	}
+	if stored.QuickReplies == nil {
+		stored.QuickReplies = []QuickReply{}
+	}
+	return stored
+}
+
+func (c *Controller) GetSuggestions(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	var cfgRaw []byte
+	err := c.db.QueryRow(`SELECT widget_config FROM widget_keys WHERE user_id=$1`, claims.UserID).Scan(&cfgRaw)
+	if err != nil {
+		utils.JSONErr(w, http.StatusNotFound, "widget not found")
+		return
+	}
+	utils.JSONOK(w, map[string]interface{}{
+		"success":     true,
+		"suggestions": suggestionSettingsFromConfig(cfgRaw),
+	})
+}
+
+func (c *Controller) UpdateSuggestions(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
+	if err := c.RequireCSRF(r); err != nil {
+		utils.JSONErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+	body := defaultSuggestionSettings()
+	if err := utils.DecodeJSON(r, &body); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, "invalid payload")
+		return
+	}
+	replies := make([]QuickReply, 0, len(body.QuickReplies))
+	for _, q := range body.QuickReplies {
+		q.Label = strings.TrimSpace(q.Label)
+		q.Message = strings.TrimSpace(q.Message)
+		if q.Message == "" {
+			q.Message = q.Label
+		}
+		replies = append(replies, q)
+	}
+	body.QuickReplies = replies
+	if err := body.validate(); err != nil {
+		utils.JSONErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	patch := map[string]interface{}{"suggestions": body}
+	patchJSON, _ := json.Marshal(patch)
+
+	var widgetKey string
+	err := c.db.QueryRow(`UPDATE widget_keys SET widget_config = widget_config || $2::jsonb, updated_at=CURRENT_TIMESTAMP WHERE user_id=$1 RETURNING widget_key`,
+		claims.UserID, string(patchJSON)).Scan(&widgetKey)
+	if err != nil {
+		c.logRequestError(r, "update suggestions failed", err, "user_id", claims.UserID)
+		utils.JSONErr(w, http.StatusInternalServerError, "db error")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	_ = c.redis.Del(ctx, "widget:"+widgetKey).Err()
+
+	utils.JSONOK(w, map[string]interface{}{"success": true, "suggestions": body})
+}
+
+// suggestFollowUps asks the model for short questions the visitor might ask
+// next that the retrieved context can answer, in the answer language. It
+// returns nil on any failure; follow-ups are never worth failing an answer.
+func (c *Controller) suggestFollowUps(ctx context.Context, p ragPrompt, answer string) []string {
+	if c.llm == nil || len(p.Matches) == 0 {
+		return nil
+	}
+	language := supportedLanguages[p.AI.language]
+	if language == "" {
+		language = "the language of the question"
+	}
+	temperature := 0.0
+	reply, err := c.llmChat(ctx, llm.ChatRequest{
+		Messages: []llm.Message{
+			{Role: "system", Content: fmt.Sprintf("You suggest follow-up questions for a website chat. Write up to %d short questions the visitor might ask next that the context answers. Do not repeat the question already asked. Write them in %s. Reply with one question per line and nothing else.", maxFollowUps, language)},
+			{Role: "user", Content: "Context:\n" + ragContextBlock(p.Matches) + "\n\nQuestion: " + p.Query + "\nAnswer: " + truncateRunes(answer, 1000)},
+		},
+		Temperature: &temperature,
+		MaxTokens:   150,
+	})
+	if err != nil {
+		return nil
+	}
+	return parseFollowUps(reply, p.Query)
+}
+
+// parseFollowUps takes one question per line, stripping list markers and
+// quotes, and drops repeats of the original question.
+func parseFollowUps(reply, question string) []string {
+	seen := map[string]struct{}{strings.ToLower(strings.TrimSpace(question)): {}}
+	out := make([]string, 0, maxFollowUps)
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimLeft(line, "-*•0123456789.) ")
+		line = strings.Trim(strings.TrimSpace(line), `"'`)
+		if line == "" || utf8.RuneCountInString(line) > maxFollowUpChars {
+			continue
+		}
+		key := strings.ToLower(line)
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, line)
+		if len(out) == maxFollowUps {
+			break
+		}
+	}
+	return out
+}
@@ -9,7 +9,7 @@ import (
 	"konvoq-backend/utils"
 )
 
-func (c *Controller) GetUsage(w http.ResponseWriter, _ *http.Request, _ TokenClaims, user UserRecord) {
+func (c *Controller) GetUsage(w http.ResponseWriter, r *http.Request, _ TokenClaims, user UserRecord) {
 	var remaining interface{}
 	var atLimit bool
 	if user.ConversationsLimit.Valid {
@@ -31,6 +31,7 @@ func (c *Controller) GetUsage(w http.ResponseWriter, _ *http.Request, _ TokenCla
 			"resetDate":              user.PlanResetDate.AddDate(0, 1, 0),
 			"isAtLimit":              atLimit,
 		},
+		"aiUsage": c.aiUsageSummary(r, user.ID),
 		"planLimits": map[string]interface{}{
 			"scrapedPages":  limits.ScrapedPages,
 			"documents":     limits.Documents,
@@ -45,13 +46,15 @@ func (c *Controller) GetUsage(w http.ResponseWriter, _ *http.Request, _ TokenCla
 			"hasFlows":      limits.HasFlows,
 			"hasPersona":    limits.HasPersona,
 			"hasNavigation": limits.HasNavigation,
+			"hasAITuning":   limits.HasAITuning,
+			"answerTokens":  limits.AnswerTokens,
 			"roles":         limits.Roles,
 		},
 	})
 }
 
 func (c *Controller) Overview(w http.ResponseWriter, r *http.Request, claims TokenClaims, _ UserRecord) {
-	var chats, leads, sources, widgetViews, widgetMessages, ratings int
+	var chats, leads, sources, widgetViews, widgetMessages, ratings, guardrailBlocks int
 	if err := c.db.QueryRow(`SELECT COUNT(*) FROM chat_conversations WHERE user_id=$1 AND is_deleted=FALSE`, claims.UserID).Scan(&chats); err != nil {
 		c.logRequestWarn(r, "overview chat count failed", err, "user_id", claims.UserID)
 	}
@@ -70,13 +73,17 @@ func (c *Controller) Overview(w http.ResponseWriter, r *http.Request, claims Tok
 	if err := c.db.QueryRow(`SELECT COUNT(*) FROM chat_ratings WHERE user_id=$1`, claims.UserID).Scan(&ratings); err != nil {
 		c.logRequestWarn(r, "overview ratings count failed", err, "user_id", claims.UserID)
 	}
+	if err := c.db.QueryRow(`SELECT COUNT(*) FROM widget_analytics wa JOIN widget_keys wk ON wa.widget_key_id=wk.id WHERE wk.user_id=$1 AND wa.event_type=$2`, claims.UserID, guardrailBlockedEvent).Scan(&guardrailBlocks); err != nil {
+		c.logRequestWarn(r, "overview guardrail blocks count failed", err, "user_id", claims.UserID)
+	}
 	utils.JSONOK(w, map[string]interface{}{"success": true, "analytics": map[string]interface{}{
-		"chatSessions":   chats,
-		"leads":          leads,
-		"sources":        sources,
-		"widgetViews":    widgetViews,
-		"widgetMessages": widgetMessages,
-		"totalRatings":   ratings,
+		"chatSessions":    chats,
+		"leads":           leads,
+		"sources":         sources,
+		"widgetViews":     widgetViews,
+		"widgetMessages":  widgetMessages,
+		"totalRatings":    ratings,
+		"guardrailBlocks": guardrailBlocks,
 	}})
 }
 
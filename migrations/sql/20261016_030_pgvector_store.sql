@@ -0,0 +1,44 @@
+-- Migration: Optional pgvector-backed vector store (VECTOR_STORE=pgvector)
+-- Skipped when the pgvector extension is not available on the server, so
+-- Pinecone-only deployments are unaffected.
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'vector') THEN
+    RAISE NOTICE 'pgvector extension not available; skipping rag_vectors';
+    RETURN;
+  END IF;
+
+  BEGIN
+    CREATE EXTENSION IF NOT EXISTS vector;
+  EXCEPTION WHEN insufficient_privilege THEN
+    RAISE NOTICE 'insufficient privilege to create pgvector extension; skipping rag_vectors';
+    RETURN;
+  END;
+
+  CREATE TABLE IF NOT EXISTS rag_vectors (
+    namespace    VARCHAR(100)  NOT NULL,
+    id           VARCHAR(100)  NOT NULL,
+    user_id      UUID          NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url          TEXT          NOT NULL DEFAULT '',
+    page_title   TEXT          NOT NULL DEFAULT '',
+    chunk_index  INT           NOT NULL DEFAULT 0,
+    content      TEXT          NOT NULL,
+    widget_key   VARCHAR(255)  NOT NULL DEFAULT '',
+    source_type  VARCHAR(20)   NOT NULL DEFAULT 'website',
+    source_key   TEXT          NOT NULL DEFAULT '',
+    embedding    vector        NOT NULL,
+    created_at   TIMESTAMPTZ   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at   TIMESTAMPTZ   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (namespace, id)
+  );
+
+  CREATE INDEX IF NOT EXISTS idx_rag_vectors_source ON rag_vectors(namespace, source_type, source_key);
+  CREATE INDEX IF NOT EXISTS idx_rag_vectors_url ON rag_vectors(namespace, url);
+  CREATE INDEX IF NOT EXISTS idx_rag_vectors_user ON rag_vectors(user_id);
+
+  DROP TRIGGER IF EXISTS update_rag_vectors_updated_at ON rag_vectors;
+  CREATE TRIGGER update_rag_vectors_updated_at BEFORE UPDATE ON rag_vectors
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END
+$$;
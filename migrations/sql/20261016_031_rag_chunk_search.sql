@@ -0,0 +1,33 @@
+-- Migration: Keyword search index over indexed knowledge chunks
+-- Mirrors the text of every chunk sent to the vector store so retrieval can
+-- fuse Postgres full-text hits (SKUs, error codes, names) with vector results.
+-- The 'simple' configuration avoids stemming and works across languages.
+
+CREATE TABLE IF NOT EXISTS rag_chunks (
+  user_id        UUID          NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  id             VARCHAR(100)  NOT NULL,
+  url            TEXT          NOT NULL DEFAULT '',
+  page_title     TEXT          NOT NULL DEFAULT '',
+  chunk_index    INT           NOT NULL DEFAULT 0,
+  content        TEXT          NOT NULL,
+  widget_key     VARCHAR(255)  NOT NULL DEFAULT '',
+  source_type    VARCHAR(20)   NOT NULL DEFAULT 'website',
+  source_key     TEXT          NOT NULL DEFAULT '',
+  search_vector  TSVECTOR      GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', COALESCE(page_title, '')), 'A') ||
+    setweight(to_tsvector('simple', content), 'B')
+  ) STORED,
+  created_at     TIMESTAMPTZ   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at     TIMESTAMPTZ   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rag_chunks_search ON rag_chunks USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_rag_chunks_source ON rag_chunks(user_id, source_type, source_key);
+CREATE INDEX IF NOT EXISTS idx_rag_chunks_url ON rag_chunks(user_id, url);
+
+DROP TRIGGER IF EXISTS update_rag_chunks_updated_at ON rag_chunks;
+CREATE TRIGGER update_rag_chunks_updated_at
+BEFORE UPDATE ON rag_chunks
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
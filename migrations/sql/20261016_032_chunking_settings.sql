@@ -0,0 +1,27 @@
+-- Migration: Structure-aware chunking
+-- Per-source chunk sizing (NULL means the chunker defaults) and the heading
+-- path each chunk was taken from. The heading path is searchable alongside
+-- the page title.
+
+ALTER TABLE scraper_sources
+ADD COLUMN IF NOT EXISTS chunk_max_tokens INT,
+ADD COLUMN IF NOT EXISTS chunk_overlap_tokens INT;
+
+ALTER TABLE documents
+ADD COLUMN IF NOT EXISTS chunk_max_tokens INT,
+ADD COLUMN IF NOT EXISTS chunk_overlap_tokens INT;
+
+ALTER TABLE IF EXISTS rag_vectors
+ADD COLUMN IF NOT EXISTS heading_path TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE rag_chunks
+ADD COLUMN IF NOT EXISTS heading_path TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE rag_chunks DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE rag_chunks
+ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+  setweight(to_tsvector('simple', COALESCE(page_title, '') || ' ' || COALESCE(heading_path, '')), 'A') ||
+  setweight(to_tsvector('simple', content), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_rag_chunks_search ON rag_chunks USING GIN (search_vector);
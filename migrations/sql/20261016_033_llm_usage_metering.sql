@@ -0,0 +1,29 @@
+-- Migration: LLM token and cost metering
+-- Daily per-user rollup of provider token usage by kind (chat, embedding,
+-- brand) and model. Costs are stored in micro-dollars to keep sums exact.
+-- ai_spend_cap_usd is an optional monthly cap; once reached, the bot answers
+-- with its fallback message instead of calling the model.
+
+CREATE TABLE IF NOT EXISTS llm_usage_daily (
+  user_id            UUID          NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  usage_date         DATE          NOT NULL,
+  kind               VARCHAR(20)   NOT NULL,
+  model              VARCHAR(100)  NOT NULL DEFAULT '',
+  requests           INT           NOT NULL DEFAULT 0,
+  prompt_tokens      BIGINT        NOT NULL DEFAULT 0,
+  completion_tokens  BIGINT        NOT NULL DEFAULT 0,
+  cost_micros        BIGINT        NOT NULL DEFAULT 0,
+  created_at         TIMESTAMPTZ   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at         TIMESTAMPTZ   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, usage_date, kind, model)
+);
+
+CREATE INDEX IF NOT EXISTS idx_llm_usage_daily_date ON llm_usage_daily(usage_date);
+
+DROP TRIGGER IF EXISTS update_llm_usage_daily_updated_at ON llm_usage_daily;
+CREATE TRIGGER update_llm_usage_daily_updated_at
+BEFORE UPDATE ON llm_usage_daily
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS ai_spend_cap_usd NUMERIC(10,2);
@@ -0,0 +1,16 @@
+-- Migration: Knowledge gaps
+-- Questions the bot could not ground in the knowledge base (no chunk above the
+-- retrieval threshold). best_score is the top raw vector similarity, NULL when
+-- vector search returned nothing. /api/knowledge/gaps clusters these into topics.
+
+CREATE TABLE IF NOT EXISTS knowledge_gaps (
+  id               BIGSERIAL PRIMARY KEY,
+  user_id          UUID              NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  widget_key_id    INTEGER           REFERENCES widget_keys(id) ON DELETE SET NULL,
+  conversation_id  UUID              REFERENCES chat_conversations(id) ON DELETE SET NULL,
+  question         TEXT              NOT NULL,
+  best_score       DOUBLE PRECISION,
+  created_at       TIMESTAMP         NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_knowledge_gaps_user_created ON knowledge_gaps(user_id, created_at DESC);
@@ -0,0 +1,26 @@
+-- Migration: Curated Q&A pairs
+-- Exact answers (pricing, refund policy, ...) checked before retrieval. A
+-- message matching the question or one of its alternative phrasings above the
+-- widget's curatedMinScore is answered verbatim.
+
+CREATE TABLE IF NOT EXISTS curated_answers (
+  id               BIGSERIAL PRIMARY KEY,
+  user_id          UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  widget_key_id    INTEGER     NOT NULL REFERENCES widget_keys(id) ON DELETE CASCADE,
+  question         TEXT        NOT NULL,
+  answer           TEXT        NOT NULL,
+  alternatives     JSONB       NOT NULL DEFAULT '[]'::jsonb,
+  is_active        BOOLEAN     NOT NULL DEFAULT TRUE,
+  match_count      INTEGER     NOT NULL DEFAULT 0,
+  last_matched_at  TIMESTAMP,
+  created_at       TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at       TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_curated_answers_user ON curated_answers(user_id, is_active);
+
+DROP TRIGGER IF EXISTS update_curated_answers_updated_at ON curated_answers;
+CREATE TRIGGER update_curated_answers_updated_at
+BEFORE UPDATE ON curated_answers
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
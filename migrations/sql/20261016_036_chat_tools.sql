@@ -0,0 +1,21 @@
+-- Migration: Chat tools
+-- Actions the assistant may take during a conversation: capturing a lead,
+-- requesting a human handoff and looking up an order through the customer's
+-- own endpoint. Order lookups are signed with order_lookup_secret the same way
+-- lead webhooks are.
+
+CREATE TABLE IF NOT EXISTS chat_tool_configs (
+  user_id               UUID        PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  lead_capture_enabled  BOOLEAN     NOT NULL DEFAULT FALSE,
+  handoff_enabled       BOOLEAN     NOT NULL DEFAULT FALSE,
+  order_lookup_url      TEXT,
+  order_lookup_secret   TEXT        NOT NULL,
+  created_at            TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at            TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+DROP TRIGGER IF EXISTS update_chat_tool_configs_updated_at ON chat_tool_configs;
+CREATE TRIGGER update_chat_tool_configs_updated_at
+BEFORE UPDATE ON chat_tool_configs
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,18 @@
+-- Migration: Guardrail events
+-- Visitor messages, retrieved chunks and generated answers stopped by the
+-- public chat guardrails. stage is input, context or output; rule names the
+-- check that fired and detail holds the blocked topic or the chunk URL.
+
+CREATE TABLE IF NOT EXISTS guardrail_events (
+  id               BIGSERIAL PRIMARY KEY,
+  user_id          UUID          NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  widget_key_id    INTEGER       REFERENCES widget_keys(id) ON DELETE SET NULL,
+  conversation_id  UUID          REFERENCES chat_conversations(id) ON DELETE SET NULL,
+  stage            VARCHAR(20)   NOT NULL,
+  rule             VARCHAR(50)   NOT NULL,
+  detail           TEXT,
+  excerpt          TEXT          NOT NULL DEFAULT '',
+  created_at       TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_guardrail_events_user_created ON guardrail_events(user_id, created_at DESC);
@@ -0,0 +1,6 @@
+-- Migration: Rich curated answers
+-- A curated answer can carry a structured message (card, carousel, buttons or
+-- form) shown under its text. Rich replies are stored in
+-- chat_messages.metadata.rich, with the plain-text fallback in content.
+
+ALTER TABLE curated_answers ADD COLUMN IF NOT EXISTS rich JSONB;
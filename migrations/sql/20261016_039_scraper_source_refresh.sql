@@ -0,0 +1,46 @@
+-- Migration: Scheduled source refresh
+-- Scraper sources are re-crawled on a schedule (refresh_interval: daily,
+-- weekly, monthly or off; NULL follows the plan default). scraper_pages keeps
+-- each crawled page's validators and content hash, so a refresh sends
+-- conditional requests and re-indexes only pages that changed. Each refresh
+-- is a scrape_jobs row with triggered_by 'scheduled' and a change summary.
+
+ALTER TABLE scraper_sources
+ADD COLUMN IF NOT EXISTS refresh_interval VARCHAR(16),
+ADD COLUMN IF NOT EXISTS last_crawled_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS next_refresh_at TIMESTAMP;
+
+-- Sources crawled before this migration are refreshed once the worker runs.
+UPDATE scraper_sources
+SET last_crawled_at = COALESCE(last_crawled_at, updated_at),
+    next_refresh_at = CURRENT_TIMESTAMP
+WHERE next_refresh_at IS NULL AND scraped_pages > 0;
+
+CREATE INDEX IF NOT EXISTS idx_scraper_sources_next_refresh
+  ON scraper_sources(next_refresh_at) WHERE next_refresh_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS scraper_pages (
+  source_id      UUID        NOT NULL REFERENCES scraper_sources(id) ON DELETE CASCADE,
+  url            TEXT        NOT NULL,
+  title          TEXT        NOT NULL DEFAULT '',
+  content_hash   VARCHAR(64) NOT NULL,
+  etag           TEXT,
+  last_modified  TEXT,
+  last_seen_at   TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  created_at     TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at     TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (source_id, url)
+);
+
+DROP TRIGGER IF EXISTS update_scraper_pages_updated_at ON scraper_pages;
+CREATE TRIGGER update_scraper_pages_updated_at
+BEFORE UPDATE ON scraper_pages
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
+
+ALTER TABLE scrape_jobs
+ADD COLUMN IF NOT EXISTS triggered_by VARCHAR(16) NOT NULL DEFAULT 'manual',
+ADD COLUMN IF NOT EXISTS summary JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_scrape_jobs_user_source_status
+  ON scrape_jobs(user_id, source_url, status);
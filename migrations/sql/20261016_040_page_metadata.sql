@@ -0,0 +1,14 @@
+-- Migration: Page metadata on indexed chunks
+-- Scraped pages are reduced to their main content, and each chunk carries the
+-- page's meta description, canonical URL and language from its <head>.
+-- Document chunks leave them empty.
+
+ALTER TABLE IF EXISTS rag_vectors
+ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS canonical_url TEXT NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS language VARCHAR(35) NOT NULL DEFAULT '';
+
+ALTER TABLE rag_chunks
+ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS canonical_url TEXT NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS language VARCHAR(35) NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- Migration: Per-source crawl rules
+-- Include/exclude URL patterns, maximum link depth and extra hosts a website
+-- crawl may follow (see CrawlRules). NULL crawls the start host unrestricted.
+
+ALTER TABLE scraper_sources
+ADD COLUMN IF NOT EXISTS crawl_rules JSONB;
@@ -0,0 +1,48 @@
+-- Migration: Durable scrape job queue
+-- scrape_jobs becomes the work queue: workers claim queued jobs with
+-- FOR UPDATE SKIP LOCKED, record a heartbeat while they run, and jobs whose
+-- heartbeat goes stale are queued again. scrape_job_pages checkpoints every
+-- crawled page so a resumed job skips what it already fetched; rows are
+-- removed when the job finishes.
+
+ALTER TABLE scrape_jobs
+ADD COLUMN IF NOT EXISTS max_pages INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS locked_by VARCHAR(100),
+ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS cancel_requested BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_scrape_jobs_queue
+  ON scrape_jobs(created_at) WHERE status = 'queued';
+
+CREATE INDEX IF NOT EXISTS idx_scrape_jobs_running
+  ON scrape_jobs(heartbeat_at) WHERE status IN ('scraping','indexing');
+
+-- Jobs queued before this migration were run by in-process goroutines that
+-- no longer exist; fail them so their sources can be scraped again.
+UPDATE scrape_jobs
+SET status = 'failed',
+    progress = 100,
+    error_message = 'interrupted by a server restart',
+    completed_at = CURRENT_TIMESTAMP
+WHERE status IN ('queued','scraping','indexing') AND max_pages = 0;
+
+CREATE TABLE IF NOT EXISTS scrape_job_pages (
+  job_id         UUID        NOT NULL REFERENCES scrape_jobs(id) ON DELETE CASCADE,
+  url            TEXT        NOT NULL,
+  depth          INTEGER     NOT NULL DEFAULT 0,
+  kept           BOOLEAN     NOT NULL DEFAULT TRUE,
+  gone           BOOLEAN     NOT NULL DEFAULT FALSE,
+  not_modified   BOOLEAN     NOT NULL DEFAULT FALSE,
+  title          TEXT        NOT NULL DEFAULT '',
+  description    TEXT        NOT NULL DEFAULT '',
+  canonical_url  TEXT        NOT NULL DEFAULT '',
+  language       VARCHAR(35) NOT NULL DEFAULT '',
+  content        TEXT        NOT NULL DEFAULT '',
+  content_hash   VARCHAR(64) NOT NULL DEFAULT '',
+  etag           TEXT,
+  last_modified  TEXT,
+  links          JSONB       NOT NULL DEFAULT '[]',
+  created_at     TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (job_id, url)
+);
@@ -0,0 +1,13 @@
+-- Migration: Stored curated answer embeddings
+-- The phrasings of a curated answer are embedded once when the entry is saved
+-- and stored in the vector store under the owner's curated namespace, so a
+-- chat message embeds only the query. revision counts saves of an entry;
+-- embedded_revision is the revision whose phrasings are in the vector store,
+-- and entries where the two differ are indexed in the background.
+
+ALTER TABLE curated_answers
+ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 1,
+ADD COLUMN IF NOT EXISTS embedded_revision INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_curated_answers_unembedded
+  ON curated_answers(id) WHERE embedded_revision IS DISTINCT FROM revision;
@@ -0,0 +1,237 @@
+// Package chunker splits documents into retrieval chunks that respect both a
+// token budget and the document's structure: chunks never span two sections,
+// lists and tables are split between rows rather than mid-row, and every chunk
+// remembers the heading path it came from.
+package chunker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	DefaultMaxTokens     = 400
+	DefaultOverlapTokens = 50
+	MinMaxTokens         = 100
+	MaxMaxTokens         = 2000
+)
+
+// Options control chunk sizing. A zero MaxTokens and a nil OverlapTokens
+// select the defaults; an OverlapTokens of 0 turns overlap off.
+type Options struct {
+	MaxTokens     int  `json:"maxTokens"`
+	OverlapTokens *int `json:"overlapTokens"`
+}
+
+// Validate reports settings outside the supported range. Unset values are
+// valid and mean "use the default".
+func (o Options) Validate() error {
+	if o.MaxTokens != 0 && (o.MaxTokens < MinMaxTokens || o.MaxTokens > MaxMaxTokens) {
+		return fmt.Errorf("maxTokens must be between %d and %d", MinMaxTokens, MaxMaxTokens)
+	}
+	max := o.MaxTokens
+	if max == 0 {
+		max = DefaultMaxTokens
+	}
+	if o.OverlapTokens != nil && (*o.OverlapTokens < 0 || *o.OverlapTokens > max/2) {
+		return fmt.Errorf("overlapTokens must be between 0 and %d", max/2)
+	}
+	return nil
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxTokens <= 0 {
+		o.MaxTokens = DefaultMaxTokens
+	}
+	if o.MaxTokens < MinMaxTokens {
+		o.MaxTokens = MinMaxTokens
+	}
+	if o.MaxTokens > MaxMaxTokens {
+		o.MaxTokens = MaxMaxTokens
+	}
+	overlap := DefaultOverlapTokens
+	if o.OverlapTokens != nil {
+		overlap = *o.OverlapTokens
+	}
+	overlap = min(max(overlap, 0), o.MaxTokens/2)
+	o.OverlapTokens = &overlap
+	return o
+}
+
+// Chunk is one retrieval unit.
+type Chunk struct {
+	Text        string
+	HeadingPath []string
+	Tokens      int
+}
+
+// ContextualText prefixes the chunk with its heading path, which gives both
+// the embedding model and the answering model the section context.
+func (c Chunk) ContextualText() string {
+	if len(c.HeadingPath) == 0 {
+		return c.Text
+	}
+	return strings.Join(c.HeadingPath, " > ") + "\n\n" + c.Text
+}
+
+// SplitHTML converts HTML to Markdown-like text and splits it by section.
+func SplitHTML(raw string, opts Options) []Chunk {
+	return SplitMarkdown(HTMLToMarkdown(raw), opts)
+}
+
+// SplitMarkdown splits Markdown (or plain text, which is treated as a single
+// untitled section of paragraphs) into chunks. The heading path counts toward
+// MaxTokens so ContextualText stays within budget.
+func SplitMarkdown(text string, opts Options) []Chunk {
+	opts = opts.withDefaults()
+	overlap := *opts.OverlapTokens
+	var chunks []Chunk
+	for _, sec := range parseMarkdown(text) {
+		budget := opts.MaxTokens - CountTokens(strings.Join(sec.path, " > "))
+		if budget < MinMaxTokens/2 {
+			budget = MinMaxTokens / 2
+		}
+		splitBudget := budget - overlap
+		if splitBudget < budget/2 {
+			splitBudget = budget / 2
+		}
+		pieces := make([]string, 0, len(sec.blocks))
+		for _, b := range sec.blocks {
+			if CountTokens(b.text) <= budget {
+				pieces = append(pieces, b.text)
+				continue
+			}
+			// Leave room for the overlap carried in from the previous piece.
+			pieces = append(pieces, splitBlock(b, splitBudget)...)
+		}
+		for _, text := range packSection(pieces, budget, overlap) {
+			chunks = append(chunks, Chunk{
+				Text:        text,
+				HeadingPath: append([]string(nil), sec.path...),
+				Tokens:      CountTokens(text),
+			})
+		}
+	}
+	return chunks
+}
+
+// packSection greedily joins a section's pieces into chunks. Every chunk after
+// the first starts with the tail of its predecessor when that still fits.
+func packSection(pieces []string, budget, overlap int) []string {
+	var out []string
+	var current []string
+	used := 0
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		out = append(out, strings.Join(current, "\n\n"))
+		current, used = nil, 0
+	}
+	for _, piece := range pieces {
+		cost := CountTokens(piece)
+		if used > 0 && used+cost > budget {
+			prev := strings.Fields(strings.Join(current, " "))
+			flush()
+			if tail := tailWords(prev, overlap); len(tail) > 0 {
+				tailText := strings.Join(tail, " ")
+				if tailCost := CountTokens(tailText); tailCost+cost <= budget {
+					current = append(current, "… "+tailText)
+					used = tailCost + 1
+				}
+			}
+		}
+		current = append(current, piece)
+		used += cost
+	}
+	flush()
+	return out
+}
+
+var tableSeparatorPattern = regexp.MustCompile(`^\|?[\s:|-]+\|?$`)
+
+// splitBlock breaks one oversized block at its natural boundaries: rows for
+// tables (repeating the header), lines for lists and code, sentences for prose.
+func splitBlock(b block, budget int) []string {
+	switch b.kind {
+	case blockTable:
+		header := []string{}
+		rows := b.lines
+		if len(rows) > 1 && tableSeparatorPattern.MatchString(rows[1]) {
+			header, rows = rows[:2], rows[2:]
+		} else if len(rows) > 0 {
+			header, rows = rows[:1], rows[1:]
+		}
+		prefix := strings.Join(header, "\n")
+		return packUnits(rows, "\n", budget-CountTokens(prefix), prefix)
+	case blockList, blockCode:
+		return packUnits(b.lines, "\n", budget, "")
+	default:
+		return packUnits(splitSentences(b.text), " ", budget, "")
+	}
+}
+
+// packUnits joins units with sep into pieces of at most budget tokens, each
+// starting with prefix. Units that are too large on their own fall back to
+// word-level splitting.
+func packUnits(units []string, sep string, budget int, prefix string) []string {
+	if budget < 1 {
+		budget = 1
+	}
+	var out []string
+	var current []string
+	used := 0
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		text := strings.Join(current, sep)
+		if prefix != "" {
+			text = prefix + "\n" + text
+		}
+		out = append(out, text)
+		current, used = nil, 0
+	}
+	for _, unit := range units {
+		cost := CountTokens(unit)
+		if cost > budget {
+			flush()
+			words := strings.Fields(unit)
+			for _, piece := range packUnits(words, " ", budget, "") {
+				current = append(current, piece)
+				flush()
+			}
+			continue
+		}
+		if used > 0 && used+cost > budget {
+			flush()
+		}
+		current = append(current, unit)
+		used += cost
+	}
+	flush()
+	return out
+}
+
+// splitSentences splits prose after ., ! or ? followed by whitespace.
+func splitSentences(text string) []string {
+	var out []string
+	start := 0
+	runes := []rune(text)
+	for i := 0; i < len(runes)-1; i++ {
+		switch runes[i] {
+		case '.', '!', '?', '。', '！', '？':
+			if runes[i+1] == ' ' || runes[i+1] == '\n' {
+				if s := strings.TrimSpace(string(runes[start : i+1])); s != "" {
+					out = append(out, s)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if s := strings.TrimSpace(string(runes[start:])); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
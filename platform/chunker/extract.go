@@ -0,0 +1,385 @@
+package chunker
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Page is an HTML document reduced to its main content and metadata.
+type Page struct {
+	Title       string
+	Description string
+	// CanonicalURL is the rel=canonical href as written, possibly relative.
+	CanonicalURL string
+	Language     string
+	Markdown     string
+}
+
+var (
+	// cookieHintPattern marks consent and cookie banners, which are dropped
+	// whatever else their class says.
+	cookieHintPattern = regexp.MustCompile(`(?i)cookie|consent|gdpr|onetrust`)
+	// boilerplateHintPattern marks page chrome by class or id.
+	boilerplateHintPattern = regexp.MustCompile(`(?i)newsletter|\b(?:nav|navbar|navigation|breadcrumbs?|footer|sidebar|social|share|sharing|subscribe|popup|modal|advert|ads?|promo|related|comments?|skip-link)\b`)
+	// contentHintPattern keeps an element whose class or id also names content,
+	// e.g. "post-sidebar article-content".
+	contentHintPattern = regexp.MustCompile(`(?i)\b(?:content|article|main|post|entry|story|prose)\b`)
+	hiddenStylePattern = regexp.MustCompile(`(?i)display\s*:\s*none|visibility\s*:\s*hidden`)
+	languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,8}(?:[-_][A-Za-z0-9]{1,8}){0,3}$`)
+
+	boilerplateRoles = map[string]bool{
+		"navigation": true, "banner": true, "contentinfo": true, "complementary": true,
+		"search": true, "menu": true, "menubar": true, "dialog": true, "alertdialog": true,
+	}
+)
+
+const (
+	// minParagraphChars is the shortest text block that counts as content.
+	minParagraphChars = 25
+	// mainContentShare is the share of the page's content score the chosen
+	// container must hold; landing pages whose content is spread over many
+	// sections keep the whole body.
+	mainContentShare = 0.66
+	// maxLinkDensity is the share of link text above which a list or block
+	// inside the main content is treated as a link list and dropped.
+	maxLinkDensity = 0.8
+)
+
+// ExtractPage parses an HTML document, drops the page chrome around its main
+// content (navigation, site header and footer, sidebars, cookie banners and
+// hidden elements), and renders what is left as Markdown with HTMLToMarkdown's
+// headings, lists and tables. The title, meta description, canonical URL and
+// language come from the document head.
+func ExtractPage(raw string) Page {
+	doc, err := html.Parse(strings.NewReader(raw))
+	if err != nil {
+		return Page{}
+	}
+	page := pageMetadata(doc)
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return page
+	}
+
+	stats := measureText(body)
+	pruneBoilerplate(body, stats, stats[body].text)
+	root := mainContent(body)
+	dropLinkLists(root)
+
+	var sb strings.Builder
+	if !containsElement(root, atom.H1) {
+		// The page heading often sits above the article container.
+		if h1 := findElement(body, atom.H1); h1 != nil {
+			renderMarkdown(&sb, h1)
+		}
+	}
+	renderMarkdown(&sb, root)
+	page.Markdown = tidyMarkdown(sb.String())
+	if page.Title == "" {
+		if h1 := findElement(body, atom.H1); h1 != nil {
+			page.Title = nodeText(h1)
+		}
+	}
+	return page
+}
+
+// tidyMarkdown trims every line and collapses runs of blank lines.
+func tidyMarkdown(text string) string {
+	lines := strings.Split(blankLinesPattern.ReplaceAllString(text, "\n\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+func pageMetadata(doc *html.Node) Page {
+	var page Page
+	var ogTitle, ogDescription string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Html:
+				page.Language = strings.TrimSpace(attr(n, "lang"))
+			case atom.Title:
+				if page.Title == "" {
+					page.Title = nodeText(n)
+				}
+				return
+			case atom.Meta:
+				content := strings.Join(strings.Fields(attr(n, "content")), " ")
+				switch strings.ToLower(attr(n, "name")) {
+				case "description":
+					page.Description = content
+				}
+				switch strings.ToLower(attr(n, "property")) {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				}
+				if strings.EqualFold(attr(n, "http-equiv"), "content-language") && page.Language == "" {
+					page.Language = strings.TrimSpace(strings.Split(content, ",")[0])
+				}
+			case atom.Link:
+				for _, rel := range strings.Fields(attr(n, "rel")) {
+					if strings.EqualFold(rel, "canonical") && page.CanonicalURL == "" {
+						page.CanonicalURL = strings.TrimSpace(attr(n, "href"))
+					}
+				}
+			case atom.Body:
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	if page.Title == "" {
+		page.Title = ogTitle
+	}
+	if page.Description == "" {
+		page.Description = ogDescription
+	}
+	if !languageTagPattern.MatchString(page.Language) {
+		page.Language = ""
+	}
+	return page
+}
+
+type textStats struct {
+	text int // characters of visible text
+	link int // characters of that text inside links
+}
+
+func (s textStats) linkDensity() float64 {
+	if s.text == 0 {
+		return 0
+	}
+	return float64(s.link) / float64(s.text)
+}
+
+// measureText counts the visible text under every element of the tree.
+func measureText(root *html.Node) map[*html.Node]textStats {
+	stats := map[*html.Node]textStats{}
+	var walk func(n *html.Node, inLink bool) textStats
+	walk = func(n *html.Node, inLink bool) textStats {
+		var s textStats
+		switch n.Type {
+		case html.TextNode:
+			chars := utf8.RuneCountInString(strings.Join(strings.Fields(n.Data), " "))
+			s.text = chars
+			if inLink {
+				s.link = chars
+			}
+			return s
+		case html.ElementNode:
+			switch n.DataAtom {
+			case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg:
+				stats[n] = s
+				return s
+			case atom.A:
+				inLink = true
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			cs := walk(c, inLink)
+			s.text += cs.text
+			s.link += cs.link
+		}
+		stats[n] = s
+		return s
+	}
+	walk(root, false)
+	return stats
+}
+
+// pruneBoilerplate removes page chrome from the tree. Elements flagged only by
+// their class or id are kept when they hold at least half of the page's text
+// and are not mostly links, since wrappers such as "has-sidebar" match too.
+func pruneBoilerplate(n *html.Node, stats map[*html.Node]textStats, pageText int) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && isBoilerplate(c, stats[c], pageText) {
+			n.RemoveChild(c)
+		} else {
+			pruneBoilerplate(c, stats, pageText)
+		}
+		c = next
+	}
+}
+
+func isBoilerplate(n *html.Node, s textStats, pageText int) bool {
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg, atom.Iframe,
+		atom.Object, atom.Canvas, atom.Nav, atom.Footer, atom.Aside, atom.Dialog,
+		atom.Button, atom.Select, atom.Input, atom.Textarea:
+		return true
+	case atom.Form:
+		// Some frameworks wrap the whole page in one form.
+		return s.text*2 < pageText
+	case atom.Header:
+		return !hasAncestor(n, atom.Article, atom.Main)
+	case atom.Main, atom.Article:
+		return false
+	}
+	if _, hidden := attrValue(n, "hidden"); hidden || strings.EqualFold(attr(n, "aria-hidden"), "true") ||
+		hiddenStylePattern.MatchString(attr(n, "style")) {
+		return true
+	}
+	if boilerplateRoles[strings.ToLower(strings.TrimSpace(attr(n, "role")))] {
+		return true
+	}
+	hints := attr(n, "class") + " " + attr(n, "id")
+	if cookieHintPattern.MatchString(hints) {
+		return true
+	}
+	if boilerplateHintPattern.MatchString(hints) && !contentHintPattern.MatchString(hints) {
+		return s.text*2 < pageText || s.linkDensity() > 0.5
+	}
+	return false
+}
+
+// mainContent picks the element that holds the page's main content: a single
+// <main> or <article> when it carries a third of the text, else the deepest
+// element holding mainContentShare of the paragraph score, in the manner of
+// Readability. A paragraph scores by length and commas, less its link share.
+func mainContent(body *html.Node) *html.Node {
+	stats := measureText(body)
+	for _, a := range []atom.Atom{atom.Main, atom.Article} {
+		if found := findElements(body, a); len(found) == 1 && stats[found[0]].text*3 >= stats[body].text {
+			return found[0]
+		}
+	}
+
+	scores := map[*html.Node]float64{}
+	var total float64
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		switch n.DataAtom {
+		case atom.P, atom.Pre, atom.Td, atom.Blockquote, atom.Li, atom.Dd:
+			s := stats[n]
+			if s.text < minParagraphChars {
+				return
+			}
+			score := 1 + float64(strings.Count(textContent(n), ",")) + min(float64(s.text)/100, 3)
+			score *= 1 - s.linkDensity()
+			total += score
+			for p := n; p != nil && p != body.Parent; p = p.Parent {
+				scores[p] += score
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(body)
+	if total == 0 {
+		return body
+	}
+
+	root := body
+	for {
+		var best *html.Node
+		for c := root.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (best == nil || scores[c] > scores[best]) {
+				best = c
+			}
+		}
+		if best == nil || scores[best] < total*mainContentShare {
+			return root
+		}
+		root = best
+	}
+}
+
+// dropLinkLists removes lists and blocks inside the main content that are
+// almost all links, such as related-post lists and in-article menus.
+func dropLinkLists(root *html.Node) {
+	stats := measureText(root)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if c.Type == html.ElementNode {
+				switch c.DataAtom {
+				case atom.Ul, atom.Ol, atom.Div, atom.Section:
+					if s := stats[c]; s.text > 0 && s.linkDensity() > maxLinkDensity {
+						n.RemoveChild(c)
+						c = next
+						continue
+					}
+				}
+				walk(c)
+			}
+			c = next
+		}
+	}
+	walk(root)
+}
+
+func attr(n *html.Node, key string) string {
+	v, _ := attrValue(n, key)
+	return v
+}
+
+func attrValue(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func hasAncestor(n *html.Node, atoms ...atom.Atom) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		for _, a := range atoms {
+			if p.Type == html.ElementNode && p.DataAtom == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func findElements(n *html.Node, a atom.Atom) []*html.Node {
+	var out []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.DataAtom == a || (a == atom.Main && strings.EqualFold(attr(n, "role"), "main"))) {
+			out = append(out, n)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return out
+}
+
+func containsElement(n *html.Node, a atom.Atom) bool {
+	return findElement(n, a) != nil
+}
@@ -0,0 +1,164 @@
+package chunker
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+
+// HTMLToMarkdown renders the visible text of an HTML document as Markdown:
+// headings become ATX headings, list items become "- " lines, tables become
+// pipe tables and block elements become paragraphs. Scripts, styles and other
+// non-content elements are dropped.
+func HTMLToMarkdown(raw string) string {
+	doc, err := html.Parse(strings.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	renderMarkdown(&sb, doc)
+	return tidyMarkdown(sb.String())
+}
+
+func renderMarkdown(sb *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		writeInline(sb, n.Data)
+		return
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg,
+			atom.Iframe, atom.Head, atom.Object, atom.Canvas:
+			return
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			if title := nodeText(n); title != "" {
+				level := int(n.Data[1] - '0')
+				sb.WriteString("\n\n" + strings.Repeat("#", level) + " " + title + "\n\n")
+			}
+			return
+		case atom.Li:
+			sb.WriteString("\n- ")
+			renderChildren(sb, n)
+			return
+		case atom.Table:
+			renderTable(sb, n)
+			return
+		case atom.Pre:
+			if code := strings.Trim(textContent(n), "\n"); strings.TrimSpace(code) != "" {
+				sb.WriteString("\n\n```\n" + code + "\n```\n\n")
+			}
+			return
+		case atom.Br:
+			sb.WriteString("\n")
+			return
+		case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Header,
+			atom.Footer, atom.Nav, atom.Aside, atom.Blockquote, atom.Ul, atom.Ol,
+			atom.Dl, atom.Dt, atom.Dd, atom.Figure, atom.Figcaption, atom.Hr,
+			atom.Form, atom.Fieldset, atom.Address, atom.Details, atom.Summary:
+			sb.WriteString("\n\n")
+			renderChildren(sb, n)
+			sb.WriteString("\n\n")
+			return
+		}
+	}
+	renderChildren(sb, n)
+}
+
+func renderChildren(sb *strings.Builder, n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		renderMarkdown(sb, child)
+	}
+}
+
+// writeInline appends collapsed text, keeping a single space between runs.
+func writeInline(sb *strings.Builder, text string) {
+	collapsed := strings.Join(strings.Fields(text), " ")
+	if collapsed == "" {
+		if text != "" {
+			sb.WriteString(" ")
+		}
+		return
+	}
+	if text[0] == ' ' || text[0] == '\n' || text[0] == '\t' {
+		sb.WriteString(" ")
+	}
+	sb.WriteString(collapsed)
+	if last := text[len(text)-1]; last == ' ' || last == '\n' || last == '\t' {
+		sb.WriteString(" ")
+	}
+}
+
+// renderTable writes a pipe table, using the first row as the header.
+func renderTable(sb *strings.Builder, table *html.Node) {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Tr {
+			var cells []string
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.ElementNode && (c.DataAtom == atom.Td || c.DataAtom == atom.Th) {
+					cells = append(cells, strings.ReplaceAll(nodeText(c), "|", "/"))
+				}
+			}
+			if len(cells) > 0 {
+				rows = append(rows, cells)
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && c.DataAtom == atom.Table {
+				continue
+			}
+			walk(c)
+		}
+	}
+	walk(table)
+	if len(rows) == 0 {
+		return
+	}
+	sb.WriteString("\n\n")
+	for i, cells := range rows {
+		sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		if i == 0 {
+			sep := make([]string, len(cells))
+			for j := range sep {
+				sep[j] = "---"
+			}
+			sb.WriteString("| " + strings.Join(sep, " | ") + " |\n")
+		}
+	}
+	sb.WriteString("\n")
+}
+
+// nodeText returns the whitespace-collapsed text of n and its descendants.
+func nodeText(n *html.Node) string {
+	return strings.Join(strings.Fields(textContent(n)), " ")
+}
+
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg:
+				return
+			case atom.Br:
+				sb.WriteString("\n")
+				return
+			}
+		}
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
@@ -0,0 +1,132 @@
+package chunker
+
+import (
+	"regexp"
+	"strings"
+)
+
+type blockKind int
+
+const (
+	blockParagraph blockKind = iota
+	blockList
+	blockTable
+	blockCode
+)
+
+type block struct {
+	kind  blockKind
+	lines []string
+	text  string
+}
+
+type section struct {
+	path   []string
+	blocks []block
+}
+
+var (
+	mdHeadingPattern  = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+	mdListItemPattern = regexp.MustCompile(`^\s*(?:[-*+•]|\d{1,3}[.)])\s+`)
+)
+
+// parseMarkdown groups text into sections (split at headings) made of
+// paragraph, list, table and fenced-code blocks.
+func parseMarkdown(text string) []section {
+	type heading struct {
+		level int
+		title string
+	}
+	var stack []heading
+	var sections []section
+	cur := section{}
+	var blk *block
+
+	flushBlock := func() {
+		if blk == nil {
+			return
+		}
+		if blk.kind == blockParagraph {
+			blk.text = strings.Join(strings.Fields(strings.Join(blk.lines, " ")), " ")
+		} else {
+			blk.text = strings.Join(blk.lines, "\n")
+		}
+		if strings.TrimSpace(blk.text) != "" {
+			cur.blocks = append(cur.blocks, *blk)
+		}
+		blk = nil
+	}
+	flushSection := func() {
+		flushBlock()
+		if len(cur.blocks) > 0 {
+			sections = append(sections, cur)
+		}
+	}
+	startBlock := func(kind blockKind) {
+		if blk != nil && blk.kind == kind {
+			return
+		}
+		flushBlock()
+		blk = &block{kind: kind}
+	}
+
+	inFence := false
+	fence := ""
+	for _, rawLine := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := strings.TrimRight(rawLine, " \t")
+		trimmed := strings.TrimSpace(line)
+
+		if inFence {
+			if strings.HasPrefix(trimmed, fence) {
+				blk.lines = append(blk.lines, trimmed)
+				flushBlock()
+				inFence = false
+				continue
+			}
+			blk.lines = append(blk.lines, line)
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			flushBlock()
+			blk = &block{kind: blockCode, lines: []string{trimmed}}
+			fence = trimmed[:3]
+			inFence = true
+			continue
+		}
+		if m := mdHeadingPattern.FindStringSubmatch(trimmed); m != nil {
+			flushSection()
+			level := len(m[1])
+			for len(stack) > 0 && stack[len(stack)-1].level >= level {
+				stack = stack[:len(stack)-1]
+			}
+			stack = append(stack, heading{level: level, title: strings.Join(strings.Fields(m[2]), " ")})
+			path := make([]string, len(stack))
+			for i, h := range stack {
+				path[i] = h.title
+			}
+			cur = section{path: path}
+			continue
+		}
+		switch {
+		case trimmed == "":
+			flushBlock()
+		case strings.HasPrefix(trimmed, "|"):
+			startBlock(blockTable)
+			blk.lines = append(blk.lines, trimmed)
+		case mdListItemPattern.MatchString(line):
+			startBlock(blockList)
+			blk.lines = append(blk.lines, trimmed)
+		case blk != nil && blk.kind == blockList && line != trimmed:
+			// Indented continuation of the previous list item.
+			blk.lines[len(blk.lines)-1] += " " + trimmed
+		default:
+			startBlock(blockParagraph)
+			blk.lines = append(blk.lines, trimmed)
+		}
+	}
+	if inFence {
+		flushBlock()
+	}
+	flushSection()
+	return sections
+}
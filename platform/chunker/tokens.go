@@ -0,0 +1,58 @@
+package chunker
+
+import "unicode"
+
+// CountTokens approximates the token count of s for BPE tokenizers such as
+// cl100k: roughly one token per four characters of a word, one per
+// punctuation mark and one per CJK character. It errs slightly high, which is
+// the safe direction when staying under model limits.
+func CountTokens(s string) int {
+	tokens := 0
+	wordRunes := 0
+	flush := func() {
+		if wordRunes > 0 {
+			tokens += (wordRunes + 3) / 4
+			wordRunes = 0
+		}
+	}
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case isCJK(r):
+			flush()
+			tokens++
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			wordRunes++
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// tailWords returns the trailing words of words worth at most max tokens; it
+// is the overlap carried into the next chunk of a section.
+func tailWords(words []string, max int) []string {
+	if max <= 0 {
+		return nil
+	}
+	total := 0
+	start := len(words)
+	for start > 0 {
+		cost := CountTokens(words[start-1])
+		if total+cost > max {
+			break
+		}
+		total += cost
+		start--
+	}
+	return words[start:]
+}
@@ -0,0 +1,245 @@
+// Package crawler holds the site policy parts of website scraping: robots.txt
+// rules (RFC 9309, plus the common Crawl-delay extension) and sitemap and
+// sitemap-index parsing. Fetching stays with the caller, which owns the HTTP
+// client and the outbound URL checks.
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ProductToken is the name robots.txt groups are matched against.
+	ProductToken = "KonvoqCrawler"
+	// UserAgent is sent with every crawler request.
+	UserAgent = ProductToken + "/1.0"
+	// MaxRobotsBytes is how much of a robots.txt file is parsed; RFC 9309
+	// requires at least 500 KiB.
+	MaxRobotsBytes = 500 << 10
+)
+
+// Robots is the parsed robots.txt of one host, reduced to the group that
+// applies to ProductToken.
+type Robots struct {
+	rules       []robotsRule
+	delay       time.Duration
+	sitemaps    []string
+	disallowAll bool
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	match   *regexp.Regexp
+}
+
+// AllowAll is the policy for hosts without a robots.txt (a 4xx response).
+func AllowAll() *Robots {
+	return &Robots{}
+}
+
+// DisallowAll is the policy for hosts whose robots.txt could not be fetched
+// because of a server or network error, as RFC 9309 requires.
+func DisallowAll() *Robots {
+	return &Robots{disallowAll: true}
+}
+
+type robotsGroup struct {
+	agents   []string
+	rules    []robotsRule
+	delay    time.Duration
+	hasDelay bool
+}
+
+// ParseRobots parses a robots.txt body. The groups naming productToken are
+// merged and used; without one, the "*" groups are. Sitemap lines apply
+// regardless of group.
+func ParseRobots(body []byte, productToken string) *Robots {
+	if len(body) > MaxRobotsBytes {
+		body = body[:MaxRobotsBytes]
+	}
+	robots := &Robots{}
+	var groups []*robotsGroup
+	var current *robotsGroup
+	inRules := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 4096), MaxRobotsBytes)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share one group; a user-agent
+			// after rules starts the next.
+			if current == nil || inRules {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+				inRules = false
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if current == nil {
+				continue
+			}
+			inRules = true
+			if value == "" {
+				// "Disallow:" with no path allows everything.
+				continue
+			}
+			current.rules = append(current.rules, newRobotsRule(key == "allow", value))
+		case "crawl-delay":
+			if current == nil {
+				continue
+			}
+			inRules = true
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.delay = time.Duration(seconds * float64(time.Second))
+				current.hasDelay = true
+			}
+		case "sitemap":
+			if u, err := url.Parse(value); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+				robots.sitemaps = append(robots.sitemaps, value)
+			}
+		}
+	}
+
+	token := strings.ToLower(productToken)
+	selected := selectRobotsGroups(groups, func(agent string) bool { return agentToken(agent) == token })
+	if len(selected) == 0 {
+		selected = selectRobotsGroups(groups, func(agent string) bool { return agent == "*" })
+	}
+	for _, g := range selected {
+		robots.rules = append(robots.rules, g.rules...)
+		if g.hasDelay && g.delay > robots.delay {
+			robots.delay = g.delay
+		}
+	}
+	return robots
+}
+
+func selectRobotsGroups(groups []*robotsGroup, matches func(string) bool) []*robotsGroup {
+	var out []*robotsGroup
+	for _, g := range groups {
+		for _, agent := range g.agents {
+			if matches(agent) {
+				out = append(out, g)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// agentToken is the product token at the start of a user-agent value, so
+// "KonvoqCrawler/1.0" matches a group for "konvoqcrawler".
+func agentToken(agent string) string {
+	end := strings.IndexFunc(agent, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	})
+	if end >= 0 {
+		return agent[:end]
+	}
+	return agent
+}
+
+// newRobotsRule compiles a path pattern, where "*" matches any characters and
+// a trailing "$" anchors the end of the path.
+func newRobotsRule(allow bool, pattern string) robotsRule {
+	rule := robotsRule{allow: allow, pattern: pattern}
+	if !strings.ContainsAny(pattern, "*$") {
+		return rule
+	}
+	expr := pattern
+	anchored := strings.HasSuffix(expr, "$")
+	expr = strings.TrimSuffix(expr, "$")
+	parts := strings.Split(expr, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	source := "^" + strings.Join(parts, ".*")
+	if anchored {
+		source += "$"
+	}
+	rule.match = regexp.MustCompile(source)
+	return rule
+}
+
+func (r robotsRule) matches(path string) bool {
+	if r.match != nil {
+		return r.match.MatchString(path)
+	}
+	return strings.HasPrefix(path, r.pattern)
+}
+
+// Allowed reports whether u may be crawled. The longest matching rule wins
+// and Allow wins ties; /robots.txt itself is always allowed.
+func (r *Robots) Allowed(u *url.URL) bool {
+	if r == nil {
+		return true
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+	if r.disallowAll {
+		return false
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	decoded := unescapeRobotsPath(path)
+	best, allowed := -1, true
+	for _, rule := range r.rules {
+		if !rule.matches(path) && !rule.matches(decoded) {
+			continue
+		}
+		length := len(rule.pattern)
+		if length > best || (length == best && rule.allow) {
+			best, allowed = length, rule.allow
+		}
+	}
+	return allowed
+}
+
+// unescapeRobotsPath decodes percent-escapes so rules written either way
+// match, e.g. "/caf%C3%A9" and "/café".
+func unescapeRobotsPath(path string) string {
+	if decoded, err := url.PathUnescape(path); err == nil {
+		return decoded
+	}
+	return path
+}
+
+// CrawlDelay is the pause the host asks for between requests, or 0.
+func (r *Robots) CrawlDelay() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return r.delay
+}
+
+// Sitemaps are the sitemap URLs the robots.txt lists.
+func (r *Robots) Sitemaps() []string {
+	if r == nil {
+		return nil
+	}
+	return r.sitemaps
+}
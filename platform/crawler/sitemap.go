@@ -0,0 +1,158 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxSitemapBytes bounds one sitemap file after decompression; the
+	// sitemaps protocol allows 50 MB.
+	MaxSitemapBytes = 50 << 20
+	// MaxSitemapEntries is the protocol's per-file URL limit.
+	MaxSitemapEntries = 50000
+)
+
+// SitemapEntry is one <url> of a urlset or one <sitemap> of a sitemap index.
+type SitemapEntry struct {
+	Loc      string
+	LastMod  time.Time
+	Priority float64
+}
+
+// Sitemap is a parsed sitemap file: page URLs for a urlset, or child sitemaps
+// for a sitemap index.
+type Sitemap struct {
+	URLs     []SitemapEntry
+	Children []SitemapEntry
+}
+
+type sitemapXMLEntry struct {
+	Loc      string `xml:"loc"`
+	LastMod  string `xml:"lastmod"`
+	Priority string `xml:"priority"`
+}
+
+// ParseSitemap reads an XML sitemap or sitemap index, gzipped or not, or a
+// plain-text sitemap with one URL per line.
+func ParseSitemap(r io.Reader) (*Sitemap, error) {
+	br := bufio.NewReader(io.LimitReader(r, MaxSitemapBytes))
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(io.LimitReader(gz, MaxSitemapBytes))
+	}
+	head, _ := br.Peek(512)
+	if trimmed := bytes.TrimLeft(head, " \t\r\n\ufeff"); len(trimmed) > 0 && trimmed[0] != '<' {
+		return parseTextSitemap(br), nil
+	}
+
+	sitemap := &Sitemap{}
+	decoder := xml.NewDecoder(br)
+	decoder.Strict = false
+	for {
+		tok, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if len(sitemap.URLs) > 0 || len(sitemap.Children) > 0 {
+				// Keep what was read before a truncated or broken tail.
+				break
+			}
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || (start.Name.Local != "url" && start.Name.Local != "sitemap") {
+			continue
+		}
+		var raw sitemapXMLEntry
+		if err := decoder.DecodeElement(&raw, &start); err != nil {
+			continue
+		}
+		entry, ok := newSitemapEntry(raw)
+		if !ok {
+			continue
+		}
+		if start.Name.Local == "url" {
+			sitemap.URLs = append(sitemap.URLs, entry)
+		} else {
+			sitemap.Children = append(sitemap.Children, entry)
+		}
+		if len(sitemap.URLs)+len(sitemap.Children) >= MaxSitemapEntries {
+			break
+		}
+	}
+	return sitemap, nil
+}
+
+func parseTextSitemap(r io.Reader) *Sitemap {
+	sitemap := &Sitemap{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() && len(sitemap.URLs) < MaxSitemapEntries {
+		if entry, ok := newSitemapEntry(sitemapXMLEntry{Loc: scanner.Text()}); ok {
+			sitemap.URLs = append(sitemap.URLs, entry)
+		}
+	}
+	return sitemap
+}
+
+func newSitemapEntry(raw sitemapXMLEntry) (SitemapEntry, bool) {
+	loc := strings.TrimSpace(raw.Loc)
+	u, err := url.Parse(loc)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return SitemapEntry{}, false
+	}
+	entry := SitemapEntry{Loc: loc, LastMod: parseLastMod(raw.LastMod)}
+	if p, err := strconv.ParseFloat(strings.TrimSpace(raw.Priority), 64); err == nil && p >= 0 && p <= 1 {
+		entry.Priority = p
+	}
+	return entry, true
+}
+
+// lastModLayouts are the W3C Datetime forms sitemaps use.
+var lastModLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+func parseLastMod(raw string) time.Time {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}
+	}
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// SortEntries orders entries most recently modified first, entries without a
+// lastmod last, then by priority, keeping file order among equals.
+func SortEntries(entries []SitemapEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if !a.LastMod.Equal(b.LastMod) {
+			return a.LastMod.After(b.LastMod)
+		}
+		return a.Priority > b.Priority
+	})
+}
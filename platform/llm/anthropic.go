@@ -0,0 +1,219 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultAnthropicBaseURL   = "https://api.anthropic.com/v1"
+	anthropicAPIVersion       = "2023-06-01"
+	defaultAnthropicMaxTokens = 1024
+)
+
+// anthropicClient targets the Anthropic Messages API. It only implements
+// Provider; Anthropic has no embeddings endpoint.
+type anthropicClient struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func newAnthropicClient(apiKey, baseURL string, client *http.Client) *anthropicClient {
+	return &anthropicClient{
+		baseURL: strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		apiKey:  strings.TrimSpace(apiKey),
+		client:  client,
+	}
+}
+
+func (a *anthropicClient) Name() string { return ProviderAnthropic }
+
+func (a *anthropicClient) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	httpReq, err := a.newRequest(ctx, anthropicPayload(req))
+	if err != nil {
+		return ChatResponse{}, err
+	}
+
+	var out struct {
+		Model   string `json:"model"`
+		Content []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+		Usage anthropicUsage `json:"usage"`
+	}
+	if err := doJSON(a.client, httpReq, ProviderAnthropic, &out); err != nil {
+		return ChatResponse{}, err
+	}
+	var sb strings.Builder
+	var calls []ToolCall
+	for _, block := range out.Content {
+		switch block.Type {
+		case "text":
+			sb.WriteString(block.Text)
+		case "tool_use":
+			calls = append(calls, ToolCall{ID: block.ID, Name: block.Name, Arguments: string(block.Input)})
+		}
+	}
+	return ChatResponse{Content: strings.TrimSpace(sb.String()), Model: coalesce(out.Model, req.Model), Usage: out.Usage.toUsage(), ToolCalls: calls}, nil
+}
+
+// ChatStream consumes the Messages streaming API, forwarding text_delta
+// fragments from content_block_delta events.
+func (a *anthropicClient) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (ChatResponse, error) {
+	payload := anthropicPayload(req)
+	payload["stream"] = true
+	httpReq, err := a.newRequest(ctx, payload)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	var sb strings.Builder
+	var usage Usage
+	model := req.Model
+	err = doStream(a.client, httpReq, ProviderAnthropic, func(event, data string) error {
+		switch event {
+		case "message_start":
+			var start struct {
+				Message struct {
+					Model string         `json:"model"`
+					Usage anthropicUsage `json:"usage"`
+				} `json:"message"`
+			}
+			if err := json.Unmarshal([]byte(data), &start); err == nil {
+				model = coalesce(start.Message.Model, model)
+				usage.PromptTokens = start.Message.Usage.InputTokens
+			}
+		case "message_delta":
+			// Carries the cumulative output token count.
+			var delta struct {
+				Usage anthropicUsage `json:"usage"`
+			}
+			if err := json.Unmarshal([]byte(data), &delta); err == nil && delta.Usage.OutputTokens > 0 {
+				usage.CompletionTokens = delta.Usage.OutputTokens
+			}
+		case "content_block_delta":
+			var chunk struct {
+				Delta struct {
+					Type string `json:"type"`
+					Text string `json:"text"`
+				} `json:"delta"`
+			}
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return err
+			}
+			if chunk.Delta.Type != "text_delta" || chunk.Delta.Text == "" {
+				return nil
+			}
+			sb.WriteString(chunk.Delta.Text)
+			return onDelta(chunk.Delta.Text)
+		case "message_stop":
+			return errStreamDone
+		case "error":
+			return &StatusError{Provider: ProviderAnthropic, StatusCode: http.StatusBadGateway, Body: data}
+		}
+		return nil
+	})
+	return ChatResponse{Content: strings.TrimSpace(sb.String()), Model: model, Usage: usage}, err
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+func (u anthropicUsage) toUsage() Usage {
+	return Usage{PromptTokens: u.InputTokens, CompletionTokens: u.OutputTokens}
+}
+
+func (a *anthropicClient) newRequest(ctx context.Context, payload map[string]interface{}) (*http.Request, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/messages", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-api-key", a.apiKey)
+	req.Header.Set("anthropic-version", anthropicAPIVersion)
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// anthropicPayload lifts system messages into the top-level "system" field,
+// which is where the Messages API expects them.
+func anthropicPayload(req ChatRequest) map[string]interface{} {
+	system := make([]string, 0, 1)
+	messages := make([]map[string]interface{}, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		messages = appendAnthropicMessage(messages, m)
+	}
+	maxTokens := req.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultAnthropicMaxTokens
+	}
+	payload := map[string]interface{}{
+		"model":      req.Model,
+		"messages":   messages,
+		"max_tokens": maxTokens,
+	}
+	if len(system) > 0 {
+		payload["system"] = strings.Join(system, "\n\n")
+	}
+	if len(req.Tools) > 0 {
+		tools := make([]map[string]interface{}, 0, len(req.Tools))
+		for _, t := range req.Tools {
+			tools = append(tools, map[string]interface{}{"name": t.Name, "description": t.Description, "input_schema": t.Parameters})
+		}
+		payload["tools"] = tools
+	}
+	if req.Temperature != nil {
+		// Anthropic accepts 0-1; OpenAI-style values up to 2 are clamped.
+		payload["temperature"] = math.Min(*req.Temperature, 1)
+	}
+	return payload
+}
+
+// appendAnthropicMessage converts m to the Messages API shape: tool calls
+// become tool_use blocks and tool results become tool_result blocks in a user
+// turn, merged with the results right before them.
+func appendAnthropicMessage(messages []map[string]interface{}, m Message) []map[string]interface{} {
+	switch {
+	case m.Role == "tool":
+		block := map[string]interface{}{"type": "tool_result", "tool_use_id": m.ToolCallID, "content": m.Content}
+		if n := len(messages); n > 0 && messages[n-1]["role"] == "user" {
+			if blocks, ok := messages[n-1]["content"].([]map[string]interface{}); ok {
+				messages[n-1]["content"] = append(blocks, block)
+				return messages
+			}
+		}
+		return append(messages, map[string]interface{}{"role": "user", "content": []map[string]interface{}{block}})
+	case len(m.ToolCalls) > 0:
+		blocks := make([]map[string]interface{}, 0, len(m.ToolCalls)+1)
+		if strings.TrimSpace(m.Content) != "" {
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": m.Content})
+		}
+		for _, call := range m.ToolCalls {
+			input := json.RawMessage(call.Arguments)
+			if !json.Valid(input) {
+				input = json.RawMessage("{}")
+			}
+			blocks = append(blocks, map[string]interface{}{"type": "tool_use", "id": call.ID, "name": call.Name, "input": input})
+		}
+		return append(messages, map[string]interface{}{"role": m.Role, "content": blocks})
+	default:
+		return append(messages, map[string]interface{}{"role": m.Role, "content": m.Content})
+	}
+}
@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultAzureAPIVersion = "2024-06-01"
+
+// azureClient targets Azure OpenAI, where the model is selected by deployment
+// name in the URL rather than by the "model" field in the payload.
+type azureClient struct {
+	endpoint            string
+	apiKey              string
+	apiVersion          string
+	chatDeployment      string
+	embeddingDeployment string
+	client              *http.Client
+}
+
+func newAzureClient(cfg Config, client *http.Client) *azureClient {
+	return &azureClient{
+		endpoint:            strings.TrimRight(strings.TrimSpace(cfg.AzureEndpoint), "/"),
+		apiKey:              strings.TrimSpace(cfg.AzureAPIKey),
+		apiVersion:          coalesce(cfg.AzureAPIVersion, defaultAzureAPIVersion),
+		chatDeployment:      strings.TrimSpace(cfg.AzureChatDeployment),
+		embeddingDeployment: strings.TrimSpace(cfg.AzureEmbeddingDeployment),
+		client:              client,
+	}
+}
+
+func (a *azureClient) Name() string { return ProviderAzureOpenAI }
+
+func (a *azureClient) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	payload := openAIChatPayload(req)
+	delete(payload, "model")
+	var out openAIChatResponse
+	if err := a.post(ctx, coalesce(a.chatDeployment, req.Model), "chat/completions", payload, &out); err != nil {
+		return ChatResponse{}, err
+	}
+	return out.toChatResponse(req.Model), nil
+}
+
+func (a *azureClient) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (ChatResponse, error) {
+	payload := openAIChatPayload(req)
+	delete(payload, "model")
+	payload["stream"] = true
+	httpReq, err := a.newRequest(ctx, coalesce(a.chatDeployment, req.Model), "chat/completions", payload)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	return streamOpenAIChat(a.client, httpReq, ProviderAzureOpenAI, req.Model, onDelta)
+}
+
+func (a *azureClient) Embed(ctx context.Context, req EmbeddingRequest) (EmbeddingResponse, error) {
+	payload := map[string]interface{}{"input": req.Input}
+	if req.Dimensions > 0 {
+		payload["dimensions"] = req.Dimensions
+	}
+	var out openAIEmbeddingResponse
+	if err := a.post(ctx, coalesce(a.embeddingDeployment, req.Model), "embeddings", payload, &out); err != nil {
+		return EmbeddingResponse{}, err
+	}
+	return out.toEmbeddingResponse(req.Model), nil
+}
+
+func (a *azureClient) EmbedBatch(ctx context.Context, req EmbeddingBatchRequest) (EmbeddingBatchResponse, error) {
+	payload := map[string]interface{}{"input": req.Inputs}
+	if req.Dimensions > 0 {
+		payload["dimensions"] = req.Dimensions
+	}
+	var out openAIEmbeddingResponse
+	if err := a.post(ctx, coalesce(a.embeddingDeployment, req.Model), "embeddings", payload, &out); err != nil {
+		return EmbeddingBatchResponse{}, err
+	}
+	return out.toBatchResponse(req.Model, len(req.Inputs))
+}
+
+func (a *azureClient) post(ctx context.Context, deployment, operation string, payload interface{}, out interface{}) error {
+	req, err := a.newRequest(ctx, deployment, operation, payload)
+	if err != nil {
+		return err
+	}
+	return doJSON(a.client, req, ProviderAzureOpenAI, out)
+}
+
+func (a *azureClient) newRequest(ctx context.Context, deployment, operation string, payload interface{}) (*http.Request, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := a.endpoint + "/openai/deployments/" + url.PathEscape(deployment) + "/" + operation +
+		"?api-version=" + url.QueryEscape(a.apiVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("api-key", a.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
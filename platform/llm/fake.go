@@ -0,0 +1,125 @@
+package llm
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const fakeEmbeddingDimensions = 256
+
+// Fake is an offline Provider and Embedder for local development and CI.
+// Chat echoes the last user message unless Reply is set; Embed returns a
+// normalized hashed bag-of-words vector so that texts sharing words score
+// as similar under cosine distance.
+type Fake struct {
+	Reply func(req ChatRequest) string
+}
+
+// NewFake returns a Fake with the default echo behaviour.
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+func (f *Fake) Name() string { return ProviderFake }
+
+func (f *Fake) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return ChatResponse{}, err
+	}
+	resp := ChatResponse{Model: coalesce(req.Model, ProviderFake)}
+	if f.Reply != nil {
+		resp.Content = f.Reply(req)
+	} else {
+		for i := len(req.Messages) - 1; i >= 0; i-- {
+			if req.Messages[i].Role == "user" {
+				resp.Content = strings.TrimSpace(req.Messages[i].Content)
+				break
+			}
+		}
+	}
+	for _, m := range req.Messages {
+		resp.Usage.PromptTokens += fakeTokens(m.Content)
+	}
+	resp.Usage.CompletionTokens = fakeTokens(resp.Content)
+	return resp, nil
+}
+
+// ChatStream emits the Chat reply word by word.
+func (f *Fake) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (ChatResponse, error) {
+	resp, err := f.Chat(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	words := strings.Fields(resp.Content)
+	for i, word := range words {
+		if err := ctx.Err(); err != nil {
+			return resp, err
+		}
+		if i < len(words)-1 {
+			word += " "
+		}
+		if err := onDelta(word); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+func (f *Fake) Embed(ctx context.Context, req EmbeddingRequest) (EmbeddingResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return EmbeddingResponse{}, err
+	}
+	return EmbeddingResponse{
+		Vector: fakeVector(req.Input, req.Dimensions),
+		Model:  coalesce(req.Model, ProviderFake),
+		Usage:  Usage{PromptTokens: fakeTokens(req.Input)},
+	}, nil
+}
+
+func (f *Fake) EmbedBatch(ctx context.Context, req EmbeddingBatchRequest) (EmbeddingBatchResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return EmbeddingBatchResponse{}, err
+	}
+	vectors := make([][]float64, len(req.Inputs))
+	var usage Usage
+	for i, input := range req.Inputs {
+		vectors[i] = fakeVector(input, req.Dimensions)
+		usage.PromptTokens += fakeTokens(input)
+	}
+	return EmbeddingBatchResponse{Vectors: vectors, Model: coalesce(req.Model, ProviderFake), Usage: usage}, nil
+}
+
+// fakeTokens counts one token per word, which is enough to exercise metering.
+func fakeTokens(s string) int {
+	return len(strings.Fields(s))
+}
+
+func fakeVector(input string, dimensions int) []float64 {
+	if dimensions <= 0 {
+		dimensions = fakeEmbeddingDimensions
+	}
+	vec := make([]float64, dimensions)
+	words := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, w := range words {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(w))
+		vec[h.Sum32()%uint32(dimensions)] += 1
+	}
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	if norm == 0 {
+		return vec
+	}
+	norm = math.Sqrt(norm)
+	for i := range vec {
+		vec[i] /= norm
+	}
+	return vec
+}
@@ -0,0 +1,257 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Provider names accepted by Config.Provider / Config.EmbeddingProvider.
+const (
+	ProviderOpenAI           = "openai"
+	ProviderAzureOpenAI      = "azure"
+	ProviderAnthropic        = "anthropic"
+	ProviderOpenAICompatible = "openai_compatible"
+	ProviderFake             = "fake"
+)
+
+// ErrNotConfigured is returned by New when the selected provider lacks credentials.
+var ErrNotConfigured = errors.New("llm provider is not configured")
+
+// Message is a single chat turn. Assistant turns that requested tools carry
+// ToolCalls; each result goes back in a "tool" turn naming its ToolCallID.
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"toolCalls,omitempty"`
+	ToolCallID string     `json:"toolCallId,omitempty"`
+}
+
+// Tool is a function the model may call. Parameters is a JSON Schema object
+// describing the arguments.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{}
+}
+
+// ToolCall is one function call requested by the model. Arguments is the
+// JSON object the model produced, unvalidated.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ChatRequest describes one chat completion call.
+// Zero values for Temperature/MaxTokens leave the provider default in place.
+// Tool calls are only reported by Chat; streamed requests should not carry
+// Tools.
+type ChatRequest struct {
+	Model       string
+	Messages    []Message
+	Temperature *float64
+	MaxTokens   int
+	Tools       []Tool
+}
+
+// Usage is the token accounting a provider reported for one call. Both
+// fields are zero when the provider did not report usage.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Total returns prompt plus completion tokens.
+func (u Usage) Total() int { return u.PromptTokens + u.CompletionTokens }
+
+// ChatResponse is the provider-neutral completion result. ToolCalls is set
+// when the model chose to call tools instead of, or as well as, replying.
+type ChatResponse struct {
+	Content   string
+	Model     string
+	Usage     Usage
+	ToolCalls []ToolCall
+}
+
+// EmbeddingRequest describes one embedding call.
+type EmbeddingRequest struct {
+	Model      string
+	Input      string
+	Dimensions int
+}
+
+// EmbeddingResponse holds the vector for an EmbeddingRequest.
+type EmbeddingResponse struct {
+	Vector []float64
+	Model  string
+	Usage  Usage
+}
+
+// EmbeddingBatchRequest embeds several inputs in one provider call.
+type EmbeddingBatchRequest struct {
+	Model      string
+	Inputs     []string
+	Dimensions int
+}
+
+// EmbeddingBatchResponse holds one vector per input, in input order.
+type EmbeddingBatchResponse struct {
+	Vectors [][]float64
+	Model   string
+	Usage   Usage
+}
+
+// Provider generates chat completions.
+type Provider interface {
+	Name() string
+	Chat(ctx context.Context, req ChatRequest) (ChatResponse, error)
+}
+
+// Streamer is implemented by providers that can emit a completion
+// incrementally. onDelta receives content fragments in order; returning an
+// error from it aborts the stream. The response carries the full content.
+type Streamer interface {
+	ChatStream(ctx context.Context, req ChatRequest, onDelta func(delta string) error) (ChatResponse, error)
+}
+
+// Embedder turns text into vectors.
+type Embedder interface {
+	Name() string
+	Embed(ctx context.Context, req EmbeddingRequest) (EmbeddingResponse, error)
+	EmbedBatch(ctx context.Context, req EmbeddingBatchRequest) (EmbeddingBatchResponse, error)
+}
+
+// Config selects and configures the chat and embedding backends.
+type Config struct {
+	Provider          string
+	EmbeddingProvider string
+
+	OpenAIAPIKey  string
+	OpenAIBaseURL string
+
+	AzureEndpoint            string
+	AzureAPIKey              string
+	AzureAPIVersion          string
+	AzureChatDeployment      string
+	AzureEmbeddingDeployment string
+
+	AnthropicAPIKey  string
+	AnthropicBaseURL string
+
+	CompatibleBaseURL string
+	CompatibleAPIKey  string
+
+	HTTPClient *http.Client
+}
+
+// New builds the chat provider and embedder selected by cfg.
+// A nil Provider or Embedder with a nil error means that side is disabled.
+// Request deadlines come from the caller's context, not the HTTP client.
+func New(cfg Config) (Provider, Embedder, error) {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+	}
+	chatName := normalizeProvider(cfg.Provider)
+	if chatName == "" {
+		chatName = ProviderOpenAI
+	}
+	embedName := normalizeProvider(cfg.EmbeddingProvider)
+	if embedName == "" {
+		embedName = chatName
+		if embedName == ProviderAnthropic {
+			// Anthropic has no embeddings API; fall back to OpenAI for vectors.
+			embedName = ProviderOpenAI
+		}
+	}
+
+	chat, err := newProvider(chatName, cfg, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	embedder, err := newEmbedder(embedName, cfg, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chat, embedder, nil
+}
+
+func newProvider(name string, cfg Config, client *http.Client) (Provider, error) {
+	switch name {
+	case ProviderOpenAI:
+		if strings.TrimSpace(cfg.OpenAIAPIKey) == "" {
+			return nil, nil
+		}
+		return newOpenAIClient(ProviderOpenAI, coalesce(cfg.OpenAIBaseURL, defaultOpenAIBaseURL), cfg.OpenAIAPIKey, client), nil
+	case ProviderOpenAICompatible:
+		if strings.TrimSpace(cfg.CompatibleBaseURL) == "" {
+			return nil, fmt.Errorf("%w: LLM_BASE_URL is required for %s", ErrNotConfigured, name)
+		}
+		return newOpenAIClient(ProviderOpenAICompatible, cfg.CompatibleBaseURL, cfg.CompatibleAPIKey, client), nil
+	case ProviderAzureOpenAI:
+		if strings.TrimSpace(cfg.AzureEndpoint) == "" || strings.TrimSpace(cfg.AzureAPIKey) == "" {
+			return nil, nil
+		}
+		return newAzureClient(cfg, client), nil
+	case ProviderAnthropic:
+		if strings.TrimSpace(cfg.AnthropicAPIKey) == "" {
+			return nil, nil
+		}
+		return newAnthropicClient(cfg.AnthropicAPIKey, coalesce(cfg.AnthropicBaseURL, defaultAnthropicBaseURL), client), nil
+	case ProviderFake:
+		return NewFake(), nil
+	default:
+		return nil, fmt.Errorf("unknown llm provider %q", name)
+	}
+}
+
+func newEmbedder(name string, cfg Config, client *http.Client) (Embedder, error) {
+	switch name {
+	case ProviderOpenAI:
+		if strings.TrimSpace(cfg.OpenAIAPIKey) == "" {
+			return nil, nil
+		}
+		return newOpenAIClient(ProviderOpenAI, coalesce(cfg.OpenAIBaseURL, defaultOpenAIBaseURL), cfg.OpenAIAPIKey, client), nil
+	case ProviderOpenAICompatible:
+		if strings.TrimSpace(cfg.CompatibleBaseURL) == "" {
+			return nil, fmt.Errorf("%w: LLM_BASE_URL is required for %s", ErrNotConfigured, name)
+		}
+		return newOpenAIClient(ProviderOpenAICompatible, cfg.CompatibleBaseURL, cfg.CompatibleAPIKey, client), nil
+	case ProviderAzureOpenAI:
+		if strings.TrimSpace(cfg.AzureEndpoint) == "" || strings.TrimSpace(cfg.AzureAPIKey) == "" {
+			return nil, nil
+		}
+		return newAzureClient(cfg, client), nil
+	case ProviderAnthropic:
+		return nil, fmt.Errorf("anthropic does not provide embeddings; set EMBEDDING_PROVIDER")
+	case ProviderFake:
+		return NewFake(), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider %q", name)
+	}
+}
+
+func normalizeProvider(raw string) string {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "":
+		return ""
+	case "azure", "azure_openai", "azure-openai":
+		return ProviderAzureOpenAI
+	case "openai_compatible", "openai-compatible", "compatible", "vllm", "ollama", "self_hosted", "self-hosted":
+		return ProviderOpenAICompatible
+	case "stub", "fake":
+		return ProviderFake
+	default:
+		return strings.ToLower(strings.TrimSpace(raw))
+	}
+}
+
+func coalesce(v, d string) string {
+	if strings.TrimSpace(v) == "" {
+		return d
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,294 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// openAIClient speaks the OpenAI REST API. It also serves self-hosted
+// OpenAI-compatible servers (vLLM, Ollama, LM Studio) via a custom base URL.
+type openAIClient struct {
+	name    string
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func newOpenAIClient(name, baseURL, apiKey string, client *http.Client) *openAIClient {
+	return &openAIClient{
+		name:    name,
+		baseURL: strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		apiKey:  strings.TrimSpace(apiKey),
+		client:  client,
+	}
+}
+
+func (o *openAIClient) Name() string { return o.name }
+
+func (o *openAIClient) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	var out openAIChatResponse
+	if err := o.post(ctx, "/chat/completions", openAIChatPayload(req), &out); err != nil {
+		return ChatResponse{}, err
+	}
+	return out.toChatResponse(req.Model), nil
+}
+
+func (o *openAIClient) ChatStream(ctx context.Context, req ChatRequest, onDelta func(string) error) (ChatResponse, error) {
+	payload := openAIChatPayload(req)
+	payload["stream"] = true
+	if o.name == ProviderOpenAI {
+		// Self-hosted servers may reject stream_options; their usage is
+		// estimated by the caller instead.
+		payload["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	httpReq, err := o.newRequest(ctx, "/chat/completions", payload)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	return streamOpenAIChat(o.client, httpReq, o.name, req.Model, onDelta)
+}
+
+func (o *openAIClient) Embed(ctx context.Context, req EmbeddingRequest) (EmbeddingResponse, error) {
+	payload := map[string]interface{}{"model": req.Model, "input": req.Input}
+	if req.Dimensions > 0 {
+		payload["dimensions"] = req.Dimensions
+	}
+	var out openAIEmbeddingResponse
+	if err := o.post(ctx, "/embeddings", payload, &out); err != nil {
+		return EmbeddingResponse{}, err
+	}
+	return out.toEmbeddingResponse(req.Model), nil
+}
+
+func (o *openAIClient) EmbedBatch(ctx context.Context, req EmbeddingBatchRequest) (EmbeddingBatchResponse, error) {
+	payload := map[string]interface{}{"model": req.Model, "input": req.Inputs}
+	if req.Dimensions > 0 {
+		payload["dimensions"] = req.Dimensions
+	}
+	var out openAIEmbeddingResponse
+	if err := o.post(ctx, "/embeddings", payload, &out); err != nil {
+		return EmbeddingBatchResponse{}, err
+	}
+	return out.toBatchResponse(req.Model, len(req.Inputs))
+}
+
+func (o *openAIClient) post(ctx context.Context, path string, payload interface{}, out interface{}) error {
+	req, err := o.newRequest(ctx, path, payload)
+	if err != nil {
+		return err
+	}
+	return doJSON(o.client, req, o.name, out)
+}
+
+func (o *openAIClient) newRequest(ctx context.Context, path string, payload interface{}) (*http.Request, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// ── Shared OpenAI wire format (also used by Azure OpenAI) ──────────────────────
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+func openAIMessages(messages []Message) []openAIMessage {
+	out := make([]openAIMessage, 0, len(messages))
+	for _, m := range messages {
+		msg := openAIMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			tc := openAIToolCall{ID: call.ID, Type: "function"}
+			tc.Function.Name, tc.Function.Arguments = call.Name, call.Arguments
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+func openAITools(tools []Tool) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(tools))
+	for _, t := range tools {
+		out = append(out, map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  t.Parameters,
+			},
+		})
+	}
+	return out
+}
+
+func openAIChatPayload(req ChatRequest) map[string]interface{} {
+	payload := map[string]interface{}{
+		"messages": openAIMessages(req.Messages),
+	}
+	if len(req.Tools) > 0 {
+		payload["tools"] = openAITools(req.Tools)
+	}
+	if strings.TrimSpace(req.Model) != "" {
+		payload["model"] = req.Model
+	}
+	if req.Temperature != nil {
+		payload["temperature"] = *req.Temperature
+	}
+	if req.MaxTokens > 0 {
+		payload["max_tokens"] = req.MaxTokens
+	}
+	return payload
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (u openAIUsage) toUsage() Usage {
+	return Usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens}
+}
+
+type openAIChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage openAIUsage `json:"usage"`
+}
+
+func (r openAIChatResponse) toChatResponse(requestedModel string) ChatResponse {
+	out := ChatResponse{Model: coalesce(r.Model, requestedModel), Usage: r.Usage.toUsage()}
+	if len(r.Choices) > 0 {
+		out.Content = strings.TrimSpace(r.Choices[0].Message.Content)
+		for _, tc := range r.Choices[0].Message.ToolCalls {
+			out.ToolCalls = append(out.ToolCalls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Arguments: tc.Function.Arguments})
+		}
+	}
+	return out
+}
+
+// streamOpenAIChat consumes a chat-completions stream ("data: {...}" chunks
+// terminated by "data: [DONE]"). With include_usage the last chunk before
+// [DONE] carries the usage and no choices.
+func streamOpenAIChat(client *http.Client, req *http.Request, provider, requestedModel string, onDelta func(string) error) (ChatResponse, error) {
+	var sb strings.Builder
+	var usage Usage
+	model := requestedModel
+	err := doStream(client, req, provider, func(_, data string) error {
+		if strings.TrimSpace(data) == "[DONE]" {
+			return errStreamDone
+		}
+		var chunk struct {
+			Model   string `json:"model"`
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *openAIUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return err
+		}
+		model = coalesce(chunk.Model, model)
+		if chunk.Usage != nil {
+			usage = chunk.Usage.toUsage()
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			return nil
+		}
+		delta := chunk.Choices[0].Delta.Content
+		sb.WriteString(delta)
+		return onDelta(delta)
+	})
+	return ChatResponse{Content: strings.TrimSpace(sb.String()), Model: model, Usage: usage}, err
+}
+
+type openAIEmbeddingResponse struct {
+	Model string `json:"model"`
+	Data  []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Usage openAIUsage `json:"usage"`
+}
+
+func (r openAIEmbeddingResponse) toEmbeddingResponse(requestedModel string) EmbeddingResponse {
+	out := EmbeddingResponse{Model: coalesce(r.Model, requestedModel), Usage: r.Usage.toUsage()}
+	if len(r.Data) > 0 {
+		out.Vector = r.Data[0].Embedding
+	}
+	return out
+}
+
+// toBatchResponse places each embedding at its "index", since the API does
+// not promise to return data in input order.
+func (r openAIEmbeddingResponse) toBatchResponse(requestedModel string, inputs int) (EmbeddingBatchResponse, error) {
+	out := EmbeddingBatchResponse{Model: coalesce(r.Model, requestedModel), Vectors: make([][]float64, inputs), Usage: r.Usage.toUsage()}
+	for _, d := range r.Data {
+		if d.Index < 0 || d.Index >= inputs {
+			return EmbeddingBatchResponse{}, fmt.Errorf("embedding index %d out of range for %d inputs", d.Index, inputs)
+		}
+		out.Vectors[d.Index] = d.Embedding
+	}
+	return out, nil
+}
+
+// doJSON executes req and decodes a JSON body into out, turning non-2xx
+// responses into errors that carry a trimmed copy of the response body.
+func doJSON(client *http.Client, req *http.Request, provider string, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &StatusError{Provider: provider, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// StatusError reports a non-success HTTP status from a provider.
+type StatusError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s status %d", e.Provider, e.StatusCode)
+}
@@ -0,0 +1,73 @@
+package llm
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// errStreamDone lets an event handler end a stream early without error.
+var errStreamDone = errors.New("stream done")
+
+// doStream executes req and feeds each server-sent event to onEvent until the
+// body ends or onEvent returns an error. Non-2xx responses become StatusErrors.
+func doStream(client *http.Client, req *http.Request, provider string, onEvent func(event, data string) error) error {
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &StatusError{Provider: provider, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	if err := readSSE(resp.Body, onEvent); err != nil && !errors.Is(err, errStreamDone) {
+		return err
+	}
+	return nil
+}
+
+// readSSE parses a text/event-stream body. Multi-line data fields are joined
+// with "\n" as the spec requires; comments and unknown fields are ignored.
+func readSSE(r io.Reader, onEvent func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var event string
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			event = ""
+			return nil
+		}
+		err := onEvent(event, strings.Join(data, "\n"))
+		event, data = "", data[:0]
+		return err
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if err := dispatch(); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return dispatch()
+}
@@ -0,0 +1,107 @@
+package vectorstore
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+)
+
+// memory is a process-local store for offline evaluation and tests. It does
+// exact cosine search and forgets everything on restart.
+type memory struct {
+	mu         sync.RWMutex
+	namespaces map[string]map[string]Record
+	dimension  int
+}
+
+func newMemory(dimension int) *memory {
+	return &memory{namespaces: map[string]map[string]Record{}, dimension: dimension}
+}
+
+func (m *memory) Name() string { return BackendMemory }
+
+func (m *memory) Dimension(context.Context) (int, error) { return m.dimension, nil }
+
+func (m *memory) Upsert(_ context.Context, namespace string, records []Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ns := m.namespaces[namespace]
+	if ns == nil {
+		ns = map[string]Record{}
+		m.namespaces[namespace] = ns
+	}
+	for _, r := range records {
+		ns[r.ID] = r
+	}
+	return nil
+}
+
+func (m *memory) DeleteBySource(_ context.Context, namespace, sourceType, sourceKey string) error {
+	m.deleteWhere(namespace, func(r Record) bool { return r.SourceType == sourceType && r.SourceKey == sourceKey })
+	return nil
+}
+
+func (m *memory) DeleteByURL(_ context.Context, namespace, url string) error {
+	m.deleteWhere(namespace, func(r Record) bool { return r.URL == url })
+	return nil
+}
+
+func (m *memory) DeleteByPage(_ context.Context, namespace, sourceType, sourceKey, url string) error {
+	m.deleteWhere(namespace, func(r Record) bool {
+		return r.SourceType == sourceType && r.SourceKey == sourceKey && r.URL == url
+	})
+	return nil
+}
+
+func (m *memory) DeleteNamespace(_ context.Context, namespace string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.namespaces, namespace)
+	return nil
+}
+
+func (m *memory) deleteWhere(namespace string, match func(Record) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, r := range m.namespaces[namespace] {
+		if match(r) {
+			delete(m.namespaces[namespace], id)
+		}
+	}
+}
+
+func (m *memory) Query(_ context.Context, namespace string, vector []float64, topK int) ([]Match, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	matches := make([]Match, 0, len(m.namespaces[namespace]))
+	for id, r := range m.namespaces[namespace] {
+		if len(r.Values) != len(vector) {
+			continue
+		}
+		matches = append(matches, Match{ID: id, Score: cosine(vector, r.Values), Metadata: r.Metadata(namespace)})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if topK > 0 && len(matches) > topK {
+		matches = matches[:topK]
+	}
+	return matches, nil
+}
+
+func cosine(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
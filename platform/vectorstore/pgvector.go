@@ -0,0 +1,136 @@
+package vectorstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const defaultPgVectorDimension = 1536
+
+// pgVector stores embeddings in the rag_vectors table (see migration
+// 20261016_030_pgvector_store.sql). The embedding column is untyped so the
+// dimension can follow the configured embedding model; queries are exact
+// cosine scans scoped to one namespace.
+type pgVector struct {
+	db        *sql.DB
+	dimension int
+}
+
+func newPgVector(ctx context.Context, db *sql.DB, dimension int) (*pgVector, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass('rag_vectors') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("rag_vectors table not found; install the pgvector extension and apply migration 20261016_030_pgvector_store.sql")
+	}
+	if dimension <= 0 {
+		dimension = defaultPgVectorDimension
+	}
+	return &pgVector{db: db, dimension: dimension}, nil
+}
+
+func (p *pgVector) Name() string { return BackendPgVector }
+
+func (p *pgVector) Dimension(context.Context) (int, error) {
+	return p.dimension, nil
+}
+
+func (p *pgVector) Upsert(ctx context.Context, namespace string, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO rag_vectors
+		(namespace,id,user_id,url,page_title,heading_path,chunk_index,content,widget_key,source_type,source_key,
+			description,canonical_url,language,embedding)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15::vector)
+		ON CONFLICT (namespace,id) DO UPDATE SET
+			url=EXCLUDED.url,page_title=EXCLUDED.page_title,heading_path=EXCLUDED.heading_path,chunk_index=EXCLUDED.chunk_index,
+			content=EXCLUDED.content,widget_key=EXCLUDED.widget_key,source_type=EXCLUDED.source_type,
+			source_key=EXCLUDED.source_key,description=EXCLUDED.description,canonical_url=EXCLUDED.canonical_url,
+			language=EXCLUDED.language,embedding=EXCLUDED.embedding,updated_at=CURRENT_TIMESTAMP`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range records {
+		if _, err := stmt.ExecContext(ctx, namespace, r.ID, r.UserID, r.URL, r.PageTitle, r.HeadingPath, r.ChunkIndex,
+			r.Text, r.WidgetKey, r.SourceType, r.SourceKey, r.Description, r.CanonicalURL, r.Language, vectorLiteral(r.Values)); err != nil {
+			return fmt.Errorf("pgvector upsert %s: %w", r.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *pgVector) DeleteBySource(ctx context.Context, namespace, sourceType, sourceKey string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM rag_vectors WHERE namespace=$1 AND source_type=$2 AND source_key=$3`,
+		namespace, sourceType, sourceKey)
+	return err
+}
+
+func (p *pgVector) DeleteByURL(ctx context.Context, namespace, sourceURL string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM rag_vectors WHERE namespace=$1 AND url=$2`, namespace, sourceURL)
+	return err
+}
+
+func (p *pgVector) DeleteByPage(ctx context.Context, namespace, sourceType, sourceKey, sourceURL string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM rag_vectors WHERE namespace=$1 AND source_type=$2 AND source_key=$3 AND url=$4`,
+		namespace, sourceType, sourceKey, sourceURL)
+	return err
+}
+
+func (p *pgVector) DeleteNamespace(ctx context.Context, namespace string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM rag_vectors WHERE namespace=$1`, namespace)
+	return err
+}
+
+func (p *pgVector) Query(ctx context.Context, namespace string, vector []float64, topK int) ([]Match, error) {
+	if len(vector) == 0 {
+		return nil, nil
+	}
+	rows, err := p.db.QueryContext(ctx, `SELECT id,1-(embedding <=> $2::vector) AS score,user_id::text,url,page_title,
+			heading_path,chunk_index,content,widget_key,source_type,source_key,description,canonical_url,language
+		FROM rag_vectors
+		WHERE namespace=$1 AND vector_dims(embedding)=vector_dims($2::vector)
+		ORDER BY embedding <=> $2::vector
+		LIMIT $3`, namespace, vectorLiteral(vector), topK)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	matches := make([]Match, 0, topK)
+	for rows.Next() {
+		var m Match
+		var r Record
+		if err := rows.Scan(&m.ID, &m.Score, &r.UserID, &r.URL, &r.PageTitle, &r.HeadingPath, &r.ChunkIndex, &r.Text,
+			&r.WidgetKey, &r.SourceType, &r.SourceKey, &r.Description, &r.CanonicalURL, &r.Language); err != nil {
+			return nil, err
+		}
+		m.Metadata = r.Metadata(namespace)
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// vectorLiteral renders v in pgvector's text input format, e.g. "[0.1,0.2]".
+func vectorLiteral(v []float64) string {
+	var sb strings.Builder
+	sb.Grow(len(v) * 10)
+	sb.WriteByte('[')
+	for i, f := range v {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.FormatFloat(f, 'g', -1, 32))
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
@@ -0,0 +1,316 @@
+package vectorstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type pinecone struct {
+	apiKey    string
+	indexName string
+	host      string
+	dimension int
+	client    *http.Client
+	logger    *slog.Logger
+}
+
+type pineconeIndexInfo struct {
+	Host      string
+	Dimension int
+}
+
+func newPinecone(cfg Config, client *http.Client, logger *slog.Logger) *pinecone {
+	return &pinecone{
+		apiKey:    strings.TrimSpace(cfg.PineconeAPIKey),
+		indexName: strings.TrimSpace(cfg.PineconeIndexName),
+		host:      normalizePineconeHost(cfg.PineconeHost),
+		dimension: cfg.PineconeDimension,
+		client:    client,
+		logger:    logger,
+	}
+}
+
+func (p *pinecone) Name() string { return BackendPinecone }
+
+func (p *pinecone) Dimension(ctx context.Context) (int, error) {
+	info, err := p.indexInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return info.Dimension, nil
+}
+
+func (p *pinecone) indexInfo(ctx context.Context) (pineconeIndexInfo, error) {
+	info := pineconeIndexInfo{
+		Host:      p.host,
+		Dimension: p.dimension,
+	}
+
+	if p.indexName == "" {
+		if info.Host == "" {
+			return info, errors.New("missing pinecone index configuration")
+		}
+		return info, nil
+	}
+
+	resolved, err := p.resolveIndexInfoFromControlPlane(ctx)
+	if err != nil {
+		if info.Host != "" {
+			p.logger.Warn("pinecone index metadata resolve failed; using explicit host override",
+				"index", p.indexName,
+				"error", err,
+			)
+			return info, nil
+		}
+		return info, err
+	}
+
+	if info.Host == "" {
+		info.Host = resolved.Host
+	}
+	if info.Dimension <= 0 {
+		info.Dimension = resolved.Dimension
+	}
+
+	return info, nil
+}
+
+func normalizePineconeHost(raw string) string {
+	host := strings.TrimSpace(raw)
+	if host == "" {
+		return ""
+	}
+	if strings.HasPrefix(host, "https://") || strings.HasPrefix(host, "http://") {
+		return host
+	}
+	return "https://" + host
+}
+
+func (p *pinecone) resolveIndexInfoFromControlPlane(ctx context.Context) (pineconeIndexInfo, error) {
+	var info pineconeIndexInfo
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	endpoint := "https://api.pinecone.io/indexes/" + url.PathEscape(p.indexName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return info, err
+	}
+	req.Header.Set("Api-Key", p.apiKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		return info, fmt.Errorf("describe index status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var out struct {
+		Host      string `json:"host"`
+		Dimension int    `json:"dimension"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return info, err
+	}
+	host := normalizePineconeHost(out.Host)
+	if host == "" {
+		return info, errors.New("empty host from pinecone control api")
+	}
+	info.Host = host
+	info.Dimension = out.Dimension
+	return info, nil
+}
+
+// resolvedHost returns the data-plane host, or "" when it cannot be resolved
+// and the caller should treat the operation as a no-op.
+func (p *pinecone) resolvedHost(ctx context.Context) (string, error) {
+	info, err := p.indexInfo(ctx)
+	if err != nil {
+		return "", err
+	}
+	return info.Host, nil
+}
+
+func (p *pinecone) Upsert(ctx context.Context, namespace string, records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	host, err := p.resolvedHost(ctx)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return errors.New("pinecone host could not be resolved; set PINECONE_HOST or verify PINECONE_INDEX_NAME")
+	}
+
+	vectors := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		vectors = append(vectors, map[string]interface{}{
+			"id":       record.ID,
+			"values":   record.Values,
+			"metadata": record.Metadata(namespace),
+		})
+	}
+	payload := map[string]interface{}{
+		"namespace": namespace,
+		"vectors":   vectors,
+	}
+	resp, err := p.post(ctx, host+"/vectors/upsert", payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("pinecone upsert status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (p *pinecone) DeleteNamespace(ctx context.Context, namespace string) error {
+	host, err := p.resolvedHost(ctx)
+	if err != nil || host == "" {
+		return err
+	}
+	return p.delete(ctx, host, map[string]interface{}{
+		"namespace": namespace,
+		"deleteAll": true,
+	})
+}
+
+func (p *pinecone) DeleteBySource(ctx context.Context, namespace, sourceType, sourceKey string) error {
+	host, err := p.resolvedHost(ctx)
+	if err != nil || host == "" {
+		return err
+	}
+	return p.delete(ctx, host, map[string]interface{}{
+		"namespace": namespace,
+		"filter": map[string]interface{}{
+			"sourceType": map[string]interface{}{"$eq": sourceType},
+			"sourceKey":  map[string]interface{}{"$eq": sourceKey},
+		},
+	})
+}
+
+func (p *pinecone) DeleteByURL(ctx context.Context, namespace, sourceURL string) error {
+	host, err := p.resolvedHost(ctx)
+	if err != nil || host == "" {
+		return err
+	}
+	return p.delete(ctx, host, map[string]interface{}{
+		"namespace": namespace,
+		"filter": map[string]interface{}{
+			"url": map[string]interface{}{"$eq": sourceURL},
+		},
+	})
+}
+
+func (p *pinecone) DeleteByPage(ctx context.Context, namespace, sourceType, sourceKey, sourceURL string) error {
+	host, err := p.resolvedHost(ctx)
+	if err != nil || host == "" {
+		return err
+	}
+	return p.delete(ctx, host, map[string]interface{}{
+		"namespace": namespace,
+		"filter": map[string]interface{}{
+			"sourceType": map[string]interface{}{"$eq": sourceType},
+			"sourceKey":  map[string]interface{}{"$eq": sourceKey},
+			"url":        map[string]interface{}{"$eq": sourceURL},
+		},
+	})
+}
+
+func (p *pinecone) delete(ctx context.Context, host string, payload map[string]interface{}) error {
+	resp, err := p.post(ctx, host+"/vectors/delete", payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		trimmed := strings.TrimSpace(string(body))
+		if resp.StatusCode == http.StatusNotFound {
+			lower := strings.ToLower(trimmed)
+			if strings.Contains(lower, "namespace not found") || strings.Contains(lower, `"code":5`) {
+				return nil
+			}
+		}
+		return fmt.Errorf("pinecone namespace delete status %d: %s", resp.StatusCode, trimmed)
+	}
+	return nil
+}
+
+func (p *pinecone) Query(ctx context.Context, namespace string, vector []float64, topK int) ([]Match, error) {
+	host, err := p.resolvedHost(ctx)
+	if err != nil || host == "" {
+		return nil, err
+	}
+	payload := map[string]interface{}{
+		"namespace":       namespace,
+		"vector":          vector,
+		"topK":            topK,
+		"includeMetadata": true,
+	}
+	resp, err := p.post(ctx, host+"/query", payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("pinecone query status %d", resp.StatusCode)
+	}
+	var out struct {
+		Matches []Match `json:"matches"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out.Matches, nil
+}
+
+func (p *pinecone) post(ctx context.Context, endpoint string, payload interface{}) (*http.Response, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Api-Key", p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the request timeout once the caller has finished
+// reading the response body.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
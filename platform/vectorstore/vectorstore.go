@@ -0,0 +1,125 @@
+package vectorstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const (
+	BackendPinecone = "pinecone"
+	BackendPgVector = "pgvector"
+	BackendMemory   = "memory"
+)
+
+// Record is a single embedded chunk. The descriptive fields are stored as
+// metadata and returned with query matches. HeadingPath is the section the
+// chunk was taken from, e.g. "Pricing > Pro". Description, CanonicalURL and
+// Language come from the page's head and are empty for documents.
+type Record struct {
+	ID           string
+	Values       []float64
+	UserID       string
+	URL          string
+	PageTitle    string
+	HeadingPath  string
+	Text         string
+	ChunkIndex   int
+	WidgetKey    string
+	SourceType   string
+	SourceKey    string
+	Description  string
+	CanonicalURL string
+	Language     string
+}
+
+// Metadata returns the record fields in the shape handlers read from matches.
+func (r Record) Metadata(namespace string) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":      r.UserID,
+		"url":          r.URL,
+		"pageTitle":    r.PageTitle,
+		"headingPath":  r.HeadingPath,
+		"chunkIndex":   r.ChunkIndex,
+		"text":         r.Text,
+		"widgetKey":    r.WidgetKey,
+		"sourceType":   r.SourceType,
+		"sourceKey":    r.SourceKey,
+		"description":  r.Description,
+		"canonicalUrl": r.CanonicalURL,
+		"language":     r.Language,
+		"namespaceId":  namespace,
+	}
+}
+
+// Match is a query hit. Score is cosine similarity (higher is closer).
+type Match struct {
+	ID       string                 `json:"id"`
+	Score    float64                `json:"score"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// Store is the vector index used for retrieval. Every tenant lives in its own
+// namespace; implementations must never return records across namespaces.
+type Store interface {
+	Name() string
+	// Dimension reports the vector size the index expects, or 0 when the
+	// embedding model default should be used.
+	Dimension(ctx context.Context) (int, error)
+	Upsert(ctx context.Context, namespace string, records []Record) error
+	DeleteBySource(ctx context.Context, namespace, sourceType, sourceKey string) error
+	DeleteByURL(ctx context.Context, namespace, url string) error
+	// DeleteByPage removes one page's chunks from one source, leaving the
+	// same URL indexed under other sources alone.
+	DeleteByPage(ctx context.Context, namespace, sourceType, sourceKey, url string) error
+	DeleteNamespace(ctx context.Context, namespace string) error
+	Query(ctx context.Context, namespace string, vector []float64, topK int) ([]Match, error)
+}
+
+type Config struct {
+	Backend string
+
+	PineconeAPIKey    string
+	PineconeIndexName string
+	PineconeHost      string
+	PineconeDimension int
+
+	DB        *sql.DB
+	Dimension int
+
+	HTTPClient *http.Client
+	Logger     *slog.Logger
+}
+
+// New builds the configured store. It returns a nil Store and a nil error
+// when the selected backend has no credentials, so retrieval is disabled
+// rather than failing every request.
+func New(ctx context.Context, cfg Config) (Store, error) {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{}
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "", BackendPinecone:
+		if strings.TrimSpace(cfg.PineconeAPIKey) == "" {
+			return nil, nil
+		}
+		return newPinecone(cfg, client, logger), nil
+	case BackendPgVector, "postgres":
+		if cfg.DB == nil {
+			return nil, fmt.Errorf("pgvector store requires a database pool")
+		}
+		return newPgVector(ctx, cfg.DB, cfg.Dimension)
+	case BackendMemory:
+		return newMemory(cfg.Dimension), nil
+	default:
+		return nil, fmt.Errorf("unknown vector store %q", cfg.Backend)
+	}
+}
@@ -394,6 +394,11 @@ export class KonvoqChatWidget extends HTMLElement {
 					updateTypingToMessage(this.elements.messagesContainer, typingWrapper, assembled, (value) => this.renderMessageContent(value, "bot"));
 					return;
 				}
+				if (payload.type === "replace" && typeof payload.response === "string") {
+					assembled = payload.response;
+					updateTypingToMessage(this.elements.messagesContainer, typingWrapper, assembled, (value) => this.renderMessageContent(value, "bot"));
+					return;
+				}
 				if (payload.type === "done") {
 					donePayload = payload;
 					return;
@@ -25,3 +25,19 @@ func ValidateURL(rawURL string) bool {
 	}
 	return u.Scheme == "http" || u.Scheme == "https"
 }
+
+// ValidatePhone reports whether phone looks like a phone number: 7 to 15
+// digits (the E.164 maximum) with only the usual separators between them.
+func ValidatePhone(phone string) bool {
+	digits := 0
+	for _, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case strings.ContainsRune("+-(). /", r):
+		default:
+			return false
+		}
+	}
+	return digits >= 7 && digits <= 15
+}